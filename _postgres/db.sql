@@ -9,3 +9,127 @@ CREATE TABLE IF NOT EXISTS "users"
     birthday TIMESTAMP,
     join_date TIMESTAMP NOT NULL
 ) WITH (oids = false);
+
+CREATE TABLE IF NOT EXISTS "api_keys"
+(
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    prefix VARCHAR(16) NOT NULL,
+    scopes TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS users_birthday_idx ON users ((birthday::date));
+CREATE INDEX IF NOT EXISTS users_full_name_idx ON users (lower(surname), lower(name));
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS merged_into INTEGER REFERENCES users (id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS "user_history"
+(
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    event VARCHAR(50) NOT NULL,
+    details JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS user_history_user_id_idx ON user_history (user_id);
+
+CREATE TABLE IF NOT EXISTS "jobs"
+(
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    params JSONB NOT NULL,
+    payload BYTEA,
+    total_rows INTEGER NOT NULL DEFAULT 0,
+    processed_rows INTEGER NOT NULL DEFAULT 0,
+    succeeded_rows INTEGER NOT NULL DEFAULT 0,
+    failed_rows INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP NOT NULL,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS "job_row_errors"
+(
+    job_id INTEGER NOT NULL REFERENCES jobs (id) ON DELETE CASCADE,
+    row_number INTEGER NOT NULL,
+    errors JSONB NOT NULL,
+    PRIMARY KEY (job_id, row_number)
+);
+
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS max_attempts INTEGER NOT NULL DEFAULT 5;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS run_at TIMESTAMP NOT NULL DEFAULT now();
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS locked_by VARCHAR(100);
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS cancel_requested BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS jobs_pending_run_at_idx ON jobs (run_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS jobs_running_locked_until_idx ON jobs (locked_until) WHERE status = 'running';
+
+-- Rows that existed before the column get the time of the migration, so the
+-- retention of already deleted users starts counting from it.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMP;
+UPDATE users SET status_changed_at = now() WHERE status_changed_at IS NULL;
+ALTER TABLE users ALTER COLUMN status_changed_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS users_deleted_status_changed_at_idx ON users (status_changed_at) WHERE status = 'deleted';
+
+CREATE TABLE IF NOT EXISTS "scheduled_tasks"
+(
+    name VARCHAR(100) PRIMARY KEY,
+    last_run_at TIMESTAMP NOT NULL
+);
+
+-- A ban without banned_until lasts until the user is unbanned.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS banned_until TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS ban_reason TEXT;
+
+CREATE INDEX IF NOT EXISTS users_banned_until_idx ON users (banned_until) WHERE status = 'banned' AND banned_until IS NOT NULL;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS users_attributes_idx ON users USING gin (attributes jsonb_path_ops);
+
+-- Every unique attribute gets its own index users_attr_<name>_key, it is
+-- created together with the attribute.
+CREATE TABLE IF NOT EXISTS "user_attributes"
+(
+    name VARCHAR(40) PRIMARY KEY,
+    type VARCHAR(20) NOT NULL,
+    required BOOLEAN NOT NULL DEFAULT false,
+    is_unique BOOLEAN NOT NULL DEFAULT false,
+    rules JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Deleting a survivor must not delete the users merged into it, databases
+-- created before version 7 have the key with ON DELETE CASCADE.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_merged_into_fkey,
+    ADD CONSTRAINT users_merged_into_fkey FOREIGN KEY (merged_into) REFERENCES users (id) ON DELETE SET NULL;
+
+-- The purge skips users that others were merged into.
+CREATE INDEX IF NOT EXISTS users_merged_into_idx ON users (merged_into) WHERE merged_into IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS "schema_migrations"
+(
+    version INTEGER PRIMARY KEY,
+    applied_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+INSERT INTO schema_migrations (version) VALUES (1) ON CONFLICT DO NOTHING;
+INSERT INTO schema_migrations (version) VALUES (2) ON CONFLICT DO NOTHING;
+INSERT INTO schema_migrations (version) VALUES (3) ON CONFLICT DO NOTHING;
+INSERT INTO schema_migrations (version) VALUES (4) ON CONFLICT DO NOTHING;
+INSERT INTO schema_migrations (version) VALUES (5) ON CONFLICT DO NOTHING;
+INSERT INTO schema_migrations (version) VALUES (6) ON CONFLICT DO NOTHING;
+INSERT INTO schema_migrations (version) VALUES (7) ON CONFLICT DO NOTHING;
+INSERT INTO schema_migrations (version) VALUES (8) ON CONFLICT DO NOTHING;
@@ -1,22 +1,58 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/gomodule/redigo/redis"
 	"github.com/gorilla/mux"
 	"github.com/ivanov-nikolay/user-api/dbinit"
+	"github.com/ivanov-nikolay/user-api/internal/auth"
+	"github.com/ivanov-nikolay/user-api/internal/config"
 	"github.com/ivanov-nikolay/user-api/internal/delivery"
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/health"
+	"github.com/ivanov-nikolay/user-api/internal/idempotency"
+	"github.com/ivanov-nikolay/user-api/internal/jobs"
+	"github.com/ivanov-nikolay/user-api/internal/metrics"
 	"github.com/ivanov-nikolay/user-api/internal/middleware"
+	"github.com/ivanov-nikolay/user-api/internal/ratelimit"
+	"github.com/ivanov-nikolay/user-api/internal/retry"
+	"github.com/ivanov-nikolay/user-api/internal/scheduler"
 	"github.com/ivanov-nikolay/user-api/internal/storage"
+	"github.com/ivanov-nikolay/user-api/internal/tracing"
 	"github.com/ivanov-nikolay/user-api/internal/usecase"
-	_ "github.com/jackc/pgx/stdlib"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 )
 
 func main() {
+	configFile := flag.String("config", os.Getenv("configFile"), "path to a YAML config file")
+	printConfig := flag.Bool("print-config", false, "print the effective config with secrets redacted and exit")
+	flag.Parse()
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+		os.Exit(2)
+	}
+	if *printConfig {
+		out, err := cfg.YAML()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error in printing config: %s\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(out)
+		return
+	}
+
 	zapLogger, err := zap.NewProduction()
 	if err != nil {
 		log.Printf("error in logger start")
@@ -29,55 +65,278 @@ func main() {
 			log.Printf("error in logger sync")
 		}
 	}()
-	pgxDB, err := dbinit.GetPostgres()
-	fmt.Println("eeee")
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter, "user-api")
+	if err != nil {
+		logger.Errorf("error in tracing setup: %s", err)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
+	backoff := retry.Backoff{Initial: cfg.Connect.InitialBackoff, Max: cfg.Connect.MaxBackoff}
+	pgxDB, err := dbinit.GetPostgres(ctx, cfg.Connect.Timeout, cfg.Postgres, backoff, storage.PrepareStatements, storage.StatementTracer{})
 	if err != nil {
 		logger.Errorf("error in connection to postgres: %s", err)
 		return
 	}
 	logger.Infof("connected to postgres")
-	defer func() {
-		err = pgxDB.Close()
-		if err != nil {
-			logger.Errorf("error in close connection to mysql: %s", err)
-		}
-	}()
+	metrics.RegisterPool(pgxDB)
 
-	redisConn, err := dbinit.GetRedis()
+	replicas, err := dbinit.GetReplicas(ctx, cfg.Postgres, storage.PrepareStatements, storage.StatementTracer{})
+	if err != nil {
+		logger.Errorf("error in configuring read replicas: %s", err)
+		return
+	}
+
+	redisPool, err := dbinit.GetRedis(ctx, cfg.Redis, backoff, logger)
 	if err != nil {
 		logger.Infof("error on connection to redis: %s", err.Error())
+	} else {
+		logger.Infof("connected to redis")
 	}
-	defer func() {
-		err = redisConn.Close()
-		if err != nil {
-			logger.Infof("error on redis close: %s", err.Error())
-		}
-	}()
-	logger.Infof("connected to redis")
 
-	s := storage.New(pgxDB, redisConn)
+	s := storage.New(pgxDB, redisPool)
+	s.UseReplicas(ctx, replicas, cfg.Postgres.ReplicaCheckInterval)
 	u := usecase.New(s)
 	h := delivery.New(u, logger)
+	ku := usecase.NewAPIKeyUseCase(s)
+	kh := delivery.NewAPIKeyHandler(ku, logger)
+	ah := delivery.NewAttributeHandler(usecase.NewAttributeUseCase(s), logger)
+	queue := jobs.New(s, jobs.Options{
+		Workers:      cfg.Jobs.Workers,
+		PollInterval: cfg.Jobs.PollInterval,
+		Lease:        cfg.Jobs.Lease,
+		MaxAttempts:  cfg.Jobs.MaxAttempts,
+		Backoff:      retry.Backoff{Initial: cfg.Jobs.RetryInitialBackoff, Max: cfg.Jobs.RetryMaxBackoff},
+	}, logger)
+	iu := usecase.NewImportUseCase(s, queue)
+	queue.Register(entity.JobKindImportUsers, iu.ImportUsersJob)
+	queue.Start()
+	ih := delivery.NewImportHandler(iu, logger)
+	ju := usecase.NewJobUseCase(s, queue)
+	jh := delivery.NewJobHandler(ju, logger)
 
-	router := mux.NewRouter()
+	idempotencyStore := idempotency.NewRedisStore(redisPool)
+	sched, err := newScheduler(cfg.Scheduler, s, usecase.NewMaintenanceUseCase(s), idempotencyStore, logger)
+	if err != nil {
+		logger.Errorf("error in scheduler setup: %s", err)
+		return
+	}
+	sched.Start()
 
-	router.HandleFunc("/user", h.CreateUserHandler).Methods(http.MethodPost)
+	checker := health.New(cfg.Health.ReadinessTimeout,
+		health.Check{Name: "postgres", Critical: true, Run: s.PingPostgresStorage},
+		health.Check{Name: "schema", Critical: true, Run: s.CheckSchemaStorage},
+		health.Check{Name: "redis", Critical: false, Run: s.PingRedisStorage},
+	)
+	hh := delivery.NewHealthHandler(checker, logger)
+
+	rootRouter := mux.NewRouter()
+	rootRouter.HandleFunc("/healthz", hh.LivenessHandler).Methods(http.MethodGet)
+	rootRouter.HandleFunc("/readyz", hh.ReadinessHandler).Methods(http.MethodGet)
+	rootRouter.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+
+	router := rootRouter.NewRoute().Subrouter()
+	router.Use(middleware.Tracing)
+	router.Use(middleware.Metrics)
+	router.Use(middleware.Recover(logger))
+	router.Use(middleware.Consistency)
+
+	idempotent := middleware.Idempotency(
+		idempotencyStore,
+		cfg.Idempotency.Window,
+		logger,
+	)
+	router.Handle("/user", idempotent(http.HandlerFunc(h.CreateUserHandler))).Methods(http.MethodPost)
 	router.HandleFunc("/user/{USER_ID}", h.GetUserByIDHandlerID).Methods(http.MethodGet)
 	router.HandleFunc("/user", h.UpdateUserHandler).Methods(http.MethodPut)
 	router.HandleFunc("/user/{USER_ID}", h.DeleteUserHandler).Methods(http.MethodDelete)
-	router.HandleFunc("/users", h.SearchUsersHandler).Methods(http.MethodGet)
+	router.HandleFunc("/user/{USER_ID}/ban", h.BanUserHandler).Methods(http.MethodPost)
+	router.HandleFunc("/user/{USER_ID}/unban", h.UnbanUserHandler).Methods(http.MethodPost)
+	router.HandleFunc("/user/{USER_ID}/activate", h.ActivateUserHandler).Methods(http.MethodPost)
+	searchTimeout := middleware.QueryTimeout(cfg.Search.StatementTimeout)
+	router.Handle("/users", searchTimeout(http.HandlerFunc(h.SearchUsersHandler))).Methods(http.MethodGet)
+	router.Handle("/users/duplicates", searchTimeout(http.HandlerFunc(h.FindDuplicatesHandler))).Methods(http.MethodGet)
+	exportTimeout := middleware.QueryTimeout(cfg.Search.ExportStatementTimeout)
+	router.Handle("/users/export", exportTimeout(http.HandlerFunc(h.ExportUsersHandler))).Methods(http.MethodGet)
+	router.HandleFunc("/users/merge", h.MergeUsersHandler).Methods(http.MethodPost)
+	router.HandleFunc("/users/import", ih.ImportUsersHandler).Methods(http.MethodPost)
 
-	aclRouter := middleware.AccessLog(router, logger)
+	router.HandleFunc("/jobs", jh.ListJobsHandler).Methods(http.MethodGet)
+	router.HandleFunc("/jobs/{JOB_ID}", jh.GetJobHandler).Methods(http.MethodGet)
+	router.HandleFunc("/jobs/{JOB_ID}/errors", jh.ExportJobErrorsHandler).Methods(http.MethodGet)
+	router.HandleFunc("/jobs/{JOB_ID}/cancel", jh.CancelJobHandler).Methods(http.MethodPost)
+	router.HandleFunc("/jobs/{JOB_ID}/retry", jh.RetryJobHandler).Methods(http.MethodPost)
 
-	port := os.Getenv("appPort")
+	router.HandleFunc("/attributes", ah.CreateAttributeHandler).Methods(http.MethodPost)
+	router.HandleFunc("/attributes", ah.ListAttributesHandler).Methods(http.MethodGet)
+	router.HandleFunc("/attributes/{NAME}", ah.DeleteAttributeHandler).Methods(http.MethodDelete)
 
-	logger.Infow("starting server",
-		"type", "START",
-		"addr", port,
-	)
-	err = http.ListenAndServe(port, aclRouter)
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimit.Store == "redis" {
+		rateLimitStore = ratelimit.NewRedisStore(redisPool)
+	}
+	ipLimiter := ratelimit.New(rateLimitStore, ratelimit.Limit{
+		Rate:  cfg.RateLimit.IPRate,
+		Burst: cfg.RateLimit.IPBurst,
+	})
+	router.Use(middleware.RateLimitIP(ipLimiter, logger))
+
+	secretFile := cfg.Auth.Secret()
+	jwtSecret, err := secretFile.Secret()
 	if err != nil {
-		logger.Fatalf("errror in server start")
+		logger.Errorf("error in reading jwtSecret: %s", err)
+		return
+	}
+	if jwtSecret != "" {
+		authenticators := []auth.Authenticator{
+			auth.NewJWTAuthenticator(secretFile.Secret),
+			auth.NewAPIKeyAuthenticator(ku),
+		}
+		router.Use(middleware.Authenticate(authenticators, logger))
+
+		// Anyone could issue keys without authorization, so the key routes
+		// exist only with it.
+		router.HandleFunc("/apikeys", kh.CreateAPIKeyHandler).Methods(http.MethodPost)
+		router.HandleFunc("/apikeys", kh.ListAPIKeysHandler).Methods(http.MethodGet)
+		router.HandleFunc("/apikeys/{KEY_ID}", kh.RevokeAPIKeyHandler).Methods(http.MethodDelete)
+	} else {
+		logger.Warnf("jwtSecret is not set, authentication and authorization are disabled, /apikeys is not served")
+	}
+
+	limiter := ratelimit.New(rateLimitStore, ratelimit.Limit{
+		Rate:  cfg.RateLimit.Rate,
+		Burst: cfg.RateLimit.Burst,
+	})
+	limiter.SetRouteLimit(http.MethodGet, "/users", ratelimit.Limit{
+		Rate:  cfg.RateLimit.SearchRate,
+		Burst: cfg.RateLimit.SearchBurst,
+	})
+	router.Use(middleware.RateLimit(limiter, logger))
+
+	if jwtSecret != "" {
+		router.Use(middleware.Authorize(auth.DefaultPolicy(), logger))
+	}
+
+	// The outer Recover covers the routes outside the API router.
+	aclRouter := middleware.AccessLog(middleware.Recover(logger)(rootRouter), logger, middleware.AccessLogOptions{
+		SampleRate: cfg.AccessLog.SampleRate,
+		SkipPaths:  cfg.AccessLog.SkipPaths,
+	})
+
+	port := cfg.HTTP.Addr
+	server := &http.Server{
+		Addr:              port,
+		Handler:           aclRouter,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+		MaxHeaderBytes:    cfg.HTTP.MaxHeaderBytes,
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Infow("starting server",
+			"type", "START",
+			"addr", port,
+		)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serverErr:
+		logger.Errorf("error in server start: %s", err)
+	case <-ctx.Done():
+		logger.Infof("shutdown signal received, draining requests")
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+	shutdown(shutdownCtx, logger, server, sched, queue, s, pgxDB, replicas, redisPool)
+	err = shutdownTracing(shutdownCtx)
+	if err != nil {
+		logger.Errorf("error in flushing traces: %s", err)
+	}
+}
+
+// shutdown stops accepting requests and waits for the in-flight ones, then
+// waits for scheduled tasks, running jobs and background cache writes and
+// only after that closes the connections they use.
+func shutdown(ctx context.Context, logger *zap.SugaredLogger, server *http.Server, sched *scheduler.Scheduler, queue *jobs.Queue, s *storage.DBStorage, pgxDB *pgxpool.Pool, replicas []*pgxpool.Pool, redisPool *redis.Pool) {
+	err := server.Shutdown(ctx)
+	if err != nil {
+		logger.Errorf("error in server shutdown: %s", err)
+	}
+	err = sched.Shutdown(ctx)
+	if err != nil {
+		logger.Errorf("error in waiting for scheduled tasks: %s", err)
+	}
+	err = queue.Shutdown(ctx)
+	if err != nil {
+		logger.Errorf("error in waiting for jobs, they were given back to the queue: %s", err)
+	}
+	err = s.Shutdown(ctx)
+	if err != nil {
+		logger.Errorf("error in waiting for background workers: %s", err)
+	}
+	pgxDB.Close()
+	for _, replica := range replicas {
+		replica.Close()
+	}
+	err = redisPool.Close()
+	if err != nil {
+		logger.Infof("error on redis close: %s", err.Error())
+	}
+	logger.Infof("server stopped")
+}
+
+func newScheduler(cfg config.SchedulerConfig, s *storage.DBStorage, mu usecase.MaintenanceUseCase, idempotencyStore *idempotency.RedisStore, logger *zap.SugaredLogger) (*scheduler.Scheduler, error) {
+	sched := scheduler.New(s, logger)
+	tasks := []scheduler.Task{
+		{
+			Name:     "purge_deleted_users",
+			Schedule: cfg.PurgeDeletedSchedule,
+			Run: func(ctx context.Context) error {
+				purged, err := mu.PurgeDeletedUsersUseCase(ctx, cfg.PurgeDeletedRetention)
+				logger.Infof("purged %d deleted users", purged)
+				return err
+			},
+		},
+		{
+			Name:     "warm_cache",
+			Schedule: cfg.WarmCacheSchedule,
+			Run: func(ctx context.Context) error {
+				warmed, err := mu.WarmCacheUseCase(ctx, cfg.WarmCacheUsers)
+				logger.Infof("added %d users to the cache", warmed)
+				return err
+			},
+		},
+		{
+			Name:     "clean_idempotency_keys",
+			Schedule: cfg.IdempotencyCleanSchedule,
+			Run: func(ctx context.Context) error {
+				removed, err := idempotencyStore.CleanStale(cfg.IdempotencyStaleAfter)
+				logger.Infof("removed %d stale idempotency keys", removed)
+				return err
+			},
+		},
+		{
+			Name:     "expire_bans",
+			Schedule: cfg.ExpireBansSchedule,
+			Run: func(ctx context.Context) error {
+				expired, err := mu.ExpireBansUseCase(ctx)
+				logger.Infof("ended %d expired bans", expired)
+				return err
+			},
+		},
+	}
+	for _, task := range tasks {
+		if err := sched.Add(task); err != nil {
+			return nil, err
+		}
 	}
+	return sched, nil
 }
@@ -1,65 +1,218 @@
 package dbinit
 
 import (
-	"database/sql"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
-	_ "github.com/jackc/pgx/stdlib"
-	"github.com/joho/godotenv"
+	"github.com/ivanov-nikolay/user-api/internal/config"
+	"github.com/ivanov-nikolay/user-api/internal/retry"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
 )
 
-const (
-	maxDBConnections  = 10
-	maxPingDBAttempts = 20
-)
+// redisTestIdleAfter is how long a connection may stay idle in the pool
+// before it is checked with PING on borrow.
+const redisTestIdleAfter = time.Minute
+
+var errRedisUnavailable = errors.New("redis is unavailable, reconnecting")
+
+// GetRedis returns a pool even when the first ping fails. While Redis is
+// down the pool fails fast instead of dialing on every request, and a
+// background loop reconnects with backoff until ctx is done.
+func GetRedis(ctx context.Context, cfg config.RedisConfig, backoff retry.Backoff, logger *zap.SugaredLogger) (*redis.Pool, error) {
+	rd := &redisDialer{
+		ctx:     ctx,
+		cfg:     cfg,
+		backoff: backoff,
+		logger:  logger,
+	}
+	pool := &redis.Pool{
+		MaxIdle:         cfg.MaxIdle,
+		MaxActive:       cfg.MaxActive,
+		IdleTimeout:     cfg.IdleTimeout,
+		MaxConnLifetime: cfg.ConnMaxLifetime,
+		Dial:            rd.dial,
+		TestOnBorrow: func(c redis.Conn, lastUsed time.Time) error {
+			if time.Since(lastUsed) < redisTestIdleAfter {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	return pool, err
+}
+
+type redisDialer struct {
+	ctx     context.Context
+	cfg     config.RedisConfig
+	backoff retry.Backoff
+	logger  *zap.SugaredLogger
+
+	mu   sync.Mutex
+	down bool
+}
+
+func (rd *redisDialer) dial() (redis.Conn, error) {
+	rd.mu.Lock()
+	down := rd.down
+	rd.mu.Unlock()
+	if down {
+		return nil, errRedisUnavailable
+	}
+
+	conn, err := dialRedis(rd.cfg)
+	if err != nil {
+		rd.markDown(err)
+	}
+	return conn, err
+}
+
+func (rd *redisDialer) markDown(err error) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if rd.down || rd.ctx.Err() != nil {
+		return
+	}
+	rd.down = true
+	rd.logger.Warnf("redis is unavailable, reconnecting in background: %s", err)
+	go rd.reconnect()
+}
+
+func (rd *redisDialer) reconnect() {
+	err := retry.Do(rd.ctx, rd.backoff, func(ctx context.Context) error {
+		conn, err := dialRedis(rd.cfg)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = conn.Do("PING")
+		return err
+	})
+	if err != nil {
+		return
+	}
+	rd.mu.Lock()
+	rd.down = false
+	rd.mu.Unlock()
+	rd.logger.Infof("reconnected to redis")
+}
 
-func GetRedis() (redis.Conn, error) {
-	host := os.Getenv("hostRD")
-	port := os.Getenv("portRD")
-	c, err := redis.DialURL(fmt.Sprintf("redis://user:@%s:%s/0", host, port))
+// dialRedis reads the URL, the password and the certificates on every
+// dial, so that rotated credentials are used by new connections.
+func dialRedis(cfg config.RedisConfig) (redis.Conn, error) {
+	rawURL, err := cfg.ConnURL()
 	if err != nil {
 		return nil, err
 	}
-	return c, nil
+	tlsConfig, err := redisTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return redis.DialURL(rawURL,
+		redis.DialTLSConfig(tlsConfig),
+		redis.DialTLSSkipVerify(cfg.TLSSkipVerify),
+	)
+}
 
+func redisTLSConfig(cfg config.RedisConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.TLSCACert != "" {
+		caCert, err := os.ReadFile(cfg.TLSCACert)
+		if err != nil {
+			return nil, fmt.Errorf("reading redis CA certificate: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.TLSCACert)
+		}
+	}
+	if cfg.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("reading redis client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
 }
 
-func GetPostgres() (*sql.DB, error) {
-	envFilePath := ".env"
-	err := godotenv.Load(envFilePath)
+// GetPostgres connects with backoff for at most connectTimeout. The pool
+// itself lives as long as ctx, it keeps opening connections after the
+// first ping. The password and certificates are read again before every
+// new connection, so rotated credentials are used without a restart.
+// tracer, if it is not nil, traces the statements of every connection.
+func GetPostgres(ctx context.Context, connectTimeout time.Duration, cfg config.PostgresConfig, backoff retry.Backoff, afterConnect func(context.Context, *pgx.Conn) error, tracer pgx.QueryTracer) (*pgxpool.Pool, error) {
+	pool, err := newPool(ctx, cfg, cfg.ConnString, afterConnect, tracer)
+	if err != nil {
+		return nil, err
+	}
+	connectCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	err = retry.Do(connectCtx, backoff, pool.Ping)
 	if err != nil {
-		fmt.Println("err")
-	}
-	pass := os.Getenv("pass")
-	user := os.Getenv("user")
-	dbName := os.Getenv("dbName")
-	host := os.Getenv("hostPG")
-	port := os.Getenv("portPG")
-	fmt.Println(port)
-	sslMode := os.Getenv("sslMode")
-	dsn := fmt.Sprintf("user=%s dbname=%s password=%s host=%s port=%s sslmode=%s",
-		user, dbName, pass, host, port, sslMode)
-	db, err := sql.Open("pgx", dsn)
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
 
+// GetReplicas doesn't wait for the replicas, they are used once their
+// health check passes.
+func GetReplicas(ctx context.Context, cfg config.PostgresConfig, afterConnect func(context.Context, *pgx.Conn) error, tracer pgx.QueryTracer) ([]*pgxpool.Pool, error) {
+	pools := make([]*pgxpool.Pool, 0, len(cfg.ReplicaURLs))
+	for i := range cfg.ReplicaURLs {
+		i := i
+		pool, err := newPool(ctx, cfg, func() (string, error) { return cfg.ReplicaConnString(i) }, afterConnect, tracer)
+		if err != nil {
+			for _, p := range pools {
+				p.Close()
+			}
+			return nil, fmt.Errorf("replica %d: %w", i+1, err)
+		}
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+func newPool(ctx context.Context, cfg config.PostgresConfig, connString func() (string, error), afterConnect func(context.Context, *pgx.Conn) error, tracer pgx.QueryTracer) (*pgxpool.Pool, error) {
+	initial, err := connString()
+	if err != nil {
+		return nil, err
+	}
+	poolConfig, err := pgxpool.ParseConfig(initial)
 	if err != nil {
 		return nil, err
 	}
-	db.SetMaxOpenConns(maxDBConnections)
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
-	attemptsNumber := 0
-	for range ticker.C {
-		err = db.Ping()
-		attemptsNumber++
-		if err == nil {
-			break
+	poolConfig.MaxConns = int32(cfg.MaxConns)
+	poolConfig.MinConns = int32(cfg.MinConns)
+	poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+	poolConfig.AfterConnect = afterConnect
+	poolConfig.ConnConfig.Tracer = tracer
+	poolConfig.BeforeConnect = func(_ context.Context, connConfig *pgx.ConnConfig) error {
+		current, err := connString()
+		if err != nil {
+			return err
 		}
-		if attemptsNumber == maxPingDBAttempts {
-			return nil, err
+		currentConfig, err := pgx.ParseConfig(current)
+		if err != nil {
+			return err
 		}
+		connConfig.Password = currentConfig.Password
+		connConfig.TLSConfig = currentConfig.TLSConfig
+		return nil
 	}
-	return db, nil
+	return pgxpool.NewWithConfig(ctx, poolConfig)
 }
@@ -0,0 +1,59 @@
+package dbinit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/config"
+	"github.com/ivanov-nikolay/user-api/internal/retry"
+	"github.com/jackc/pgx/v5"
+)
+
+// unreachable is a postgres address nothing listens on.
+var unreachable = config.PostgresConfig{
+	URL:             "postgres://user@127.0.0.1:1/users?connect_timeout=1",
+	MaxConns:        3,
+	MinConns:        0,
+	MaxConnLifetime: time.Hour,
+	MaxConnIdleTime: time.Minute,
+}
+
+func TestNewPoolConfig(t *testing.T) {
+	afterConnect := func(context.Context, *pgx.Conn) error { return nil }
+	tracer := noopTracer{}
+	pool, err := newPool(context.Background(), unreachable, unreachable.ConnString, afterConnect, tracer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	cfg := pool.Config()
+	if cfg.MaxConns != 3 || cfg.MaxConnLifetime != time.Hour || cfg.MaxConnIdleTime != time.Minute {
+		t.Errorf("pool config = max %d, lifetime %s, idle %s", cfg.MaxConns, cfg.MaxConnLifetime, cfg.MaxConnIdleTime)
+	}
+	if cfg.AfterConnect == nil || cfg.ConnConfig.Tracer != tracer {
+		t.Error("hooks are not set on the pool")
+	}
+}
+
+func TestGetPostgresGivesUp(t *testing.T) {
+	start := time.Now()
+	_, err := GetPostgres(context.Background(), 100*time.Millisecond, unreachable,
+		retry.Backoff{Initial: 10 * time.Millisecond, Max: 20 * time.Millisecond}, nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetPostgres() error = %v, want the connect deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("GetPostgres() gave up after %s, the connect timeout is 100ms", elapsed)
+	}
+}
+
+type noopTracer struct{}
+
+func (noopTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return ctx
+}
+
+func (noopTracer) TraceQueryEnd(context.Context, *pgx.Conn, pgx.TraceQueryEndData) {}
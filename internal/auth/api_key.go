@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+type APIKeyLookup interface {
+	AuthenticateAPIKeyUseCase(ctx context.Context, rawKey string) (*entity.APIKey, error)
+}
+
+type APIKeyAuthenticator struct {
+	lookup APIKeyLookup
+}
+
+func NewAPIKeyAuthenticator(lookup APIKeyLookup) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{lookup: lookup}
+}
+
+func (aa *APIKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	rawKey := r.Header.Get("X-API-Key")
+	if rawKey == "" {
+		return nil, ErrNoCredentials
+	}
+	key, err := aa.lookup.AuthenticateAPIKeyUseCase(r.Context(), rawKey)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown, revoked or expired api key")
+	}
+	return &Principal{
+		Subject: fmt.Sprintf("apikey:%d", key.ID),
+		Scopes:  key.Scopes,
+	}, nil
+}
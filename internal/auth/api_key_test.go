@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+type lookupFunc func(ctx context.Context, rawKey string) (*entity.APIKey, error)
+
+func (f lookupFunc) AuthenticateAPIKeyUseCase(ctx context.Context, rawKey string) (*entity.APIKey, error) {
+	return f(ctx, rawKey)
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	aa := NewAPIKeyAuthenticator(lookupFunc(func(ctx context.Context, rawKey string) (*entity.APIKey, error) {
+		if rawKey != "uak_valid" {
+			return nil, nil
+		}
+		return &entity.APIKey{ID: 7, Scopes: []string{ScopeUsersRead}}, nil
+	}))
+
+	r := httptest.NewRequest("GET", "/users", nil)
+	if _, err := aa.Authenticate(r); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("no key: error = %v, want ErrNoCredentials", err)
+	}
+
+	r.Header.Set("X-API-Key", "uak_unknown")
+	if principal, err := aa.Authenticate(r); err == nil || errors.Is(err, ErrNoCredentials) {
+		t.Errorf("unknown key: %+v, %v, want an error", principal, err)
+	}
+
+	r.Header.Set("X-API-Key", "uak_valid")
+	principal, err := aa.Authenticate(r)
+	if err != nil {
+		t.Fatalf("valid key: error %v", err)
+	}
+	if principal.Subject != "apikey:7" || !principal.HasScope(ScopeUsersRead) || principal.HasScope(ScopeUsersWrite) {
+		t.Errorf("valid key: principal = %+v", principal)
+	}
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type JWTAuthenticator struct {
+	secret func() (string, error)
+}
+
+// NewJWTAuthenticator takes the secret on every request, so that a rotated
+// secret is used without a restart.
+func NewJWTAuthenticator(secret func() (string, error)) *JWTAuthenticator {
+	return &JWTAuthenticator{secret: secret}
+}
+
+type scopeClaims struct {
+	Scope string `json:"scope"`
+	jwt.RegisteredClaims
+}
+
+func (ja *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, ErrNoCredentials
+	}
+	tokenStr, found := strings.CutPrefix(header, "Bearer ")
+	if !found {
+		return nil, ErrNoCredentials
+	}
+
+	secret, err := ja.secret()
+	if err != nil {
+		return nil, fmt.Errorf("reading jwt secret: %s", err)
+	}
+	if secret == "" {
+		// Any token would be signed correctly with an empty key.
+		return nil, fmt.Errorf("jwt secret is empty")
+	}
+
+	claims := &scopeClaims{}
+	_, err = jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bearer token: %s", err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("invalid bearer token: subject is not set")
+	}
+	return &Principal{
+		Subject: claims.Subject,
+		Scopes:  strings.Fields(claims.Scope),
+	}, nil
+}
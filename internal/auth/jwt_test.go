@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func signToken(t *testing.T, secret string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, scopeClaims{
+		Scope:            ScopeUsersRead,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "client"},
+	}).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestJWTAuthenticatorSecret(t *testing.T) {
+	tests := []struct {
+		name     string
+		signedBy string
+		secret   string
+		ok       bool
+	}{
+		{"current secret", "current", "current", true},
+		{"rotated secret", "old", "current", false},
+		{"empty secret", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := NewJWTAuthenticator(func() (string, error) { return tt.secret, nil })
+			r := httptest.NewRequest("GET", "/users", nil)
+			r.Header.Set("Authorization", "Bearer "+signToken(t, tt.signedBy))
+			principal, err := authenticator.Authenticate(r)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Authenticate() error = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && principal.Subject != "client" {
+				t.Errorf("Subject = %q, want %q", principal.Subject, "client")
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package auth
+
+import "net/http"
+
+type RouteRule struct {
+	Method string
+	Path   string
+	Scope  string
+}
+
+// FieldRule restricts which values of a JSON body field may be sent to a
+// route. An empty Values list means any value of the field is restricted.
+type FieldRule struct {
+	Method string
+	Path   string
+	Field  string
+	Values []string
+	Scope  string
+}
+
+func (fr FieldRule) Restricts(value string) bool {
+	if len(fr.Values) == 0 {
+		return true
+	}
+	for _, v := range fr.Values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type Policy struct {
+	routes map[string]string
+	fields map[string][]FieldRule
+}
+
+func NewPolicy(routes []RouteRule, fields []FieldRule) *Policy {
+	p := &Policy{
+		routes: make(map[string]string, len(routes)),
+		fields: make(map[string][]FieldRule),
+	}
+	for _, rr := range routes {
+		p.routes[routeKey(rr.Method, rr.Path)] = rr.Scope
+	}
+	for _, fr := range fields {
+		key := routeKey(fr.Method, fr.Path)
+		p.fields[key] = append(p.fields[key], fr)
+	}
+	return p
+}
+
+func DefaultPolicy() *Policy {
+	return NewPolicy(
+		[]RouteRule{
+			{Method: http.MethodGet, Path: "/user/{USER_ID}", Scope: ScopeUsersRead},
+			{Method: http.MethodGet, Path: "/users", Scope: ScopeUsersRead},
+			{Method: http.MethodGet, Path: "/users/duplicates", Scope: ScopeUsersRead},
+			{Method: http.MethodGet, Path: "/users/export", Scope: ScopeUsersRead},
+			{Method: http.MethodGet, Path: "/attributes", Scope: ScopeUsersRead},
+			{Method: http.MethodPost, Path: "/user", Scope: ScopeUsersWrite},
+			{Method: http.MethodPut, Path: "/user", Scope: ScopeUsersWrite},
+			{Method: http.MethodPost, Path: "/users/import", Scope: ScopeUsersWrite},
+			{Method: http.MethodGet, Path: "/jobs/{JOB_ID}", Scope: ScopeUsersWrite},
+			{Method: http.MethodGet, Path: "/jobs/{JOB_ID}/errors", Scope: ScopeUsersWrite},
+			{Method: http.MethodPost, Path: "/jobs/{JOB_ID}/cancel", Scope: ScopeUsersWrite},
+			{Method: http.MethodDelete, Path: "/user/{USER_ID}", Scope: ScopeUsersAdmin},
+			{Method: http.MethodPost, Path: "/user/{USER_ID}/ban", Scope: ScopeUsersAdmin},
+			{Method: http.MethodPost, Path: "/user/{USER_ID}/unban", Scope: ScopeUsersAdmin},
+			{Method: http.MethodPost, Path: "/user/{USER_ID}/activate", Scope: ScopeUsersAdmin},
+			{Method: http.MethodPost, Path: "/users/merge", Scope: ScopeUsersAdmin},
+			{Method: http.MethodGet, Path: "/jobs", Scope: ScopeUsersAdmin},
+			{Method: http.MethodPost, Path: "/jobs/{JOB_ID}/retry", Scope: ScopeUsersAdmin},
+			{Method: http.MethodPost, Path: "/attributes", Scope: ScopeUsersAdmin},
+			{Method: http.MethodDelete, Path: "/attributes/{NAME}", Scope: ScopeUsersAdmin},
+			{Method: http.MethodPost, Path: "/apikeys", Scope: ScopeUsersAdmin},
+			{Method: http.MethodGet, Path: "/apikeys", Scope: ScopeUsersAdmin},
+			{Method: http.MethodDelete, Path: "/apikeys/{KEY_ID}", Scope: ScopeUsersAdmin},
+		},
+		[]FieldRule{
+			{Method: http.MethodPost, Path: "/user", Field: "status", Values: []string{"banned", "deleted"}, Scope: ScopeUsersAdmin},
+			{Method: http.MethodPut, Path: "/user", Field: "status", Values: []string{"banned", "deleted"}, Scope: ScopeUsersAdmin},
+		},
+	)
+}
+
+func (p *Policy) RouteScope(method, path string) (string, bool) {
+	scope, ok := p.routes[routeKey(method, path)]
+	return scope, ok
+}
+
+func (p *Policy) FieldRules(method, path string) []FieldRule {
+	return p.fields[routeKey(method, path)]
+}
+
+func routeKey(method, path string) string {
+	return method + " " + path
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDefaultPolicyRouteScope(t *testing.T) {
+	policy := DefaultPolicy()
+	tests := []struct {
+		method string
+		path   string
+		scope  string
+		ok     bool
+	}{
+		{http.MethodGet, "/user/{USER_ID}", ScopeUsersRead, true},
+		{http.MethodGet, "/users", ScopeUsersRead, true},
+		{http.MethodPost, "/user", ScopeUsersWrite, true},
+		{http.MethodPut, "/user", ScopeUsersWrite, true},
+		{http.MethodDelete, "/user/{USER_ID}", ScopeUsersAdmin, true},
+		{http.MethodPost, "/user/{USER_ID}/ban", ScopeUsersAdmin, true},
+		{http.MethodPost, "/attributes", ScopeUsersAdmin, true},
+		{http.MethodPost, "/apikeys", ScopeUsersAdmin, true},
+		{http.MethodGet, "/metrics", "", false},
+		{http.MethodPatch, "/user", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			scope, ok := policy.RouteScope(tt.method, tt.path)
+			if scope != tt.scope || ok != tt.ok {
+				t.Errorf("RouteScope() = %q, %v, want %q, %v", scope, ok, tt.scope, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDefaultPolicyFieldRules(t *testing.T) {
+	policy := DefaultPolicy()
+	tests := []struct {
+		method string
+		path   string
+		field  string
+		values []string
+	}{
+		{http.MethodPost, "/user", "status", []string{"banned", "deleted"}},
+		{http.MethodPut, "/user", "status", []string{"banned", "deleted"}},
+		{http.MethodGet, "/users", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rules := policy.FieldRules(tt.method, tt.path)
+			if tt.field == "" {
+				if len(rules) != 0 {
+					t.Fatalf("FieldRules() = %v, want none", rules)
+				}
+				return
+			}
+			if len(rules) != 1 {
+				t.Fatalf("FieldRules() = %v, want one rule", rules)
+			}
+			rule := rules[0]
+			if rule.Field != tt.field || rule.Scope != ScopeUsersAdmin {
+				t.Errorf("rule = %+v, want field %q with scope %q", rule, tt.field, ScopeUsersAdmin)
+			}
+			for _, value := range tt.values {
+				if !rule.Restricts(value) {
+					t.Errorf("Restricts(%q) = false, want true", value)
+				}
+			}
+			if rule.Restricts("active") {
+				t.Errorf("Restricts(%q) = true, want false", "active")
+			}
+		})
+	}
+}
+
+func TestFieldRuleRestricts(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   FieldRule
+		value  string
+		result bool
+	}{
+		{"listed value", FieldRule{Values: []string{"banned", "deleted"}}, "banned", true},
+		{"other value", FieldRule{Values: []string{"banned", "deleted"}}, "active", false},
+		{"values are case-sensitive", FieldRule{Values: []string{"banned"}}, "Banned", false},
+		{"no values restrict any value", FieldRule{}, "anything", true},
+		{"no values restrict empty value", FieldRule{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Restricts(tt.value); got != tt.result {
+				t.Errorf("Restricts(%q) = %v, want %v", tt.value, got, tt.result)
+			}
+		})
+	}
+}
+
+func TestPrincipalHasScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		scope  string
+		result bool
+	}{
+		{"granted", []string{ScopeUsersRead}, ScopeUsersRead, true},
+		{"not granted", []string{ScopeUsersRead}, ScopeUsersWrite, false},
+		{"admin implies write", []string{ScopeUsersAdmin}, ScopeUsersWrite, true},
+		{"write doesn't imply admin", []string{ScopeUsersWrite}, ScopeUsersAdmin, false},
+		{"no scopes", nil, ScopeUsersRead, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Principal{Subject: "client", Scopes: tt.scopes}
+			if got := p.HasScope(tt.scope); got != tt.result {
+				t.Errorf("HasScope(%q) = %v, want %v", tt.scope, got, tt.result)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	ScopeUsersAdmin = "users:admin"
+)
+
+var ErrNoCredentials = errors.New("no credentials in request")
+
+func IsKnownScope(scope string) bool {
+	return scope == ScopeUsersRead || scope == ScopeUsersWrite || scope == ScopeUsersAdmin
+}
+
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+type Principal struct {
+	Subject string
+	Scopes  []string
+}
+
+// HasScope reports whether the principal was granted scope. users:admin
+// implies every other users scope.
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeUsersAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
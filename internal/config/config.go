@@ -0,0 +1,409 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is loaded from defaults, then the optional YAML file, then the
+// environment (with .env). Every field has an env tag, the env names are
+// kept from the time the service read os.Getenv directly.
+type Config struct {
+	HTTP        HTTPConfig        `yaml:"http"`
+	Postgres    PostgresConfig    `yaml:"postgres"`
+	Redis       RedisConfig       `yaml:"redis"`
+	Connect     ConnectConfig     `yaml:"connect"`
+	Auth        AuthConfig        `yaml:"auth"`
+	RateLimit   RateLimitConfig   `yaml:"rate_limit"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	Search      SearchConfig      `yaml:"search"`
+	AccessLog   AccessLogConfig   `yaml:"access_log"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	Health      HealthConfig      `yaml:"health"`
+	Jobs        JobsConfig        `yaml:"jobs"`
+	Scheduler   SchedulerConfig   `yaml:"scheduler"`
+}
+
+type HTTPConfig struct {
+	Addr              string        `yaml:"addr" env:"appPort"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"readHeaderTimeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"readTimeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"writeTimeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"idleTimeout"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes" env:"maxHeaderBytes"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" env:"shutdownTimeout"`
+}
+
+// PostgresConfig describes the connection either as URL or as separate
+// fields. Parameters set by the fields are added to the URL when it does
+// not have them.
+type PostgresConfig struct {
+	URL          string `yaml:"url" env:"DATABASE_URL" secret:"true"`
+	URLFile      string `yaml:"url_file" env:"DATABASE_URL_FILE"`
+	Host         string `yaml:"host" env:"hostPG"`
+	Port         int    `yaml:"port" env:"portPG"`
+	User         string `yaml:"user" env:"user"`
+	Password     string `yaml:"password" env:"pass" secret:"true"`
+	PasswordFile string `yaml:"password_file" env:"pass_FILE"`
+	DBName       string `yaml:"db_name" env:"dbName"`
+	SSLMode      string `yaml:"ssl_mode" env:"sslMode"`
+	SSLRootCert  string `yaml:"ssl_root_cert" env:"sslRootCert"`
+	SSLCert      string `yaml:"ssl_cert" env:"sslCert"`
+	SSLKey       string `yaml:"ssl_key" env:"sslKey"`
+	AppName      string `yaml:"application_name" env:"appName"`
+
+	ReplicaURLs          []string      `yaml:"replica_urls" env:"replicaURLs" secret:"true"`
+	ReplicaCheckInterval time.Duration `yaml:"replica_check_interval" env:"replicaCheckInterval"`
+
+	MaxConns        int           `yaml:"max_conns" env:"maxConnsPG"`
+	MinConns        int           `yaml:"min_conns" env:"minConnsPG"`
+	MaxConnLifetime time.Duration `yaml:"max_conn_lifetime" env:"maxConnLifetimePG"`
+	MaxConnIdleTime time.Duration `yaml:"max_conn_idle_time" env:"maxConnIdleTimePG"`
+}
+
+type RedisConfig struct {
+	URL           string `yaml:"url" env:"REDIS_URL" secret:"true"`
+	URLFile       string `yaml:"url_file" env:"REDIS_URL_FILE"`
+	Host          string `yaml:"host" env:"hostRD"`
+	Port          int    `yaml:"port" env:"portRD"`
+	User          string `yaml:"user" env:"userRD"`
+	Password      string `yaml:"password" env:"passRD" secret:"true"`
+	PasswordFile  string `yaml:"password_file" env:"passRD_FILE"`
+	DB            int    `yaml:"db" env:"dbRD"`
+	TLS           bool   `yaml:"tls" env:"tlsRD"`
+	TLSCACert     string `yaml:"tls_ca_cert" env:"tlsCACertRD"`
+	TLSCert       string `yaml:"tls_cert" env:"tlsCertRD"`
+	TLSKey        string `yaml:"tls_key" env:"tlsKeyRD"`
+	TLSSkipVerify bool   `yaml:"tls_skip_verify" env:"tlsSkipVerifyRD"`
+
+	MaxIdle         int           `yaml:"max_idle" env:"maxIdleRD"`
+	MaxActive       int           `yaml:"max_active" env:"maxActiveRD"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout" env:"idleTimeoutRD"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"connMaxLifetimeRD"`
+}
+
+// ConnectConfig is the backoff used to connect to Postgres and Redis.
+// Timeout bounds only the initial Postgres connection, Redis is optional
+// and is reconnected in the background for as long as the service runs.
+type ConnectConfig struct {
+	InitialBackoff time.Duration `yaml:"initial_backoff" env:"connectInitialBackoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff" env:"connectMaxBackoff"`
+	Timeout        time.Duration `yaml:"timeout" env:"connectTimeout"`
+}
+
+type AuthConfig struct {
+	JWTSecret     string `yaml:"jwt_secret" env:"jwtSecret" secret:"true"`
+	JWTSecretFile string `yaml:"jwt_secret_file" env:"jwtSecret_FILE"`
+}
+
+type RateLimitConfig struct {
+	Store       string  `yaml:"store" env:"rateLimitStore"`
+	Rate        float64 `yaml:"rate" env:"rateLimitRate"`
+	Burst       int     `yaml:"burst" env:"rateLimitBurst"`
+	SearchRate  float64 `yaml:"search_rate" env:"rateLimitSearchRate"`
+	SearchBurst int     `yaml:"search_burst" env:"rateLimitSearchBurst"`
+	// IPRate and IPBurst limit an address over all routes before the
+	// request is authenticated.
+	IPRate  float64 `yaml:"ip_rate" env:"rateLimitIPRate"`
+	IPBurst int     `yaml:"ip_burst" env:"rateLimitIPBurst"`
+}
+
+type IdempotencyConfig struct {
+	Window time.Duration `yaml:"window" env:"idempotencyWindow"`
+}
+
+type SearchConfig struct {
+	StatementTimeout time.Duration `yaml:"statement_timeout" env:"searchStatementTimeout"`
+	// ExportStatementTimeout bounds the export query, which reads all the
+	// matching rows and so gets a much larger budget than a search page.
+	ExportStatementTimeout time.Duration `yaml:"export_statement_timeout" env:"exportStatementTimeout"`
+}
+
+type AccessLogConfig struct {
+	SampleRate float64  `yaml:"sample_rate" env:"accessLogSampleRate"`
+	SkipPaths  []string `yaml:"skip_paths" env:"accessLogSkipPaths"`
+}
+
+type TracingConfig struct {
+	Exporter string `yaml:"exporter" env:"traceExporter"`
+}
+
+type HealthConfig struct {
+	ReadinessTimeout time.Duration `yaml:"readiness_timeout" env:"readinessTimeout"`
+}
+
+// JobsConfig configures the background job workers. A job that fails is
+// retried after RetryInitialBackoff, doubled up to RetryMaxBackoff, until it
+// has run MaxAttempts times. Lease is how long a job stays with a worker
+// that stopped renewing it, for example because it crashed.
+type JobsConfig struct {
+	Workers             int           `yaml:"workers" env:"jobWorkers"`
+	PollInterval        time.Duration `yaml:"poll_interval" env:"jobPollInterval"`
+	Lease               time.Duration `yaml:"lease" env:"jobLease"`
+	MaxAttempts         int           `yaml:"max_attempts" env:"jobMaxAttempts"`
+	RetryInitialBackoff time.Duration `yaml:"retry_initial_backoff" env:"jobRetryInitialBackoff"`
+	RetryMaxBackoff     time.Duration `yaml:"retry_max_backoff" env:"jobRetryMaxBackoff"`
+}
+
+// SchedulerConfig holds the cron schedules of the maintenance tasks, an
+// empty schedule disables the task.
+type SchedulerConfig struct {
+	PurgeDeletedSchedule     string        `yaml:"purge_deleted_schedule" env:"purgeDeletedSchedule"`
+	PurgeDeletedRetention    time.Duration `yaml:"purge_deleted_retention" env:"purgeDeletedRetention"`
+	WarmCacheSchedule        string        `yaml:"warm_cache_schedule" env:"warmCacheSchedule"`
+	WarmCacheUsers           int           `yaml:"warm_cache_users" env:"warmCacheUsers"`
+	IdempotencyCleanSchedule string        `yaml:"idempotency_clean_schedule" env:"idempotencyCleanSchedule"`
+	IdempotencyStaleAfter    time.Duration `yaml:"idempotency_stale_after" env:"idempotencyStaleAfter"`
+	ExpireBansSchedule       string        `yaml:"expire_bans_schedule" env:"expireBansSchedule"`
+}
+
+func Default() Config {
+	return Config{
+		HTTP: HTTPConfig{
+			Addr:              ":8080",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			MaxHeaderBytes:    1 << 20,
+			ShutdownTimeout:   20 * time.Second,
+		},
+		Postgres: PostgresConfig{
+			Host:    "localhost",
+			Port:    5432,
+			User:    "postgres",
+			DBName:  "postgres",
+			SSLMode: "disable",
+			AppName: "user-api",
+
+			ReplicaCheckInterval: 5 * time.Second,
+
+			MaxConns:        10,
+			MinConns:        2,
+			MaxConnLifetime: 30 * time.Minute,
+			MaxConnIdleTime: 5 * time.Minute,
+		},
+		Redis: RedisConfig{
+			Host: "localhost",
+			Port: 6379,
+
+			MaxIdle:     10,
+			IdleTimeout: 240 * time.Second,
+		},
+		Connect: ConnectConfig{
+			InitialBackoff: 500 * time.Millisecond,
+			MaxBackoff:     10 * time.Second,
+			Timeout:        time.Minute,
+		},
+		RateLimit: RateLimitConfig{
+			Store:       "memory",
+			Rate:        10,
+			Burst:       20,
+			SearchRate:  2,
+			SearchBurst: 5,
+			IPRate:      50,
+			IPBurst:     100,
+		},
+		Idempotency: IdempotencyConfig{
+			Window: 24 * time.Hour,
+		},
+		Search: SearchConfig{
+			StatementTimeout:       2 * time.Second,
+			ExportStatementTimeout: 5 * time.Minute,
+		},
+		AccessLog: AccessLogConfig{
+			SampleRate: 1,
+			SkipPaths:  []string{"/healthz", "/readyz", "/metrics"},
+		},
+		Health: HealthConfig{
+			ReadinessTimeout: 2 * time.Second,
+		},
+		Jobs: JobsConfig{
+			Workers:             4,
+			PollInterval:        time.Second,
+			Lease:               30 * time.Second,
+			MaxAttempts:         5,
+			RetryInitialBackoff: 10 * time.Second,
+			RetryMaxBackoff:     10 * time.Minute,
+		},
+		Scheduler: SchedulerConfig{
+			PurgeDeletedSchedule:     "0 3 * * *",
+			PurgeDeletedRetention:    30 * 24 * time.Hour,
+			WarmCacheSchedule:        "*/15 * * * *",
+			WarmCacheUsers:           1000,
+			IdempotencyCleanSchedule: "@hourly",
+			IdempotencyStaleAfter:    10 * time.Minute,
+			ExpireBansSchedule:       "* * * * *",
+		},
+	}
+}
+
+// Load reads the configuration and validates it. path is the optional
+// YAML file, an empty path skips it.
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("reading config file: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return cfg, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	}
+
+	err := godotenv.Load(".env")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return cfg, fmt.Errorf("reading .env: %w", err)
+	}
+	if err = loadEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	if err = cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.HTTP.Addr != "", "appPort: listen address is required")
+	check(c.HTTP.ReadHeaderTimeout > 0, "readHeaderTimeout: must be positive")
+	check(c.HTTP.ReadTimeout > 0, "readTimeout: must be positive")
+	check(c.HTTP.WriteTimeout > 0, "writeTimeout: must be positive")
+	check(c.HTTP.IdleTimeout > 0, "idleTimeout: must be positive")
+	check(c.HTTP.MaxHeaderBytes > 0, "maxHeaderBytes: must be positive")
+	check(c.HTTP.ShutdownTimeout > 0, "shutdownTimeout: must be positive")
+
+	errs = append(errs, checkSecret("DATABASE_URL", c.Postgres.URL, c.Postgres.URLFile)...)
+	errs = append(errs, checkSecret("pass", c.Postgres.Password, c.Postgres.PasswordFile)...)
+	if c.Postgres.URL != "" {
+		check(validURL(c.Postgres.URL, "postgres", "postgresql"), "DATABASE_URL: must be a postgres:// URL")
+	}
+	if c.Postgres.URL == "" && c.Postgres.URLFile == "" {
+		check(c.Postgres.Host != "", "hostPG: postgres host is required")
+		check(validPort(c.Postgres.Port), "portPG: %d is not a valid port", c.Postgres.Port)
+		check(c.Postgres.User != "", "user: postgres user is required")
+		check(c.Postgres.DBName != "", "dbName: postgres database is required")
+		check(oneOf(c.Postgres.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full"),
+			"sslMode: unknown mode %q", c.Postgres.SSLMode)
+	}
+	check((c.Postgres.SSLCert == "") == (c.Postgres.SSLKey == ""), "sslCert, sslKey: must be set together")
+	errs = append(errs, checkFiles(map[string]string{
+		"sslRootCert": c.Postgres.SSLRootCert,
+		"sslCert":     c.Postgres.SSLCert,
+		"sslKey":      c.Postgres.SSLKey,
+	})...)
+
+	for i, replicaURL := range c.Postgres.ReplicaURLs {
+		check(validURL(replicaURL, "postgres", "postgresql"), "replicaURLs: item %d must be a postgres:// URL", i+1)
+	}
+	check(c.Postgres.ReplicaCheckInterval > 0, "replicaCheckInterval: must be positive")
+	check(c.Postgres.MaxConns > 0, "maxConnsPG: must be positive")
+	check(c.Postgres.MinConns >= 0 && c.Postgres.MinConns <= c.Postgres.MaxConns,
+		"minConnsPG: must be between 0 and maxConnsPG")
+	check(c.Postgres.MaxConnLifetime > 0, "maxConnLifetimePG: must be positive")
+	check(c.Postgres.MaxConnIdleTime > 0, "maxConnIdleTimePG: must be positive")
+
+	errs = append(errs, checkSecret("REDIS_URL", c.Redis.URL, c.Redis.URLFile)...)
+	errs = append(errs, checkSecret("passRD", c.Redis.Password, c.Redis.PasswordFile)...)
+	if c.Redis.URL != "" {
+		check(validURL(c.Redis.URL, "redis", "rediss"), "REDIS_URL: must be a redis:// or rediss:// URL")
+	}
+	if c.Redis.URL == "" && c.Redis.URLFile == "" {
+		check(c.Redis.Host != "", "hostRD: redis host is required")
+		check(validPort(c.Redis.Port), "portRD: %d is not a valid port", c.Redis.Port)
+		check(c.Redis.DB >= 0, "dbRD: must not be negative")
+	}
+	check((c.Redis.TLSCert == "") == (c.Redis.TLSKey == ""), "tlsCertRD, tlsKeyRD: must be set together")
+	errs = append(errs, checkFiles(map[string]string{
+		"tlsCACertRD": c.Redis.TLSCACert,
+		"tlsCertRD":   c.Redis.TLSCert,
+		"tlsKeyRD":    c.Redis.TLSKey,
+	})...)
+
+	check(c.Redis.MaxIdle >= 0, "maxIdleRD: must not be negative")
+	check(c.Redis.MaxActive >= 0, "maxActiveRD: must not be negative, 0 means no limit")
+	check(c.Redis.IdleTimeout >= 0, "idleTimeoutRD: must not be negative")
+	check(c.Redis.ConnMaxLifetime >= 0, "connMaxLifetimeRD: must not be negative")
+
+	check(c.Connect.InitialBackoff > 0, "connectInitialBackoff: must be positive")
+	check(c.Connect.MaxBackoff >= c.Connect.InitialBackoff, "connectMaxBackoff: must not be less than connectInitialBackoff")
+	check(c.Connect.Timeout > 0, "connectTimeout: must be positive")
+
+	errs = append(errs, checkSecret("jwtSecret", c.Auth.JWTSecret, c.Auth.JWTSecretFile)...)
+
+	check(oneOf(c.RateLimit.Store, "memory", "redis"), "rateLimitStore: must be memory or redis, got %q", c.RateLimit.Store)
+	check(c.RateLimit.Rate > 0, "rateLimitRate: must be positive")
+	check(c.RateLimit.Burst > 0, "rateLimitBurst: must be positive")
+	check(c.RateLimit.SearchRate > 0, "rateLimitSearchRate: must be positive")
+	check(c.RateLimit.SearchBurst > 0, "rateLimitSearchBurst: must be positive")
+	check(c.RateLimit.IPRate > 0, "rateLimitIPRate: must be positive")
+	check(c.RateLimit.IPBurst > 0, "rateLimitIPBurst: must be positive")
+
+	check(c.Idempotency.Window > 0, "idempotencyWindow: must be positive")
+	check(c.Search.StatementTimeout > 0, "searchStatementTimeout: must be positive")
+	check(c.Search.ExportStatementTimeout > 0, "exportStatementTimeout: must be positive")
+	check(c.AccessLog.SampleRate >= 0 && c.AccessLog.SampleRate <= 1, "accessLogSampleRate: must be between 0 and 1")
+	check(oneOf(c.Tracing.Exporter, "", "otlp", "stdout"), "traceExporter: must be empty, otlp or stdout, got %q", c.Tracing.Exporter)
+	check(c.Health.ReadinessTimeout > 0, "readinessTimeout: must be positive")
+
+	check(c.Jobs.Workers > 0, "jobWorkers: must be positive")
+	check(c.Jobs.PollInterval > 0, "jobPollInterval: must be positive")
+	check(c.Jobs.Lease >= time.Second, "jobLease: must be at least 1s")
+	check(c.Jobs.MaxAttempts > 0, "jobMaxAttempts: must be positive")
+	check(c.Jobs.RetryInitialBackoff > 0, "jobRetryInitialBackoff: must be positive")
+	check(c.Jobs.RetryMaxBackoff >= c.Jobs.RetryInitialBackoff, "jobRetryMaxBackoff: must not be less than jobRetryInitialBackoff")
+
+	for _, schedule := range []struct{ name, spec string }{
+		{"purgeDeletedSchedule", c.Scheduler.PurgeDeletedSchedule},
+		{"warmCacheSchedule", c.Scheduler.WarmCacheSchedule},
+		{"idempotencyCleanSchedule", c.Scheduler.IdempotencyCleanSchedule},
+		{"expireBansSchedule", c.Scheduler.ExpireBansSchedule},
+	} {
+		if _, err := cron.ParseStandard(schedule.spec); schedule.spec != "" && err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", schedule.name, err))
+		}
+	}
+	check(c.Scheduler.PurgeDeletedRetention > 0, "purgeDeletedRetention: must be positive")
+	check(c.Scheduler.WarmCacheUsers > 0, "warmCacheUsers: must be positive")
+	check(c.Scheduler.IdempotencyStaleAfter > 0, "idempotencyStaleAfter: must be positive")
+
+	return errors.Join(errs...)
+}
+
+func validURL(rawURL string, schemes ...string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && oneOf(u.Scheme, schemes...)
+}
+
+func validPort(port int) bool {
+	return port > 0 && port < 1<<16
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
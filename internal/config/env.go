@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[redacted]"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// loadEnv overrides every field that has an env tag with the value of that
+// variable, when it is set.
+func loadEnv(cfg *Config) error {
+	var errs []string
+	walk(reflect.ValueOf(cfg).Elem(), func(field reflect.StructField, value reflect.Value) {
+		name := field.Tag.Get("env")
+		raw, ok := os.LookupEnv(name)
+		if name == "" || !ok {
+			return
+		}
+		if err := setValue(value, strings.TrimSpace(raw)); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err))
+		}
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid environment: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func walk(v reflect.Value, fn func(reflect.StructField, reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, value := t.Field(i), v.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			walk(value, fn)
+			continue
+		}
+		fn(field, value)
+	}
+}
+
+func setValue(v reflect.Value, raw string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration, use values like 500ms or 30s", raw)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration with secret fields masked.
+func (c Config) Redacted() Config {
+	out := c
+	walk(reflect.ValueOf(&out).Elem(), func(field reflect.StructField, value reflect.Value) {
+		if field.Tag.Get("secret") != "true" {
+			return
+		}
+		switch value.Kind() {
+		case reflect.String:
+			if value.String() != "" {
+				value.SetString(redacted)
+			}
+		case reflect.Slice:
+			masked := make([]string, value.Len())
+			for i := range masked {
+				masked[i] = redacted
+			}
+			value.Set(reflect.ValueOf(masked))
+		}
+	})
+	return out
+}
+
+// YAML renders the redacted configuration in the config file format.
+func (c Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c.Redacted())
+}
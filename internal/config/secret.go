@@ -0,0 +1,202 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readSecret returns the content of path when it is set and value
+// otherwise. Files are read on every call, so a secret mounted from a file
+// can be rotated without a restart.
+func readSecret(value, path string) (string, error) {
+	if path == "" {
+		return value, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func checkSecret(name, value, path string) []error {
+	if path == "" {
+		return nil
+	}
+	if value != "" {
+		return []error{fmt.Errorf("%s, %s_FILE: only one of them can be set", name, name)}
+	}
+	if _, err := readSecret(value, path); err != nil {
+		return []error{fmt.Errorf("%s_FILE: %w", name, err)}
+	}
+	return nil
+}
+
+func checkFiles(files map[string]string) []error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		if files[name] == "" {
+			continue
+		}
+		if _, err := os.Stat(files[name]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errs
+}
+
+// SecretFile is a secret given as is or kept in a file. Unlike readSecret
+// it is meant for every request: the file is read again only when its
+// modification time or size changes.
+type SecretFile struct {
+	value string
+	path  string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	cached  string
+}
+
+// Secret returns the current secret. A file that can't be read is an
+// error, the old value is not used after the file is gone.
+func (sf *SecretFile) Secret() (string, error) {
+	if sf.path == "" {
+		return sf.value, nil
+	}
+	info, err := os.Stat(sf.path)
+	if err != nil {
+		return "", fmt.Errorf("reading secret file: %w", err)
+	}
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if !sf.modTime.IsZero() && info.ModTime().Equal(sf.modTime) && info.Size() == sf.size {
+		return sf.cached, nil
+	}
+	secret, err := readSecret(sf.value, sf.path)
+	if err != nil {
+		return "", err
+	}
+	sf.modTime, sf.size, sf.cached = info.ModTime(), info.Size(), secret
+	return secret, nil
+}
+
+// Secret returns jwtSecret, from jwtSecret_FILE when it is set.
+func (c AuthConfig) Secret() *SecretFile {
+	return &SecretFile{value: c.JWTSecret, path: c.JWTSecretFile}
+}
+
+// ConnString returns the connection URL with the current password.
+func (c PostgresConfig) ConnString() (string, error) {
+	rawURL, err := readSecret(c.URL, c.URLFile)
+	if err != nil {
+		return "", err
+	}
+	return c.connString(rawURL)
+}
+
+// ReplicaConnString returns the URL of the i-th replica. A replica URL
+// without a password uses the password of the primary.
+func (c PostgresConfig) ReplicaConnString(i int) (string, error) {
+	u, err := url.Parse(c.ReplicaURLs[i])
+	if err != nil {
+		return "", fmt.Errorf("parsing replica URL: %w", err)
+	}
+	if _, ok := u.User.Password(); ok {
+		c.PasswordFile = ""
+	}
+	return c.connString(c.ReplicaURLs[i])
+}
+
+func (c PostgresConfig) connString(rawURL string) (string, error) {
+	password, err := readSecret(c.Password, c.PasswordFile)
+	if err != nil {
+		return "", err
+	}
+
+	var u *url.URL
+	if rawURL != "" {
+		u, err = url.Parse(rawURL)
+		if err != nil {
+			return "", fmt.Errorf("parsing connection URL: %w", err)
+		}
+		if _, ok := u.User.Password(); password != "" && (!ok || c.PasswordFile != "") {
+			u.User = url.UserPassword(u.User.Username(), password)
+		}
+	} else {
+		u = &url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(c.User, password),
+			Host:     net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+			Path:     "/" + c.DBName,
+			RawQuery: url.Values{"sslmode": {c.SSLMode}}.Encode(),
+		}
+	}
+
+	query := u.Query()
+	setDefault(query, "sslrootcert", c.SSLRootCert)
+	setDefault(query, "sslcert", c.SSLCert)
+	setDefault(query, "sslkey", c.SSLKey)
+	setDefault(query, "application_name", c.AppName)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// ConnURL returns the redis:// or rediss:// URL with the current password.
+func (c RedisConfig) ConnURL() (string, error) {
+	rawURL, err := readSecret(c.URL, c.URLFile)
+	if err != nil {
+		return "", err
+	}
+	password, err := readSecret(c.Password, c.PasswordFile)
+	if err != nil {
+		return "", err
+	}
+
+	if rawURL != "" {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return "", fmt.Errorf("parsing REDIS_URL: %w", err)
+		}
+		if c.PasswordFile != "" {
+			u.User = url.UserPassword(u.User.Username(), password)
+		}
+		if c.TLS {
+			u.Scheme = "rediss"
+		}
+		return u.String(), nil
+	}
+
+	u := &url.URL{
+		Scheme: "redis",
+		Host:   net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:   "/" + strconv.Itoa(c.DB),
+	}
+	if c.TLS {
+		u.Scheme = "rediss"
+	}
+	if c.User != "" || password != "" {
+		u.User = url.UserPassword(c.User, password)
+	}
+	return u.String(), nil
+}
+
+func setDefault(query url.Values, key, value string) {
+	if value != "" && query.Get(key) == "" {
+		query.Set(key, value)
+	}
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSecretFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwt")
+	write := func(content string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	write("first\n", start)
+	secret := AuthConfig{JWTSecretFile: path}.Secret()
+
+	steps := []struct {
+		name  string
+		write func()
+		want  string
+		error bool
+	}{
+		{"initial read", func() {}, "first", false},
+		{"unchanged file is cached", func() {}, "first", false},
+		{"same mtime and size is not read again", func() { write("other\n", start) }, "first", false},
+		{"rotated file", func() { write("second\n", start.Add(time.Minute)) }, "second", false},
+		{"removed file", func() { os.Remove(path) }, "", true},
+		{"restored file", func() { write("third\n", start.Add(2*time.Minute)) }, "third", false},
+	}
+	for _, step := range steps {
+		step.write()
+		got, err := secret.Secret()
+		if step.error {
+			if err == nil {
+				t.Errorf("%s: Secret() = %q, want an error", step.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", step.name, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: Secret() = %q, want %q", step.name, got, step.want)
+		}
+	}
+}
+
+func TestSecretFileValue(t *testing.T) {
+	got, err := AuthConfig{JWTSecret: "inline"}.Secret().Secret()
+	if err != nil || got != "inline" {
+		t.Errorf("Secret() = %q, %v, want %q", got, err, "inline")
+	}
+}
@@ -0,0 +1,21 @@
+package consistency
+
+import "context"
+
+const (
+	Header = "Consistency"
+	Strong = "strong"
+)
+
+type strongKey struct{}
+
+// WithStrong marks ctx so that reads go to the primary and see every write
+// committed before them, instead of a possibly lagging replica.
+func WithStrong(ctx context.Context) context.Context {
+	return context.WithValue(ctx, strongKey{}, true)
+}
+
+func IsStrong(ctx context.Context) bool {
+	strong, _ := ctx.Value(strongKey{}).(bool)
+	return strong
+}
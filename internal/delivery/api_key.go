@@ -0,0 +1,131 @@
+package delivery
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/ivanov-nikolay/user-api/internal/dto"
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/logging"
+	"github.com/ivanov-nikolay/user-api/internal/usecase"
+	"go.uber.org/zap"
+)
+
+type APIKeyHandler struct {
+	u      usecase.APIKeyUseCase
+	logger *zap.SugaredLogger
+}
+
+func NewAPIKeyHandler(u usecase.APIKeyUseCase, logger *zap.SugaredLogger) *APIKeyHandler {
+	return &APIKeyHandler{
+		u:      u,
+		logger: logger,
+	}
+}
+
+type createdAPIKey struct {
+	entity.APIKey
+	Key string
+}
+
+func (kh *APIKeyHandler) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), kh.logger)
+	keyCreateDTO := &dto.APIKeyCreate{}
+	rBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in reading request body: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(rBody, keyCreateDTO)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in decoding api key: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return
+	}
+
+	if validationErrors := keyCreateDTO.Validate(); len(validationErrors) != 0 {
+		var errorsJSON []byte
+		errorsJSON, err = json.Marshal(validationErrors)
+		if err != nil {
+			errText := fmt.Sprintf(`{"message": "error in json decoding: %s"}`, err)
+			logger.Errorf(errText)
+			writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+			return
+		}
+		writeResponse(logger, w, errorsJSON, http.StatusUnprocessableEntity)
+		return
+	}
+
+	addedKey, rawKey, err := kh.u.CreateAPIKeyUseCase(r.Context(), keyCreateDTO.ConvertToAPIKey())
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "internal server error"}`)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	keyJSON, err := encodeJSON(r.Context(), createdAPIKey{APIKey: *addedKey, Key: rawKey})
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in coding api key: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	writeResponse(logger, w, keyJSON, http.StatusOK)
+}
+
+func (kh *APIKeyHandler) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), kh.logger)
+	keys, err := kh.u.ListAPIKeysUseCase(r.Context())
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "internal server error"}`)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	if keys == nil {
+		keys = []entity.APIKey{}
+	}
+	keysJSON, err := encodeJSON(r.Context(), keys)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in coding api keys: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	writeResponse(logger, w, keysJSON, http.StatusOK)
+}
+
+func (kh *APIKeyHandler) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), kh.logger)
+	vars := mux.Vars(r)
+	keyID := vars["KEY_ID"]
+	keyIDInt, err := strconv.ParseInt(keyID, 10, 64)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "bad format of api key id: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return
+	}
+	wasRevoked, err := kh.u.RevokeAPIKeyUseCase(r.Context(), keyIDInt)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "internal server error"}`)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	if !wasRevoked {
+		errText := fmt.Sprintf(`{"message": "active api key with ID %d is not found"}`, keyIDInt)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusNotFound)
+		return
+	}
+	result := `{"result": "success"}`
+	writeResponse(logger, w, []byte(result), http.StatusOK)
+}
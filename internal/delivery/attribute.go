@@ -0,0 +1,126 @@
+package delivery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/ivanov-nikolay/user-api/internal/dto"
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/logging"
+	"github.com/ivanov-nikolay/user-api/internal/usecase"
+	"go.uber.org/zap"
+)
+
+type AttributeHandler struct {
+	u      usecase.AttributeUseCase
+	logger *zap.SugaredLogger
+}
+
+func NewAttributeHandler(u usecase.AttributeUseCase, logger *zap.SugaredLogger) *AttributeHandler {
+	return &AttributeHandler{
+		u:      u,
+		logger: logger,
+	}
+}
+
+func (ah *AttributeHandler) CreateAttributeHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), ah.logger)
+	attributeCreateDTO := &dto.AttributeCreate{}
+	rBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in reading request body: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(rBody, attributeCreateDTO)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in decoding attribute: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return
+	}
+
+	if validationErrors := attributeCreateDTO.Validate(); len(validationErrors) != 0 {
+		var errorsJSON []byte
+		errorsJSON, err = json.Marshal(validationErrors)
+		if err != nil {
+			errText := fmt.Sprintf(`{"message": "error in json decoding: %s"}`, err)
+			logger.Errorf(errText)
+			writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+			return
+		}
+		writeResponse(logger, w, errorsJSON, http.StatusUnprocessableEntity)
+		return
+	}
+
+	attr, err := ah.u.CreateAttributeUseCase(r.Context(), attributeCreateDTO.ConvertToAttribute())
+	if errors.Is(err, usecase.ErrAttributeExists) {
+		errText := fmt.Sprintf(`{"message": "%s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "internal server error"}`)
+		logger.Errorf("error in creating attribute: %s", err)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	attrJSON, err := encodeJSON(r.Context(), attr)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in coding attribute: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	writeResponse(logger, w, attrJSON, http.StatusOK)
+}
+
+func (ah *AttributeHandler) ListAttributesHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), ah.logger)
+	attrs, err := ah.u.ListAttributesUseCase(r.Context())
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "internal server error"}`)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	if attrs == nil {
+		attrs = []entity.Attribute{}
+	}
+	attrsJSON, err := encodeJSON(r.Context(), attrs)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in coding attributes: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	writeResponse(logger, w, attrsJSON, http.StatusOK)
+}
+
+// DeleteAttributeHandler removes the attribute and its values from every
+// user.
+func (ah *AttributeHandler) DeleteAttributeHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), ah.logger)
+	name := mux.Vars(r)["NAME"]
+	wasDeleted, err := ah.u.DeleteAttributeUseCase(r.Context(), name)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "internal server error"}`)
+		logger.Errorf("error in deleting attribute: %s", err)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	if !wasDeleted {
+		errText, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("attribute %s is not found", name)})
+		logger.Errorf(string(errText))
+		writeResponse(logger, w, errText, http.StatusNotFound)
+		return
+	}
+	result := `{"result": "success"}`
+	writeResponse(logger, w, []byte(result), http.StatusOK)
+}
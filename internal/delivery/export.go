@@ -0,0 +1,109 @@
+package delivery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/export"
+	"github.com/ivanov-nikolay/user-api/internal/logging"
+	"github.com/ivanov-nikolay/user-api/internal/usecase"
+)
+
+const exportFlushRows = 500
+
+// ExportUsersHandler streams the users matching the search filters. Limit
+// and Offset are optional here, without them the whole table is exported
+// within exportStatementTimeout.
+// Errors before the first row get a JSON response, later ones abort the
+// connection so that the client doesn't take a truncated file for a
+// complete one.
+func (uh *UserHandler) ExportUsersHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), uh.logger)
+	format, ok := export.Lookup(r.URL.Query().Get("format"))
+	if !ok {
+		errText := fmt.Sprintf(`{"message": "format must be csv, ndjson or xlsx"}`)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return
+	}
+	filter, err := parseFilter(r, false)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "bad filtering params: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	err = rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		logger.Warnf("export is limited by the server write timeout: %s", err)
+	}
+
+	var ew export.Writer
+	started := false
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", format.ContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="users.%s"`, format.Extension))
+		w.WriteHeader(http.StatusOK)
+		writer, err := format.NewWriter(w)
+		ew = writer
+		return err
+	}
+	rows := 0
+	err = uh.u.ExportUsersUseCase(r.Context(), filter, func(user entity.User) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if err := ew.Write(user); err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushRows == 0 {
+			if err := ew.Flush(); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+		return nil
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		err = ew.Close()
+	}
+	var filterErr *usecase.FilterError
+	if errors.As(err, &filterErr) && !started {
+		errText, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("bad filtering params: %s", filterErr)})
+		logger.Errorf(string(errText))
+		writeResponse(logger, w, errText, http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, usecase.ErrQueryTimeout) && !started {
+		errText := fmt.Sprintf(`{"message": "%s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil && !started {
+		errText := fmt.Sprintf(`{"message": "internal server error"}`)
+		logger.Errorf("error in exporting users: %s", err)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error in exporting users after %d rows: %s", rows, err)
+		panic(http.ErrAbortHandler)
+	}
+	if err = rc.Flush(); err != nil {
+		logger.Errorf("error in flushing export: %s", err)
+	}
+}
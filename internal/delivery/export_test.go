@@ -0,0 +1,88 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/filters"
+	"github.com/ivanov-nikolay/user-api/internal/usecase"
+	"go.uber.org/zap"
+)
+
+// exportUseCase hands users to the export callback and fails with err
+// once they run out.
+type exportUseCase struct {
+	usecase.UserUseCase
+	users []entity.User
+	err   error
+}
+
+func (eu exportUseCase) ExportUsersUseCase(ctx context.Context, filter filters.Filter, fn func(user entity.User) error) error {
+	for _, user := range eu.users {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return eu.err
+}
+
+func exportUsers(u usecase.UserUseCase, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/users/export?"+query, nil)
+	New(u, zap.NewNop().Sugar()).ExportUsersHandler(w, r)
+	return w
+}
+
+func TestExportUsersHandler(t *testing.T) {
+	users := []entity.User{{ID: 1, Name: "Ivan"}, {ID: 2, Name: "Olga"}}
+	w := exportUsers(exportUseCase{users: users}, "format=ndjson")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("export = %d %q, want 200 ndjson", w.Code, w.Header().Get("Content-Type"))
+	}
+	if lines := strings.Count(w.Body.String(), "\n"); lines != len(users) {
+		t.Errorf("got %d lines, want %d", lines, len(users))
+	}
+
+	w = exportUsers(exportUseCase{}, "format=csv")
+	if w.Code != http.StatusOK || w.Body.String() != "id,name,surname,patronymic,gender,status,birthday,join_date\n" {
+		t.Errorf("empty export = %d %q, want 200 with the header only", w.Code, w.Body.String())
+	}
+}
+
+func TestExportUsersHandlerErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		u     exportUseCase
+		query string
+		code  int
+	}{
+		{"unknown format", exportUseCase{}, "format=pdf", http.StatusBadRequest},
+		{"bad filter", exportUseCase{err: &usecase.FilterError{Err: errors.New("unknown attribute")}}, "format=csv", http.StatusBadRequest},
+		{"timeout before the first row", exportUseCase{err: usecase.ErrQueryTimeout}, "format=csv", http.StatusServiceUnavailable},
+		{"storage failure before the first row", exportUseCase{err: errors.New("connection reset")}, "format=csv", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := exportUsers(tt.u, tt.query)
+			if w.Code != tt.code || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+				t.Errorf("export = %d %q, want %d with a JSON body", w.Code, w.Header().Get("Content-Type"), tt.code)
+			}
+		})
+	}
+}
+
+func TestExportUsersHandlerAbortsTruncatedExport(t *testing.T) {
+	u := exportUseCase{users: []entity.User{{ID: 1, Name: "Ivan"}}, err: usecase.ErrQueryTimeout}
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", err)
+		}
+	}()
+	exportUsers(u, "format=csv")
+	t.Error("export failing after the first row was not aborted")
+}
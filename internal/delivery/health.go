@@ -0,0 +1,48 @@
+package delivery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/ivanov-nikolay/user-api/internal/health"
+	"github.com/ivanov-nikolay/user-api/internal/logging"
+	"go.uber.org/zap"
+)
+
+type HealthHandler struct {
+	checker *health.Checker
+	logger  *zap.SugaredLogger
+}
+
+func NewHealthHandler(checker *health.Checker, logger *zap.SugaredLogger) *HealthHandler {
+	return &HealthHandler{
+		checker: checker,
+		logger:  logger,
+	}
+}
+
+func (hh *HealthHandler) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), hh.logger)
+	writeResponse(logger, w, []byte(`{"status": "up"}`), http.StatusOK)
+}
+
+// ReadinessHandler reports 503 only when a critical dependency is down, so
+// that a degraded instance keeps receiving traffic.
+func (hh *HealthHandler) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), hh.logger)
+	report := hh.checker.Run(r.Context())
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in coding health report: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	statusCode := http.StatusOK
+	if report.Status == health.StatusDown {
+		logger.Errorf("service is not ready: %s", reportJSON)
+		statusCode = http.StatusServiceUnavailable
+	}
+	writeResponse(logger, w, reportJSON, statusCode)
+}
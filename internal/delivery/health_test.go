@@ -0,0 +1,39 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/health"
+	"go.uber.org/zap"
+)
+
+func TestReadinessHandler(t *testing.T) {
+	failing := func(ctx context.Context) error { return errors.New("connection refused") }
+	up := func(ctx context.Context) error { return nil }
+	tests := []struct {
+		name     string
+		critical bool
+		code     int
+	}{
+		{"critical dependency down", true, http.StatusServiceUnavailable},
+		{"non-critical dependency down", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := health.New(time.Second,
+				health.Check{Name: "postgres", Critical: true, Run: up},
+				health.Check{Name: "dependency", Critical: tt.critical, Run: failing},
+			)
+			w := httptest.NewRecorder()
+			NewHealthHandler(checker, zap.NewNop().Sugar()).ReadinessHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if w.Code != tt.code {
+				t.Errorf("code = %d, want %d, body %s", w.Code, tt.code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package delivery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/auth"
+	"github.com/ivanov-nikolay/user-api/internal/dto"
+	"github.com/ivanov-nikolay/user-api/internal/logging"
+	"github.com/ivanov-nikolay/user-api/internal/usecase"
+	"go.uber.org/zap"
+)
+
+const (
+	maxImportSize   = 32 << 20
+	maxImportMemory = 8 << 20
+	// importReadTimeout replaces the server read timeout, which is too short
+	// for a large file on a slow connection.
+	importReadTimeout = 5 * time.Minute
+)
+
+type ImportHandler struct {
+	u      usecase.ImportUseCase
+	logger *zap.SugaredLogger
+}
+
+func NewImportHandler(u usecase.ImportUseCase, logger *zap.SugaredLogger) *ImportHandler {
+	return &ImportHandler{
+		u:      u,
+		logger: logger,
+	}
+}
+
+// ImportUsersHandler accepts a multipart form with the file in the file
+// field. format is taken from the file extension when it isn't sent,
+// mapping is a JSON object from user field to column and dry_run only
+// validates the rows.
+func (ih *ImportHandler) ImportUsersHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), ih.logger)
+	err := http.NewResponseController(w).SetReadDeadline(time.Now().Add(importReadTimeout))
+	if err != nil {
+		logger.Warnf("upload is limited by the server read timeout: %s", err)
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	err = r.ParseMultipartForm(maxImportMemory)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			errText := fmt.Sprintf(`{"message": "file is too large, the limit is %d MB"}`, maxImportSize>>20)
+			logger.Errorf(errText)
+			writeResponse(logger, w, []byte(errText), http.StatusRequestEntityTooLarge)
+			return
+		}
+		errText := fmt.Sprintf(`{"message": "error in reading multipart form: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		err = r.MultipartForm.RemoveAll()
+		if err != nil {
+			logger.Errorf("error in removing uploaded files: %s", err)
+		}
+	}()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in reading file field: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	params := dto.UserImport{Format: r.FormValue("format")}
+	if params.Format == "" {
+		params.Format = importFormatByName(header.Filename)
+	}
+	if mapping := r.FormValue("mapping"); mapping != "" {
+		err = json.Unmarshal([]byte(mapping), &params.Mapping)
+		if err != nil {
+			errText := fmt.Sprintf(`{"message": "error in decoding mapping: %s"}`, err)
+			logger.Errorf(errText)
+			writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+			return
+		}
+	}
+	if dryRun := r.FormValue("dry_run"); dryRun != "" {
+		params.DryRun, err = strconv.ParseBool(dryRun)
+		if err != nil {
+			errText := fmt.Sprintf(`{"message": "param dry_run must be true or false"}`)
+			logger.Errorf(errText)
+			writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if validationErrors := params.Validate(); len(validationErrors) != 0 {
+		var errorsJSON []byte
+		errorsJSON, err = json.Marshal(validationErrors)
+		if err != nil {
+			errText := fmt.Sprintf(`{"message": "error in json decoding: %s"}`, err)
+			logger.Errorf(errText)
+			writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+			return
+		}
+		writeResponse(logger, w, errorsJSON, http.StatusUnprocessableEntity)
+		return
+	}
+
+	payload, err := io.ReadAll(file)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in reading file: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return
+	}
+
+	var createdBy string
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+		createdBy = principal.Subject
+	}
+	job, err := ih.u.StartImportUseCase(r.Context(), params, payload, createdBy)
+	var fileErr *usecase.ImportFileError
+	if errors.As(err, &fileErr) {
+		errText, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("file can't be imported: %s", fileErr)})
+		logger.Errorf(string(errText))
+		writeResponse(logger, w, errText, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "internal server error"}`)
+		logger.Errorf("error in starting import: %s", err)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	jobJSON, err := encodeJSON(r.Context(), job)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in coding job: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/jobs/%d", job.ID))
+	writeResponse(logger, w, jobJSON, http.StatusAccepted)
+}
+
+func importFormatByName(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return "csv"
+	case ".ndjson", ".jsonl":
+		return "ndjson"
+	}
+	return ""
+}
@@ -0,0 +1,229 @@
+package delivery
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/logging"
+	"github.com/ivanov-nikolay/user-api/internal/usecase"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultJobsLimit = 50
+	maxJobsLimit     = 500
+)
+
+type JobHandler struct {
+	u      usecase.JobUseCase
+	logger *zap.SugaredLogger
+}
+
+func NewJobHandler(u usecase.JobUseCase, logger *zap.SugaredLogger) *JobHandler {
+	return &JobHandler{
+		u:      u,
+		logger: logger,
+	}
+}
+
+func (jh *JobHandler) GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), jh.logger)
+	jobID, ok := parseJobID(logger, w, r)
+	if !ok {
+		return
+	}
+	job, err := jh.u.GetJobUseCase(r.Context(), jobID)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "internal server error"}`)
+		logger.Errorf("error in getting job: %s", err)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		errText := fmt.Sprintf(`{"message": "job with ID %d is not found"}`, jobID)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusNotFound)
+		return
+	}
+	jobJSON, err := encodeJSON(r.Context(), job)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in coding job: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	writeResponse(logger, w, jobJSON, http.StatusOK)
+}
+
+// ExportJobErrorsHandler streams the failed rows of a job as CSV (row,
+// errors) or as NDJSON objects.
+func (jh *JobHandler) ExportJobErrorsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), jh.logger)
+	jobID, ok := parseJobID(logger, w, r)
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "ndjson" {
+		errText := fmt.Sprintf(`{"message": "format must be csv or ndjson"}`)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		started bool
+		cw      *csv.Writer
+		enc     *json.Encoder
+	)
+	start := func() error {
+		started = true
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="job-%d-errors.%s"`, jobID, format))
+		w.WriteHeader(http.StatusOK)
+		if format == "ndjson" {
+			enc = json.NewEncoder(w)
+			return nil
+		}
+		cw = csv.NewWriter(w)
+		return cw.Write([]string{"row", "errors"})
+	}
+	found, err := jh.u.ExportJobErrorsUseCase(r.Context(), jobID, func(rowError entity.JobRowError) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if enc != nil {
+			return enc.Encode(rowError)
+		}
+		return cw.Write([]string{strconv.Itoa(rowError.Row), strings.Join(rowError.Errors, "; ")})
+	})
+	if err == nil && !found {
+		errText := fmt.Sprintf(`{"message": "job with ID %d is not found"}`, jobID)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusNotFound)
+		return
+	}
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil && cw != nil {
+		cw.Flush()
+		err = cw.Error()
+	}
+	if err != nil && !started {
+		errText := fmt.Sprintf(`{"message": "internal server error"}`)
+		logger.Errorf("error in exporting job errors: %s", err)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		logger.Errorf("error in exporting job errors: %s", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// ListJobsHandler returns the newest jobs, filtered by the status and kind
+// params. status=dead lists the dead-lettered jobs.
+func (jh *JobHandler) ListJobsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), jh.logger)
+	params := r.URL.Query()
+	limit := defaultJobsLimit
+	if limitStr := params.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > maxJobsLimit {
+			errText := fmt.Sprintf(`{"message": "param limit must be a number between 1 and %d"}`, maxJobsLimit)
+			logger.Errorf(errText)
+			writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+			return
+		}
+	}
+	jobList, err := jh.u.ListJobsUseCase(r.Context(), params.Get("status"), params.Get("kind"), limit)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "internal server error"}`)
+		logger.Errorf("error in listing jobs: %s", err)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	jobsJSON, err := encodeJSON(r.Context(), jobList)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in coding jobs: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	writeResponse(logger, w, jobsJSON, http.StatusOK)
+}
+
+func (jh *JobHandler) CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	jh.changeJob(w, r, "cancelling", jh.u.CancelJobUseCase)
+}
+
+func (jh *JobHandler) RetryJobHandler(w http.ResponseWriter, r *http.Request) {
+	jh.changeJob(w, r, "retrying", jh.u.RetryJobUseCase)
+}
+
+// changeJob responds with the changed job, or with 409 when the job is in
+// a status that doesn't allow the change.
+func (jh *JobHandler) changeJob(w http.ResponseWriter, r *http.Request, action string, change func(ctx context.Context, ID int64) (*entity.Job, error)) {
+	logger := logging.FromContext(r.Context(), jh.logger)
+	jobID, ok := parseJobID(logger, w, r)
+	if !ok {
+		return
+	}
+	job, err := change(r.Context(), jobID)
+	if errors.Is(err, usecase.ErrJobFinished) || errors.Is(err, usecase.ErrJobNotRetryable) {
+		errText := fmt.Sprintf(`{"message": "%s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "internal server error"}`)
+		logger.Errorf("error in %s job: %s", action, err)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		errText := fmt.Sprintf(`{"message": "job with ID %d is not found"}`, jobID)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusNotFound)
+		return
+	}
+	jobJSON, err := encodeJSON(r.Context(), job)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in coding job: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	writeResponse(logger, w, jobJSON, http.StatusOK)
+}
+
+func parseJobID(logger *zap.SugaredLogger, w http.ResponseWriter, r *http.Request) (int64, bool) {
+	jobID, err := strconv.ParseInt(mux.Vars(r)["JOB_ID"], 10, 64)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "bad format of job id: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return 0, false
+	}
+	return jobID, true
+}
@@ -1,11 +1,20 @@
 package delivery
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 
+	"github.com/ivanov-nikolay/user-api/internal/tracing"
 	"go.uber.org/zap"
 )
 
+func encodeJSON(ctx context.Context, v interface{}) ([]byte, error) {
+	_, span := tracing.Tracer().Start(ctx, "encode json")
+	defer span.End()
+	return json.Marshal(v)
+}
+
 func writeResponse(logger *zap.SugaredLogger, w http.ResponseWriter, dataJSON []byte, statusCode int) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("Connection", "keep-alive")
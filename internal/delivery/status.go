@@ -0,0 +1,105 @@
+package delivery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ivanov-nikolay/user-api/internal/dto"
+	"github.com/ivanov-nikolay/user-api/internal/logging"
+	"github.com/ivanov-nikolay/user-api/internal/usecase"
+)
+
+func (uh *UserHandler) BanUserHandler(w http.ResponseWriter, r *http.Request) {
+	uh.changeStatus(w, r, usecase.StatusActionBan)
+}
+
+func (uh *UserHandler) UnbanUserHandler(w http.ResponseWriter, r *http.Request) {
+	uh.changeStatus(w, r, usecase.StatusActionUnban)
+}
+
+func (uh *UserHandler) ActivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	uh.changeStatus(w, r, usecase.StatusActionActivate)
+}
+
+// changeStatus reads the optional body with the reason and applies the
+// action. A user in a status the action doesn't start from gets 409.
+func (uh *UserHandler) changeStatus(w http.ResponseWriter, r *http.Request, action string) {
+	logger := logging.FromContext(r.Context(), uh.logger)
+	userID, err := strconv.ParseInt(mux.Vars(r)["USER_ID"], 10, 64)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "bad format of user id: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return
+	}
+
+	statusDTO := &dto.UserStatusChange{}
+	rBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in reading request body: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return
+	}
+	if len(rBody) != 0 {
+		err = json.Unmarshal(rBody, statusDTO)
+		if err != nil {
+			errText := fmt.Sprintf(`{"message": "error in decoding status change: %s"}`, err)
+			logger.Errorf(errText)
+			writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if validationErrors := statusDTO.Validate(action == usecase.StatusActionBan); len(validationErrors) != 0 {
+		var errorsJSON []byte
+		errorsJSON, err = json.Marshal(validationErrors)
+		if err != nil {
+			errText := fmt.Sprintf(`{"message": "error in json decoding: %s"}`, err)
+			logger.Errorf(errText)
+			writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+			return
+		}
+		writeResponse(logger, w, errorsJSON, http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := uh.u.ChangeStatusUseCase(r.Context(), userID, action, statusDTO.Reason, statusDTO.BanEnd(time.Now()))
+	var transitionErr *usecase.TransitionError
+	if errors.As(err, &transitionErr) {
+		writeTransitionResponse(logger, w, transitionErr)
+		return
+	}
+	if errors.Is(err, usecase.ErrReasonRequired) || errors.Is(err, usecase.ErrBadBanEnd) {
+		errText := fmt.Sprintf(`{"message": "%s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "internal server error"}`)
+		logger.Errorf("error in changing user status: %s", err)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		errText := fmt.Sprintf(`{"message": "user with ID %d is not found"}`, userID)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusNotFound)
+		return
+	}
+	userJSON, err := encodeJSON(r.Context(), user)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in coding user: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	writeResponse(logger, w, userJSON, http.StatusOK)
+}
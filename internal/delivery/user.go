@@ -2,18 +2,37 @@ package delivery
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/ivanov-nikolay/user-api/internal/dto"
 	"github.com/ivanov-nikolay/user-api/internal/filters"
+	"github.com/ivanov-nikolay/user-api/internal/logging"
 	"github.com/ivanov-nikolay/user-api/internal/usecase"
 	"go.uber.org/zap"
 )
 
+// Search is paged so that one request can't read the whole table. Deep
+// offsets are rejected too, Postgres still has to scan the skipped rows.
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+	maxSearchOffset    = 10000
+)
+
+// The duplicate report is paged by candidate pairs, the self join can't
+// read the whole table in one request either.
+const (
+	defaultDuplicatesLimit = 1000
+	maxDuplicatesLimit     = 10000
+)
+
 type UserHandler struct {
 	u      usecase.UserUseCase
 	logger *zap.SugaredLogger
@@ -27,19 +46,20 @@ func New(u usecase.UserUseCase, logger *zap.SugaredLogger) *UserHandler {
 }
 
 func (uh *UserHandler) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), uh.logger)
 	userCreateDTO := &dto.UserCreate{}
 	rBody, err := io.ReadAll(r.Body)
 	if err != nil {
 		errText := fmt.Sprintf(`{"message": "error in reading request body: %s"}`, err)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusBadRequest)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
 		return
 	}
 	err = json.Unmarshal(rBody, userCreateDTO)
 	if err != nil {
 		errText := fmt.Sprintf(`{"message": "error in decoding user: %s"}`, err)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusBadRequest)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
 		return
 	}
 
@@ -48,73 +68,93 @@ func (uh *UserHandler) CreateUserHandler(w http.ResponseWriter, r *http.Request)
 		errorsJSON, err = json.Marshal(validationErrors)
 		if err != nil {
 			errText := fmt.Sprintf(`{"message": "error in json decoding: %s"}`, err)
-			uh.logger.Errorf(errText)
-			writeResponse(uh.logger, w, []byte(errText), http.StatusInternalServerError)
+			logger.Errorf(errText)
+			writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
 			return
 		}
-		writeResponse(uh.logger, w, errorsJSON, http.StatusUnprocessableEntity)
+		writeResponse(logger, w, errorsJSON, http.StatusUnprocessableEntity)
+		return
+	}
+
+	force, err := parseForce(r)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "bad format of force param: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
 		return
 	}
 
 	user := userCreateDTO.ConvertToUser()
-	addedUser, err := uh.u.CreateUserUseCase(user)
+	addedUser, err := uh.u.CreateUserUseCase(r.Context(), user, force)
+	var duplicateErr *usecase.DuplicateError
+	if errors.As(err, &duplicateErr) {
+		writeDuplicateResponse(logger, w, duplicateErr)
+		return
+	}
+	var attributeErr *usecase.AttributeError
+	if errors.As(err, &attributeErr) {
+		writeAttributeResponse(logger, w, attributeErr)
+		return
+	}
 	if err != nil {
 		errText := fmt.Sprintf(`{"message": "internal server error"}`)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusInternalServerError)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
 		return
 	}
-	userJSON, err := json.Marshal(addedUser)
+	userJSON, err := encodeJSON(r.Context(), addedUser)
 	if err != nil {
 		errText := fmt.Sprintf(`{"message": "error in coding user: %s"}`, err)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusInternalServerError)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
 		return
 	}
-	writeResponse(uh.logger, w, userJSON, http.StatusOK)
+	writeResponse(logger, w, userJSON, http.StatusOK)
 }
 
 func (uh *UserHandler) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), uh.logger)
 	vars := mux.Vars(r)
 	userID := vars["USER_ID"]
 	userIDInt, err := strconv.ParseInt(userID, 10, 64)
 	if err != nil {
 		errText := fmt.Sprintf(`{"message": "bad format of user id: %s"}`, err)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusBadRequest)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
 		return
 	}
-	wasDeleted, err := uh.u.DeleteUserUseCase(userIDInt)
+	wasDeleted, err := uh.u.DeleteUserUseCase(r.Context(), userIDInt)
 	if err != nil {
 		errText := fmt.Sprintf(`{"message": "internal server error"}`)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusInternalServerError)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
 		return
 	}
 	if !wasDeleted {
 		errText := fmt.Sprintf(`{"message": "user with ID %d is not found"}`, userIDInt)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusNotFound)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusNotFound)
 		return
 	}
 	result := `{"result": "success"}`
-	writeResponse(uh.logger, w, []byte(result), http.StatusOK)
+	writeResponse(logger, w, []byte(result), http.StatusOK)
 }
 
 func (uh *UserHandler) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), uh.logger)
 	userUpdateDTO := &dto.UserUpdate{}
 	rBody, err := io.ReadAll(r.Body)
 	if err != nil {
 		errText := fmt.Sprintf(`{"message": "error in reading request body: %s"}`, err)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusBadRequest)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
 		return
 	}
 	err = json.Unmarshal(rBody, userUpdateDTO)
 	if err != nil {
 		errText := fmt.Sprintf(`{"message": "error in decoding user: %s"}`, err)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusBadRequest)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
 		return
 	}
 
@@ -123,104 +163,324 @@ func (uh *UserHandler) UpdateUserHandler(w http.ResponseWriter, r *http.Request)
 		errorsJSON, err = json.Marshal(validationErrors)
 		if err != nil {
 			errText := fmt.Sprintf(`{"message": "error in json decoding: %s"}`, err)
-			uh.logger.Errorf(errText)
-			writeResponse(uh.logger, w, []byte(errText), http.StatusInternalServerError)
+			logger.Errorf(errText)
+			writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
 			return
 		}
-		writeResponse(uh.logger, w, errorsJSON, http.StatusUnprocessableEntity)
+		writeResponse(logger, w, errorsJSON, http.StatusUnprocessableEntity)
+		return
+	}
+
+	force, err := parseForce(r)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "bad format of force param: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
 		return
 	}
 
 	user := userUpdateDTO.ConvertToUser()
-	updatedUser, err := uh.u.UpdateUserUseCase(user)
+	updatedUser, err := uh.u.UpdateUserUseCase(r.Context(), user, force)
+	var duplicateErr *usecase.DuplicateError
+	if errors.As(err, &duplicateErr) {
+		writeDuplicateResponse(logger, w, duplicateErr)
+		return
+	}
+	var attributeErr *usecase.AttributeError
+	if errors.As(err, &attributeErr) {
+		writeAttributeResponse(logger, w, attributeErr)
+		return
+	}
+	var transitionErr *usecase.TransitionError
+	if errors.As(err, &transitionErr) {
+		writeTransitionResponse(logger, w, transitionErr)
+		return
+	}
 	if err != nil {
 		errText := fmt.Sprintf(`{"message": "internal server error"}`)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusInternalServerError)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
 		return
 	}
 	if updatedUser == nil {
 		errText := fmt.Sprintf(`{"message": "user with ID %d is not found"}`, user.ID)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusNotFound)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusNotFound)
 		return
 	}
-	userJSON, err := json.Marshal(updatedUser)
+	userJSON, err := encodeJSON(r.Context(), updatedUser)
 	if err != nil {
 		errText := fmt.Sprintf(`{"message": "error in coding user: %s"}`, err)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusInternalServerError)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
 		return
 	}
-	writeResponse(uh.logger, w, userJSON, http.StatusOK)
+	writeResponse(logger, w, userJSON, http.StatusOK)
 }
 
 func (uh *UserHandler) GetUserByIDHandlerID(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), uh.logger)
 	vars := mux.Vars(r)
 	userID := vars["USER_ID"]
 	userIDInt, err := strconv.ParseInt(userID, 10, 64)
 	if err != nil {
 		errText := fmt.Sprintf(`{"message": "bad format of user id: %s"}`, err)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusBadRequest)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return
+	}
+	user, err := uh.u.GetUserByIDUseCase(r.Context(), userIDInt)
+	var mergedErr *usecase.MergedError
+	if errors.As(err, &mergedErr) {
+		w.Header().Set("Location", fmt.Sprintf("/user/%d", mergedErr.SurvivorID))
+		result := fmt.Sprintf(`{"message": "user with ID %d was merged into user with ID %d", "merged_into": %d}`,
+			userIDInt, mergedErr.SurvivorID, mergedErr.SurvivorID)
+		writeResponse(logger, w, []byte(result), http.StatusMovedPermanently)
 		return
 	}
-	user, err := uh.u.GetUserByIDUseCase(userIDInt)
 	if err != nil {
 		errText := fmt.Sprintf(`{"message": "internal server error"}`)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusInternalServerError)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
 		return
 	}
 	if user == nil {
 		errText := fmt.Sprintf(`{"message": "user with ID %d is not found"}`, userIDInt)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusNotFound)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusNotFound)
 		return
 	}
 
-	userJSON, err := json.Marshal(user)
+	userJSON, err := encodeJSON(r.Context(), user)
 	if err != nil {
 		errText := fmt.Sprintf(`{"message": "error in coding user: %s"}`, err)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusInternalServerError)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
 		return
 	}
-	writeResponse(uh.logger, w, userJSON, http.StatusOK)
+	writeResponse(logger, w, userJSON, http.StatusOK)
 }
 
 func (uh *UserHandler) SearchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), uh.logger)
 	filter, err := parseFilterFromRequest(r)
 	if err != nil {
 		errText := fmt.Sprintf(`{"message": "bad filtering params: %s"}`, err)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusBadRequest)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return
+	}
+	users, err := uh.u.SearchUsersUseCase(r.Context(), filter)
+	var filterErr *usecase.FilterError
+	if errors.As(err, &filterErr) {
+		errText, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("bad filtering params: %s", filterErr)})
+		logger.Errorf(string(errText))
+		writeResponse(logger, w, errText, http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, usecase.ErrQueryTimeout) {
+		errText := fmt.Sprintf(`{"message": "%s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusServiceUnavailable)
 		return
 	}
-	users, err := uh.u.SearchUsersUseCase(filter)
 	if err != nil {
 		errText := fmt.Sprintf(`{"message": "internal server error"}`)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusInternalServerError)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
 		return
 	}
 	if users == nil {
 		errText := fmt.Sprintf(`{"message": "users are not found"}`)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusNotFound)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusNotFound)
 		return
 	}
-	userJSON, err := json.Marshal(users)
+	userJSON, err := encodeJSON(r.Context(), users)
 	if err != nil {
 		errText := fmt.Sprintf(`{"message": "error in coding users: %s"}`, err)
-		uh.logger.Errorf(errText)
-		writeResponse(uh.logger, w, []byte(errText), http.StatusInternalServerError)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
 		return
 	}
-	writeResponse(uh.logger, w, userJSON, http.StatusOK)
+	writeResponse(logger, w, userJSON, http.StatusOK)
+}
+
+func (uh *UserHandler) MergeUsersHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), uh.logger)
+	userMergeDTO := &dto.UserMerge{}
+	rBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in reading request body: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(rBody, userMergeDTO)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in decoding merge: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+		return
+	}
+
+	if validationErrors := userMergeDTO.Validate(); len(validationErrors) != 0 {
+		var errorsJSON []byte
+		errorsJSON, err = json.Marshal(validationErrors)
+		if err != nil {
+			errText := fmt.Sprintf(`{"message": "error in json decoding: %s"}`, err)
+			logger.Errorf(errText)
+			writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+			return
+		}
+		writeResponse(logger, w, errorsJSON, http.StatusUnprocessableEntity)
+		return
+	}
+
+	mergedUser, err := uh.u.MergeUsersUseCase(r.Context(), userMergeDTO.SurvivorID, userMergeDTO.VictimID, userMergeDTO.Fields)
+	if errors.Is(err, usecase.ErrAlreadyMerged) {
+		errText := fmt.Sprintf(`{"message": "%s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusConflict)
+		return
+	}
+	var transitionErr *usecase.TransitionError
+	if errors.As(err, &transitionErr) {
+		writeTransitionResponse(logger, w, transitionErr)
+		return
+	}
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "internal server error"}`)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	if mergedUser == nil {
+		errText := fmt.Sprintf(`{"message": "user with ID %d or %d is not found"}`, userMergeDTO.SurvivorID, userMergeDTO.VictimID)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusNotFound)
+		return
+	}
+	userJSON, err := encodeJSON(r.Context(), mergedUser)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in coding user: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	writeResponse(logger, w, userJSON, http.StatusOK)
+}
+
+// FindDuplicatesHandler returns a page of duplicate clusters. The page is
+// chosen with the limit and after params, the next one is in the Link
+// header.
+func (uh *UserHandler) FindDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), uh.logger)
+	params := r.URL.Query()
+	limit := defaultDuplicatesLimit
+	if limitStr := params.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > maxDuplicatesLimit {
+			errText := fmt.Sprintf(`{"message": "param limit must be a number between 1 and %d"}`, maxDuplicatesLimit)
+			logger.Errorf(errText)
+			writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+			return
+		}
+	}
+	var after [2]int64
+	if afterStr := params.Get("after"); afterStr != "" {
+		first, second, ok := strings.Cut(afterStr, ":")
+		var err1, err2 error
+		after[0], err1 = strconv.ParseInt(first, 10, 64)
+		after[1], err2 = strconv.ParseInt(second, 10, 64)
+		if !ok || err1 != nil || err2 != nil {
+			errText := fmt.Sprintf(`{"message": "param after must be two user ids like 12:40"}`)
+			logger.Errorf(errText)
+			writeResponse(logger, w, []byte(errText), http.StatusBadRequest)
+			return
+		}
+	}
+
+	clusters, next, err := uh.u.FindDuplicateClustersUseCase(r.Context(), after, limit)
+	if errors.Is(err, usecase.ErrQueryTimeout) {
+		errText := fmt.Sprintf(`{"message": "query took too long, use a smaller limit"}`)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "internal server error"}`)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	clustersJSON, err := encodeJSON(r.Context(), clusters)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in coding duplicates: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	if next != nil {
+		w.Header().Set("Link", fmt.Sprintf(`</users/duplicates?after=%d:%d&limit=%d>; rel="next"`, next[0], next[1], limit))
+	}
+	writeResponse(logger, w, clustersJSON, http.StatusOK)
+}
+
+func writeDuplicateResponse(logger *zap.SugaredLogger, w http.ResponseWriter, duplicateErr *usecase.DuplicateError) {
+	IDsJSON, err := json.Marshal(duplicateErr.IDs)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in coding duplicate ids: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	errText := fmt.Sprintf(`{"message": "user is a likely duplicate, repeat with force=true to save it anyway", "duplicate_ids": %s}`, IDsJSON)
+	writeResponse(logger, w, []byte(errText), http.StatusConflict)
+}
+
+func writeTransitionResponse(logger *zap.SugaredLogger, w http.ResponseWriter, transitionErr *usecase.TransitionError) {
+	errText, err := json.Marshal(map[string]string{
+		"message": transitionErr.Error(),
+		"from":    transitionErr.From,
+		"to":      transitionErr.To,
+	})
+	if err != nil {
+		logger.Errorf("error in coding transition error: %s", err)
+		writeResponse(logger, w, []byte(`{"message": "internal server error"}`), http.StatusInternalServerError)
+		return
+	}
+	logger.Errorf(string(errText))
+	writeResponse(logger, w, errText, http.StatusConflict)
+}
+
+func writeAttributeResponse(logger *zap.SugaredLogger, w http.ResponseWriter, attributeErr *usecase.AttributeError) {
+	errorsJSON, err := json.Marshal(attributeErr.Errors)
+	if err != nil {
+		errText := fmt.Sprintf(`{"message": "error in json decoding: %s"}`, err)
+		logger.Errorf(errText)
+		writeResponse(logger, w, []byte(errText), http.StatusInternalServerError)
+		return
+	}
+	writeResponse(logger, w, errorsJSON, http.StatusUnprocessableEntity)
+}
+
+func parseForce(r *http.Request) (bool, error) {
+	force := r.URL.Query().Get("force")
+	if force == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(force)
 }
 
 func parseFilterFromRequest(r *http.Request) (filters.Filter, error) {
+	return parseFilter(r, true)
+}
+
+// parseFilter reads the search filters. Unless paged, Limit and Offset are
+// optional and not capped.
+func parseFilter(r *http.Request, paged bool) (filters.Filter, error) {
 	var filter filters.Filter
 	params := r.URL.Query()
 
@@ -248,40 +508,51 @@ func parseFilterFromRequest(r *http.Request) (filters.Filter, error) {
 	attributesToSort := params.Get("AttributesToSort")
 	filter.AttributesToSort = attributesToSort
 
-	if filter.SortAsk || filter.SortDesc {
-		if filter.AttributesToSort == "" {
-			return filter, fmt.Errorf("sorting param is not set")
-
-		}
-		switch filter.AttributesToSort {
-		case "id":
-			break
-		case "name":
-			break
-		case "surname":
-			break
-		case "patronymic":
-			break
-		case "gender":
-			break
-		case "status":
-			break
-		case "birthday":
-			break
-		case "join_date":
-			break
-		default:
+	if (filter.SortAsk || filter.SortDesc) && filter.AttributesToSort == "" {
+		return filter, fmt.Errorf("sorting param is not set")
+	}
+	if filter.AttributesToSort != "" {
+		_, isColumn := filters.SortColumns[filter.AttributesToSort]
+		if !isColumn && !strings.HasPrefix(filter.AttributesToSort, filters.AttributePrefix) {
 			return filter, fmt.Errorf("unknown sorting param")
 		}
 	}
 
-	limitStr := params.Get("Limit")
-	if limit, err := strconv.Atoi(limitStr); err == nil {
+	// Attribute names are checked by the usecase, they are sorted so that
+	// the same filters always build the same query.
+	for param, paramValues := range params {
+		name, ok := strings.CutPrefix(param, filters.AttributePrefix)
+		if !ok {
+			continue
+		}
+		filter.Attributes = append(filter.Attributes, filters.AttributeFilter{Name: name, Raw: paramValues[0]})
+	}
+	sort.Slice(filter.Attributes, func(i, j int) bool {
+		return filter.Attributes[i].Name < filter.Attributes[j].Name
+	})
+
+	if paged {
+		filter.Limit = defaultSearchLimit
+	}
+	if limitStr := params.Get("Limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("param Limit must be a positive number")
+		}
+		if paged && limit > maxSearchLimit {
+			return filter, fmt.Errorf("param Limit must not exceed %d", maxSearchLimit)
+		}
 		filter.Limit = limit
 	}
 
-	offsetStr := params.Get("Offset")
-	if offset, err := strconv.Atoi(offsetStr); err == nil {
+	if offsetStr := params.Get("Offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			return filter, fmt.Errorf("param Offset must be a non-negative number")
+		}
+		if paged && offset > maxSearchOffset {
+			return filter, fmt.Errorf("param Offset must not exceed %d, narrow the filters instead", maxSearchOffset)
+		}
 		filter.Offset = offset
 	}
 
@@ -0,0 +1,60 @@
+package delivery
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseFilterLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		paged  bool
+		limit  int
+		offset int
+		ok     bool
+	}{
+		{"default limit", "", true, defaultSearchLimit, 0, true},
+		{"limit and offset", "Limit=20&Offset=40", true, 20, 40, true},
+		{"largest page", "Limit=500&Offset=10000", true, maxSearchLimit, maxSearchOffset, true},
+		{"limit too large", "Limit=501", true, 0, 0, false},
+		{"offset too deep", "Offset=10001", true, 0, 0, false},
+		{"zero limit", "Limit=0", true, 0, 0, false},
+		{"negative offset", "Offset=-1", true, 0, 0, false},
+		{"limit not a number", "Limit=ten", true, 0, 0, false},
+		{"export without limit", "", false, 0, 0, true},
+		{"export with a large limit", "Limit=100000&Offset=50000", false, 100000, 50000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/users?"+tt.query, nil)
+			filter, err := parseFilter(r, tt.paged)
+			if (err == nil) != tt.ok {
+				t.Fatalf("parseFilter() error = %v, want ok = %v", err, tt.ok)
+			}
+			if tt.ok && (filter.Limit != tt.limit || filter.Offset != tt.offset) {
+				t.Errorf("Limit, Offset = %d, %d, want %d, %d", filter.Limit, filter.Offset, tt.limit, tt.offset)
+			}
+		})
+	}
+}
+
+func TestParseFilterSort(t *testing.T) {
+	tests := []struct {
+		query string
+		ok    bool
+	}{
+		{"AttributesToSort=surname&SortAsk=true", true},
+		{"AttributesToSort=attr.hired_on&SortDesc=true", true},
+		{"AttributesToSort=password&SortAsk=true", false},
+		{"SortAsk=true", false},
+		{"AttributesToSort=name&SortAsk=true&SortDesc=true", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/users?"+tt.query, nil)
+		if _, err := parseFilterFromRequest(r); (err == nil) != tt.ok {
+			t.Errorf("parseFilterFromRequest(%q) error = %v, want ok = %v", tt.query, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/ivanov-nikolay/user-api/internal/auth"
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+type APIKeyCreate struct {
+	Name      string    `json:"name" valid:"required,length(2|255)"`
+	Scopes    []string  `json:"scopes" valid:"required"`
+	ExpiresAt time.Time `json:"expires_at" valid:"optional"`
+}
+
+func (k *APIKeyCreate) Validate() []string {
+	_, err := govalidator.ValidateStruct(k)
+	validationErrors := collectErrors(err)
+	for _, scope := range k.Scopes {
+		if !auth.IsKnownScope(scope) {
+			validationErrors = append(validationErrors, "scopes: unknown scope "+scope)
+		}
+	}
+	if !k.ExpiresAt.IsZero() && k.ExpiresAt.Before(time.Now()) {
+		validationErrors = append(validationErrors, "expires_at: must be in the future")
+	}
+	return validationErrors
+}
+
+func (k *APIKeyCreate) ConvertToAPIKey() entity.APIKey {
+	return entity.APIKey{
+		Name:      k.Name,
+		Scopes:    k.Scopes,
+		ExpiresAt: k.ExpiresAt,
+	}
+}
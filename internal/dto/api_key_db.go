@@ -0,0 +1,39 @@
+package dto
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+type APIKeyDB struct {
+	ID         int64
+	Name       string
+	Prefix     string
+	Scopes     string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time
+	RevokedAt  *time.Time
+}
+
+func (k *APIKeyDB) ConvertToAPIKey() entity.APIKey {
+	return entity.APIKey{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     strings.Fields(k.Scopes),
+		CreatedAt:  k.CreatedAt,
+		LastUsedAt: timeOrZero(k.LastUsedAt),
+		ExpiresAt:  timeOrZero(k.ExpiresAt),
+		RevokedAt:  timeOrZero(k.RevokedAt),
+	}
+}
+
+func timeOrZero(tm *time.Time) time.Time {
+	if tm == nil {
+		return time.Time{}
+	}
+	return *tm
+}
@@ -0,0 +1,75 @@
+package dto
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+// AttributeCreate registers an attribute. The name is used in search params
+// and in the name of the unique index, so it is limited to lowercase
+// letters, digits and underscores.
+type AttributeCreate struct {
+	Name     string         `json:"name" valid:"required,length(1|40),matches(^[a-z][a-z0-9_]*$)"`
+	Type     string         `json:"type" valid:"required,in(string|integer|number|boolean|date)"`
+	Required bool           `json:"required"`
+	Unique   bool           `json:"unique"`
+	Rules    AttributeRules `json:"rules"`
+}
+
+type AttributeRules struct {
+	MinLength *int     `json:"min_length"`
+	MaxLength *int     `json:"max_length"`
+	Pattern   string   `json:"pattern"`
+	Enum      []string `json:"enum"`
+	Min       *float64 `json:"min"`
+	Max       *float64 `json:"max"`
+}
+
+func (a *AttributeCreate) Validate() []string {
+	_, err := govalidator.ValidateStruct(a)
+	validationErrors := collectErrors(err)
+	rules := a.Rules
+	isString := a.Type == entity.AttributeTypeString
+	isNumber := a.Type == entity.AttributeTypeInteger || a.Type == entity.AttributeTypeNumber
+	if !isString && (rules.MinLength != nil || rules.MaxLength != nil || rules.Pattern != "" || rules.Enum != nil) {
+		validationErrors = append(validationErrors, "rules: min_length, max_length, pattern and enum apply only to strings")
+	}
+	if !isNumber && (rules.Min != nil || rules.Max != nil) {
+		validationErrors = append(validationErrors, "rules: min and max apply only to integers and numbers")
+	}
+	if rules.MinLength != nil && *rules.MinLength < 0 {
+		validationErrors = append(validationErrors, "rules: min_length must not be negative")
+	}
+	if rules.MinLength != nil && rules.MaxLength != nil && *rules.MinLength > *rules.MaxLength {
+		validationErrors = append(validationErrors, "rules: min_length must not exceed max_length")
+	}
+	if rules.Min != nil && rules.Max != nil && *rules.Min > *rules.Max {
+		validationErrors = append(validationErrors, "rules: min must not exceed max")
+	}
+	if rules.Pattern != "" {
+		if _, err = regexp.Compile(rules.Pattern); err != nil {
+			validationErrors = append(validationErrors, fmt.Sprintf("rules: bad pattern: %s", err))
+		}
+	}
+	return validationErrors
+}
+
+func (a *AttributeCreate) ConvertToAttribute() entity.Attribute {
+	return entity.Attribute{
+		Name:     a.Name,
+		Type:     a.Type,
+		Required: a.Required,
+		Unique:   a.Unique,
+		Rules: entity.AttributeRules{
+			MinLength: a.Rules.MinLength,
+			MaxLength: a.Rules.MaxLength,
+			Pattern:   a.Rules.Pattern,
+			Enum:      a.Rules.Enum,
+			Min:       a.Rules.Min,
+			Max:       a.Rules.Max,
+		},
+	}
+}
@@ -0,0 +1,58 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+type JobDB struct {
+	ID              int64
+	Kind            string
+	Status          string
+	Params          []byte
+	TotalRows       int
+	ProcessedRows   int
+	SucceededRows   int
+	FailedRows      int
+	Attempts        int
+	MaxAttempts     int
+	RunAt           time.Time
+	CancelRequested bool
+	Error           *string
+	CreatedBy       *string
+	CreatedAt       time.Time
+	StartedAt       *time.Time
+	FinishedAt      *time.Time
+}
+
+func (j *JobDB) ConvertToJob() entity.Job {
+	var errText, createdBy string
+	if j.Error != nil {
+		errText = *j.Error
+	}
+	if j.CreatedBy != nil {
+		createdBy = *j.CreatedBy
+	}
+	return entity.Job{
+		ID:     j.ID,
+		Kind:   j.Kind,
+		Status: j.Status,
+		Params: j.Params,
+		Progress: entity.JobProgress{
+			Total:     j.TotalRows,
+			Processed: j.ProcessedRows,
+			Succeeded: j.SucceededRows,
+			Failed:    j.FailedRows,
+		},
+		Attempts:        j.Attempts,
+		MaxAttempts:     j.MaxAttempts,
+		RunAt:           j.RunAt,
+		CancelRequested: j.CancelRequested,
+		Error:           errText,
+		CreatedBy:       createdBy,
+		CreatedAt:       j.CreatedAt,
+		StartedAt:       timeOrZero(j.StartedAt),
+		FinishedAt:      timeOrZero(j.FinishedAt),
+	}
+}
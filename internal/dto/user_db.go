@@ -15,6 +15,12 @@ type UserDB struct {
 	Status     string
 	Birthday   *time.Time
 	JoinDate   time.Time
+	MergedInto *int64
+
+	StatusChangedAt time.Time
+	BannedUntil     *time.Time
+	BanReason       *string
+	Attributes      map[string]interface{}
 }
 
 func (u *UserDB) ConvertToUser() entity.User {
@@ -31,6 +37,21 @@ func (u *UserDB) ConvertToUser() entity.User {
 	} else {
 		bDay = time.Time{}
 	}
+
+	var bannedUntil time.Time
+	if u.BannedUntil != nil {
+		bannedUntil = *u.BannedUntil
+	}
+
+	var banReason string
+	if u.BanReason != nil {
+		banReason = *u.BanReason
+	}
+
+	var mergedInto int64
+	if u.MergedInto != nil {
+		mergedInto = *u.MergedInto
+	}
 	return entity.User{
 		ID:         u.ID,
 		Name:       u.Name,
@@ -40,5 +61,11 @@ func (u *UserDB) ConvertToUser() entity.User {
 		Status:     u.Status,
 		Birthday:   bDay,
 		JoinDate:   u.JoinDate,
+		MergedInto: mergedInto,
+
+		StatusChangedAt: u.StatusChangedAt,
+		BannedUntil:     bannedUntil,
+		BanReason:       banReason,
+		Attributes:      u.Attributes,
 	}
 }
@@ -0,0 +1,111 @@
+package dto
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+// ImportFields are the user fields a column of an import file can be
+// mapped to.
+var ImportFields = []string{"name", "surname", "patronymic", "gender", "status", "birthday"}
+
+// ImportAttributePrefix starts the fields of registered attributes, for
+// example attr.department is read from the column attr.department unless
+// it is mapped to another one.
+const ImportAttributePrefix = "attr."
+
+var importAttributePattern = regexp.MustCompile(`^attr\.[a-z][a-z0-9_]*$`)
+
+// RequiredImportFields must be present in every row, a file without them
+// is rejected before the job is created.
+var RequiredImportFields = []string{"name", "surname", "gender", "status"}
+
+// UserImport describes an upload. Mapping maps a user field to the column
+// (CSV) or key (NDJSON) it is read from, fields that are not mapped are
+// read from the column with the same name.
+type UserImport struct {
+	Format  string            `json:"format"`
+	Mapping map[string]string `json:"mapping"`
+	DryRun  bool              `json:"dry_run"`
+}
+
+func (ui *UserImport) Validate() []string {
+	validationErrors := make([]string, 0)
+	if ui.Format != "csv" && ui.Format != "ndjson" {
+		validationErrors = append(validationErrors, "format: must be csv or ndjson")
+	}
+	for field, source := range ui.Mapping {
+		if !isImportField(field) && !importAttributePattern.MatchString(field) {
+			validationErrors = append(validationErrors, fmt.Sprintf("mapping: unknown field %q, known fields are %s and attr.<name>", field, strings.Join(ImportFields, ", ")))
+		}
+		if source == "" {
+			validationErrors = append(validationErrors, fmt.Sprintf("mapping: empty source for field %q", field))
+		}
+	}
+	return validationErrors
+}
+
+// Sources returns the column or key every import field is read from,
+// attributes are the names of the registered attributes.
+func (ui *UserImport) Sources(attributes []string) map[string]string {
+	sources := make(map[string]string, len(ImportFields)+len(attributes))
+	for _, field := range ImportFields {
+		sources[field] = field
+	}
+	for _, name := range attributes {
+		sources[ImportAttributePrefix+name] = ImportAttributePrefix + name
+	}
+	for field := range sources {
+		if source, ok := ui.Mapping[field]; ok {
+			sources[field] = source
+		}
+	}
+	return sources
+}
+
+func isImportField(field string) bool {
+	for _, f := range ImportFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// UserCreateFromImport builds a user from the values of one imported row
+// and validates it with the same rules as POST /user. Imported users must be
+// active: an import needs only users:write, and a ban needs a reason the
+// file doesn't have.
+func UserCreateFromImport(values map[string]string) (*UserCreate, []string) {
+	user := &UserCreate{
+		Name:       values["name"],
+		Surname:    values["surname"],
+		Patronymic: values["patronymic"],
+		Gender:     values["gender"],
+		Status:     values["status"],
+	}
+	var validationErrors []string
+	if birthday := values["birthday"]; birthday != "" {
+		bDay, err := parseImportDate(birthday)
+		if err != nil {
+			validationErrors = append(validationErrors, fmt.Sprintf("birthday: %q is not a date, use 2006-01-02", birthday))
+		}
+		user.Birthday = bDay
+	}
+	validationErrors = append(validationErrors, user.Validate()...)
+	if user.Status == entity.UserStatusBanned || user.Status == entity.UserStatusDeleted {
+		validationErrors = append(validationErrors, fmt.Sprintf("status: %q can't be imported, only active users are", user.Status))
+	}
+	return user, validationErrors
+}
+
+func parseImportDate(value string) (time.Time, error) {
+	if tm, err := time.Parse(time.DateOnly, value); err == nil {
+		return tm, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
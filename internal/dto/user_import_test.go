@@ -0,0 +1,40 @@
+package dto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUserCreateFromImport(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   map[string]string
+		errCount int
+	}{
+		{"active", map[string]string{"name": "Ivan", "surname": "Petrov", "gender": "male", "status": "active", "birthday": "1990-05-01"}, 0},
+		{"banned", map[string]string{"name": "Ivan", "surname": "Petrov", "gender": "male", "status": "banned"}, 1},
+		{"deleted", map[string]string{"name": "Ivan", "surname": "Petrov", "gender": "male", "status": "deleted"}, 1},
+		{"unknown status", map[string]string{"name": "Ivan", "surname": "Petrov", "gender": "male", "status": "frozen"}, 1},
+		{"bad birthday", map[string]string{"name": "Ivan", "surname": "Petrov", "gender": "male", "status": "active", "birthday": "01.05.1990"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, validationErrors := UserCreateFromImport(tt.values)
+			if len(validationErrors) != tt.errCount {
+				t.Errorf("UserCreateFromImport() errors = %q, want %d", validationErrors, tt.errCount)
+			}
+		})
+	}
+}
+
+func TestUserCreateFromImportBirthday(t *testing.T) {
+	user, validationErrors := UserCreateFromImport(map[string]string{
+		"name": "Ivan", "surname": "Petrov", "gender": "male", "status": "active", "birthday": "1990-05-01T10:00:00Z",
+	})
+	if len(validationErrors) != 0 {
+		t.Fatalf("UserCreateFromImport() errors = %q", validationErrors)
+	}
+	if want := time.Date(1990, 5, 1, 10, 0, 0, 0, time.UTC); !user.Birthday.Equal(want) {
+		t.Errorf("Birthday = %v, want %v", user.Birthday, want)
+	}
+}
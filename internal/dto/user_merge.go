@@ -0,0 +1,49 @@
+package dto
+
+import (
+	"fmt"
+
+	"github.com/asaskevich/govalidator"
+)
+
+const (
+	MergeFromSurvivor = "survivor"
+	MergeFromVictim   = "victim"
+)
+
+var MergeableFields = []string{"name", "surname", "patronymic", "gender", "status", "birthday", "join_date"}
+
+// UserMerge describes merging the victim into the survivor. Fields maps a
+// field name to the user its value is taken from; fields that are not
+// listed keep the survivor's value.
+type UserMerge struct {
+	SurvivorID int64             `json:"survivor_id" valid:"required"`
+	VictimID   int64             `json:"victim_id" valid:"required"`
+	Fields     map[string]string `json:"fields" valid:"optional"`
+}
+
+func (m *UserMerge) Validate() []string {
+	_, err := govalidator.ValidateStruct(m)
+	validationErrors := collectErrors(err)
+	if m.SurvivorID == m.VictimID {
+		validationErrors = append(validationErrors, "victim_id: must differ from survivor_id")
+	}
+	for field, from := range m.Fields {
+		if !isMergeableField(field) {
+			validationErrors = append(validationErrors, fmt.Sprintf("fields: unknown field %s", field))
+		}
+		if from != MergeFromSurvivor && from != MergeFromVictim {
+			validationErrors = append(validationErrors, fmt.Sprintf("fields: %s must be taken from survivor or victim", field))
+		}
+	}
+	return validationErrors
+}
+
+func isMergeableField(field string) bool {
+	for _, f := range MergeableFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
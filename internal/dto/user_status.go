@@ -0,0 +1,76 @@
+package dto
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserStatusChange is the body of the status endpoints. The reason is kept
+// in the history of the user, banning requires it. A ban lasts for Duration
+// (for example 7d or 36h) or until Until, without either it is permanent.
+type UserStatusChange struct {
+	Reason   string    `json:"reason"`
+	Duration string    `json:"duration"`
+	Until    time.Time `json:"until"`
+}
+
+func (u *UserStatusChange) Validate(banning bool) []string {
+	validationErrors := make([]string, 0)
+	u.Reason = strings.TrimSpace(u.Reason)
+	if banning && u.Reason == "" {
+		validationErrors = append(validationErrors, "reason: non zero value required")
+	}
+	if len(u.Reason) > 500 {
+		validationErrors = append(validationErrors, "reason: must be at most 500 characters")
+	}
+	if !banning && (u.Duration != "" || !u.Until.IsZero()) {
+		validationErrors = append(validationErrors, "duration, until: only a ban can have an end")
+	}
+	if u.Duration != "" && !u.Until.IsZero() {
+		validationErrors = append(validationErrors, "duration, until: only one of them can be set")
+	}
+	if u.Duration != "" {
+		if _, err := parseBanDuration(u.Duration); err != nil {
+			validationErrors = append(validationErrors, fmt.Sprintf("duration: %s", err))
+		}
+	}
+	if !u.Until.IsZero() && !u.Until.After(time.Now()) {
+		validationErrors = append(validationErrors, "until: must be in the future")
+	}
+	return validationErrors
+}
+
+// BanEnd returns when the ban ends if it starts at now, zero for a
+// permanent ban. It must be called after Validate.
+func (u *UserStatusChange) BanEnd(now time.Time) time.Time {
+	if u.Duration != "" {
+		duration, _ := parseBanDuration(u.Duration)
+		return now.Add(duration)
+	}
+	return u.Until
+}
+
+// parseBanDuration accepts a number of days with the d suffix or anything
+// time.ParseDuration accepts.
+func parseBanDuration(s string) (time.Duration, error) {
+	var (
+		duration time.Duration
+		err      error
+	)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		duration = time.Duration(n) * 24 * time.Hour
+	} else {
+		duration, err = time.ParseDuration(s)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("must be a number of days like 7d or a duration like 36h")
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return duration, nil
+}
@@ -15,6 +15,8 @@ type UserUpdate struct {
 	Gender     string    `json:"gender" valid:"required,in(male|female)"`
 	Status     string    `json:"status" valid:"required,in(active|banned|deleted)"`
 	Birthday   time.Time `json:"b_day" valid:"optional"`
+	// Attributes are checked against the registry by the usecase.
+	Attributes map[string]interface{} `json:"attributes" valid:"-"`
 }
 
 func (u *UserUpdate) Validate() []string {
@@ -31,5 +33,6 @@ func (u *UserUpdate) ConvertToUser() entity.User {
 		Gender:     u.Gender,
 		Status:     u.Status,
 		Birthday:   u.Birthday,
+		Attributes: u.Attributes,
 	}
 }
@@ -0,0 +1,14 @@
+package entity
+
+import "time"
+
+type APIKey struct {
+	ID         int64
+	Name       string
+	Prefix     string
+	Scopes     []string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  time.Time
+}
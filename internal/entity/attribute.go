@@ -0,0 +1,34 @@
+package entity
+
+import "time"
+
+const (
+	AttributeTypeString  = "string"
+	AttributeTypeInteger = "integer"
+	AttributeTypeNumber  = "number"
+	AttributeTypeBoolean = "boolean"
+	// AttributeTypeDate is a date in the 2006-01-02 format.
+	AttributeTypeDate = "date"
+)
+
+// Attribute is a registered user attribute. Values of attributes are kept
+// in User.Attributes and checked against the registry on every write.
+type Attribute struct {
+	Name      string
+	Type      string
+	Required  bool
+	Unique    bool
+	Rules     AttributeRules
+	CreatedAt time.Time
+}
+
+// AttributeRules restrict the values of an attribute. Length, Pattern and
+// Enum apply to strings, Min and Max to numbers.
+type AttributeRules struct {
+	MinLength *int     `json:",omitempty"`
+	MaxLength *int     `json:",omitempty"`
+	Pattern   string   `json:",omitempty"`
+	Enum      []string `json:",omitempty"`
+	Min       *float64 `json:",omitempty"`
+	Max       *float64 `json:",omitempty"`
+}
@@ -0,0 +1,58 @@
+package entity
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	JobKindImportUsers = "import_users"
+
+	JobStatusPending   = "pending"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	// JobStatusFailed is a job that failed with an error retrying won't fix.
+	JobStatusFailed = "failed"
+	// JobStatusDead is a job that failed MaxAttempts times in a row.
+	JobStatusDead      = "dead"
+	JobStatusCancelled = "cancelled"
+)
+
+// Job is a unit of background work. Error is the error of the last attempt,
+// it is kept while the job waits for a retry.
+type Job struct {
+	ID              int64
+	Kind            string
+	Status          string
+	Params          json.RawMessage
+	Progress        JobProgress
+	Attempts        int
+	MaxAttempts     int
+	RunAt           time.Time
+	CancelRequested bool   `json:",omitempty"`
+	Error           string `json:",omitempty"`
+	CreatedBy       string `json:",omitempty"`
+	CreatedAt       time.Time
+	StartedAt       time.Time
+	FinishedAt      time.Time
+}
+
+// Finished reports whether the job won't run anymore unless it is retried
+// by hand.
+func (j *Job) Finished() bool {
+	return j.Status != JobStatusPending && j.Status != JobStatusRunning
+}
+
+// JobProgress counts rows of the job input. Processed rows are either
+// succeeded or failed.
+type JobProgress struct {
+	Total     int
+	Processed int
+	Succeeded int
+	Failed    int
+}
+
+type JobRowError struct {
+	Row    int
+	Errors []string
+}
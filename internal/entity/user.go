@@ -2,6 +2,12 @@ package entity
 
 import "time"
 
+const (
+	UserStatusActive  = "active"
+	UserStatusBanned  = "banned"
+	UserStatusDeleted = "deleted"
+)
+
 type User struct {
 	ID         int64
 	Name       string
@@ -11,4 +17,14 @@ type User struct {
 	Status     string
 	Birthday   time.Time
 	JoinDate   time.Time
+	MergedInto int64 `json:",omitempty"`
+	// StatusChangedAt is when the user got the current status, the join
+	// date if the status was never changed.
+	StatusChangedAt time.Time
+	// BannedUntil is when a temporary ban ends, it is zero for permanent
+	// bans and users that are not banned.
+	BannedUntil time.Time
+	BanReason   string `json:",omitempty"`
+	// Attributes holds the values of registered attributes by name.
+	Attributes map[string]interface{} `json:",omitempty"`
 }
@@ -0,0 +1,30 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) (Writer, error) {
+	cw := &csvWriter{w: csv.NewWriter(w)}
+	return cw, cw.w.Write(Columns)
+}
+
+func (cw *csvWriter) Write(user entity.User) error {
+	return cw.w.Write(row(user))
+}
+
+func (cw *csvWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *csvWriter) Close() error {
+	return cw.Flush()
+}
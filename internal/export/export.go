@@ -0,0 +1,79 @@
+package export
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+// Writer encodes users one at a time, so that an export never holds more
+// than one row in memory.
+type Writer interface {
+	Write(user entity.User) error
+	// Flush pushes the buffered rows to the underlying writer.
+	Flush() error
+	// Close writes the trailer of the format, if it has one, and flushes.
+	Close() error
+}
+
+type Format struct {
+	Name        string
+	ContentType string
+	Extension   string
+	newWriter   func(w io.Writer) (Writer, error)
+}
+
+func (f Format) NewWriter(w io.Writer) (Writer, error) {
+	return f.newWriter(w)
+}
+
+var formats = map[string]Format{
+	"csv": {
+		Name:        "csv",
+		ContentType: "text/csv; charset=utf-8",
+		Extension:   "csv",
+		newWriter:   newCSVWriter,
+	},
+	"ndjson": {
+		Name:        "ndjson",
+		ContentType: "application/x-ndjson",
+		Extension:   "ndjson",
+		newWriter:   newNDJSONWriter,
+	},
+	"xlsx": {
+		Name:        "xlsx",
+		ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		Extension:   "xlsx",
+		newWriter:   newXLSXWriter,
+	},
+}
+
+func Lookup(name string) (Format, bool) {
+	f, ok := formats[name]
+	return f, ok
+}
+
+// Columns are shared by the tabular formats.
+var Columns = []string{"id", "name", "surname", "patronymic", "gender", "status", "birthday", "join_date"}
+
+func row(user entity.User) []string {
+	return []string{
+		strconv.FormatInt(user.ID, 10),
+		user.Name,
+		user.Surname,
+		user.Patronymic,
+		user.Gender,
+		user.Status,
+		formatDate(user.Birthday),
+		user.JoinDate.Format(time.RFC3339),
+	}
+}
+
+func formatDate(tm time.Time) string {
+	if tm.IsZero() {
+		return ""
+	}
+	return tm.Format(time.DateOnly)
+}
@@ -0,0 +1,116 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+var exportUsers = []entity.User{
+	{ID: 1, Name: "Ivan", Surname: "Petrov", Gender: "male", Status: "active", JoinDate: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+	{ID: 2, Name: "Olga & <Co>", Surname: "Petrova", Gender: "female", Status: "banned", Birthday: time.Date(1990, 5, 6, 0, 0, 0, 0, time.UTC)},
+}
+
+func writeAll(t *testing.T, name string) []byte {
+	t.Helper()
+	format, ok := Lookup(name)
+	if !ok {
+		t.Fatalf("format %q is not registered", name)
+	}
+	var buf bytes.Buffer
+	w, err := format.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range exportUsers {
+		if err = w.Write(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCSVWriter(t *testing.T) {
+	want := "id,name,surname,patronymic,gender,status,birthday,join_date\n" +
+		"1,Ivan,Petrov,,male,active,,2024-01-02T03:04:05Z\n" +
+		"2,Olga & <Co>,Petrova,,female,banned,1990-05-06,0001-01-01T00:00:00Z\n"
+	if got := string(writeAll(t, "csv")); got != want {
+		t.Errorf("csv =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	lines := strings.Split(strings.TrimSuffix(string(writeAll(t, "ndjson")), "\n"), "\n")
+	if len(lines) != len(exportUsers) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(exportUsers))
+	}
+	var user entity.User
+	if err := json.Unmarshal([]byte(lines[1]), &user); err != nil || user.ID != 2 || user.Name != "Olga & <Co>" {
+		t.Errorf("second line = %s, want the second user", lines[1])
+	}
+}
+
+func TestXLSXWriter(t *testing.T) {
+	data := writeAll(t, "xlsx")
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("workbook is not a zip archive: %s", err)
+	}
+	var sheet []byte
+	for _, f := range zr.File {
+		if f.Name != "xl/worksheets/sheet1.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sheet, err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var parsed struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err = xml.Unmarshal(sheet, &parsed); err != nil {
+		t.Fatalf("sheet is not valid XML: %s", err)
+	}
+	if len(parsed.Rows) != len(exportUsers)+1 {
+		t.Fatalf("got %d rows, want a header and %d users", len(parsed.Rows), len(exportUsers))
+	}
+	id, name := parsed.Rows[2].Cells[0], parsed.Rows[2].Cells[1]
+	if id.Ref != "A3" || id.Type != "" || id.Value != "2" {
+		t.Errorf("id cell = %+v, want the number 2 in A3", id)
+	}
+	if name.Ref != "B3" || name.Type != "inlineStr" || name.Inline != "Olga & <Co>" {
+		t.Errorf("name cell = %+v, want the inline string in B3", name)
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 7: "H", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		if got := columnName(i); got != want {
+			t.Errorf("columnName(%d) = %q, want %q", i, got, want)
+		}
+	}
+}
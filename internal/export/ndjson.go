@@ -0,0 +1,31 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+type ndjsonWriter struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func newNDJSONWriter(w io.Writer) (Writer, error) {
+	buf := bufio.NewWriter(w)
+	return &ndjsonWriter{buf: buf, enc: json.NewEncoder(buf)}, nil
+}
+
+func (nw *ndjsonWriter) Write(user entity.User) error {
+	return nw.enc.Encode(user)
+}
+
+func (nw *ndjsonWriter) Flush() error {
+	return nw.buf.Flush()
+}
+
+func (nw *ndjsonWriter) Close() error {
+	return nw.Flush()
+}
@@ -0,0 +1,128 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+// The workbook is written by hand instead of with a spreadsheet library:
+// those build the whole sheet in memory. Cells use inline strings, so no
+// shared string table has to be collected before the sheet is written.
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+	xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="users" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`
+	xlsxSheetHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetFooter = `</sheetData></worksheet>`
+)
+
+// xlsxNumericColumns are written as numbers, the rest as text.
+var xlsxNumericColumns = map[int]bool{0: true}
+
+type xlsxWriter struct {
+	zw    *zip.Writer
+	sheet *bufio.Writer
+	rows  int
+}
+
+func newXLSXWriter(w io.Writer) (Writer, error) {
+	zw := zip.NewWriter(w)
+	for _, part := range []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	} {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	xw := &xlsxWriter{zw: zw, sheet: bufio.NewWriter(sheet)}
+	if _, err = xw.sheet.WriteString(xlsxSheetHeader); err != nil {
+		return nil, err
+	}
+	return xw, xw.writeRow(Columns, nil)
+}
+
+func (xw *xlsxWriter) Write(user entity.User) error {
+	return xw.writeRow(row(user), xlsxNumericColumns)
+}
+
+func (xw *xlsxWriter) writeRow(cells []string, numeric map[int]bool) error {
+	xw.rows++
+	fmt.Fprintf(xw.sheet, `<row r="%d">`, xw.rows)
+	for i, cell := range cells {
+		ref := fmt.Sprintf("%s%d", columnName(i), xw.rows)
+		if numeric[i] {
+			fmt.Fprintf(xw.sheet, `<c r="%s"><v>%s</v></c>`, ref, cell)
+			continue
+		}
+		var escaped strings.Builder
+		if err := xml.EscapeText(&escaped, []byte(cell)); err != nil {
+			return err
+		}
+		fmt.Fprintf(xw.sheet, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, escaped.String())
+	}
+	_, err := xw.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (xw *xlsxWriter) Flush() error {
+	if err := xw.sheet.Flush(); err != nil {
+		return err
+	}
+	return xw.zw.Flush()
+}
+
+func (xw *xlsxWriter) Close() error {
+	if _, err := xw.sheet.WriteString(xlsxSheetFooter); err != nil {
+		return err
+	}
+	if err := xw.sheet.Flush(); err != nil {
+		return err
+	}
+	return xw.zw.Close()
+}
+
+// columnName returns the spreadsheet name of the zero-based column i: A, B,
+// ..., Z, AA, AB and so on.
+func columnName(i int) string {
+	name := ""
+	for i >= 0 {
+		name = string(rune('A'+i%26)) + name
+		i = i/26 - 1
+	}
+	return name
+}
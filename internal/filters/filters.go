@@ -1,5 +1,22 @@
 package filters
 
+// AttributePrefix marks a registered attribute in filter and sort params,
+// for example attr.department=sales or AttributesToSort=attr.hired_on.
+const AttributePrefix = "attr."
+
+// SortColumns are the columns a search can be sorted by. The query is built
+// only from this list, never from the request.
+var SortColumns = map[string]string{
+	"id":         "id",
+	"name":       "name",
+	"surname":    "surname",
+	"patronymic": "patronymic",
+	"gender":     "gender",
+	"status":     "status",
+	"birthday":   "birthday",
+	"join_date":  "join_date",
+}
+
 type Filter struct {
 	Gender           string
 	Status           string
@@ -9,4 +26,17 @@ type Filter struct {
 	SortDesc         bool
 	Limit            int
 	Offset           int
+	Attributes       []AttributeFilter
+	// SortAttributeType is the type of the attribute in AttributesToSort,
+	// the usecase sets it from the registry.
+	SortAttributeType string
+}
+
+// AttributeFilter matches users whose attribute equals the value. Raw is
+// the value from the request, the usecase checks the attribute against the
+// registry and sets Value converted to the attribute type.
+type AttributeFilter struct {
+	Name  string
+	Raw   string
+	Value interface{}
 }
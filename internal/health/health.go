@@ -0,0 +1,83 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	StatusUp       = "up"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+)
+
+// Check is a single dependency check. A failing critical check makes the
+// service down, a failing non-critical one only degrades it.
+type Check struct {
+	Name     string
+	Critical bool
+	Run      func(ctx context.Context) error
+}
+
+type Result struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type Checker struct {
+	checks  []Check
+	timeout time.Duration
+}
+
+func New(timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{
+		checks:  checks,
+		timeout: timeout,
+	}
+}
+
+func (c *Checker) Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	report := Report{
+		Status: StatusUp,
+		Checks: make(map[string]Result, len(c.checks)),
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range c.checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+			start := time.Now()
+			err := check.Run(ctx)
+			res := Result{
+				Status:  StatusUp,
+				Latency: time.Since(start).String(),
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				res.Status = StatusDown
+				res.Error = err.Error()
+				if check.Critical {
+					report.Status = StatusDown
+				} else if report.Status == StatusUp {
+					report.Status = StatusDegraded
+				}
+			}
+			report.Checks[check.Name] = res
+		}(check)
+	}
+	wg.Wait()
+	return report
+}
@@ -0,0 +1,59 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckerRun(t *testing.T) {
+	up := func(ctx context.Context) error { return nil }
+	failing := func(ctx context.Context) error { return errors.New("connection refused") }
+	tests := []struct {
+		name   string
+		checks []Check
+		status string
+	}{
+		{"no checks", nil, StatusUp},
+		{"all up", []Check{{"postgres", true, up}, {"redis", false, up}}, StatusUp},
+		{"non-critical down", []Check{{"postgres", true, up}, {"redis", false, failing}}, StatusDegraded},
+		{"critical down", []Check{{"postgres", true, failing}, {"redis", false, up}}, StatusDown},
+		{"both down", []Check{{"postgres", true, failing}, {"redis", false, failing}}, StatusDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := New(time.Second, tt.checks...).Run(context.Background())
+			if report.Status != tt.status {
+				t.Errorf("status = %q, want %q", report.Status, tt.status)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Errorf("%d checks reported, want %d", len(report.Checks), len(tt.checks))
+			}
+		})
+	}
+}
+
+func TestCheckerRunFailedCheck(t *testing.T) {
+	report := New(time.Second, Check{Name: "redis", Run: func(ctx context.Context) error {
+		return errors.New("connection refused")
+	}}).Run(context.Background())
+	if res := report.Checks["redis"]; res.Status != StatusDown || res.Error != "connection refused" || res.Latency == "" {
+		t.Errorf("redis = %+v", res)
+	}
+}
+
+func TestCheckerRunTimeout(t *testing.T) {
+	hanging := Check{Name: "postgres", Critical: true, Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	start := time.Now()
+	report := New(20*time.Millisecond, hanging).Run(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Run() took %s, the timeout is 20ms", elapsed)
+	}
+	if report.Status != StatusDown || report.Checks["postgres"].Error != context.DeadlineExceeded.Error() {
+		t.Errorf("report = %+v, want postgres down after the timeout", report)
+	}
+}
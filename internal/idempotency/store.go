@@ -0,0 +1,167 @@
+package idempotency
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const redisKeyPrefix = "idempotency:"
+
+// deleteIfUnchanged deletes KEYS[1] only if it still holds ARGV[1], so a
+// reservation completed in the meantime is kept.
+var deleteIfUnchanged = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// Record is what is kept for an idempotency key. A record with zero
+// StatusCode is a reservation for a request that is still being handled.
+type Record struct {
+	Fingerprint string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	ReservedAt  time.Time
+}
+
+func (r *Record) InProgress() bool {
+	return r.StatusCode == 0
+}
+
+type Store interface {
+	Reserve(key string, fingerprint string, window time.Duration) (bool, error)
+	Get(key string) (*Record, error)
+	Save(key string, record Record, window time.Duration) error
+	Release(key string) error
+}
+
+type RedisStore struct {
+	pool *redis.Pool
+}
+
+func NewRedisStore(pool *redis.Pool) *RedisStore {
+	return &RedisStore{pool: pool}
+}
+
+// Reserve atomically claims key for the first request carrying it.
+func (rs *RedisStore) Reserve(key string, fingerprint string, window time.Duration) (bool, error) {
+	recordJSON, err := json.Marshal(Record{Fingerprint: fingerprint, ReservedAt: time.Now()})
+	if err != nil {
+		return false, err
+	}
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	_, err = redis.String(conn.Do("SET", redisKeyPrefix+key, recordJSON, "NX", "PX", window.Milliseconds()))
+	if errors.Is(err, redis.ErrNil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (rs *RedisStore) Get(key string) (*Record, error) {
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	recordJSON, err := redis.Bytes(conn.Do("GET", redisKeyPrefix+key))
+	if errors.Is(err, redis.ErrNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	record := &Record{}
+	if err = json.Unmarshal(recordJSON, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (rs *RedisStore) Save(key string, record Record, window time.Duration) error {
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("SET", redisKeyPrefix+key, recordJSON, "PX", window.Milliseconds())
+	return err
+}
+
+func (rs *RedisStore) Release(key string) error {
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", redisKeyPrefix+key)
+	return err
+}
+
+// CleanStale removes reservations older than olderThan, they belong to
+// requests whose instance died before saving the response and would block
+// retries with the key until the window ends. Keys without an expiry are
+// removed too. It returns how many keys were removed.
+func (rs *RedisStore) CleanStale(olderThan time.Duration) (int, error) {
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	removed := 0
+	cursor := 0
+	for {
+		reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", redisKeyPrefix+"*", "COUNT", 500))
+		if err != nil {
+			return removed, err
+		}
+		var keys []string
+		if _, err = redis.Scan(reply, &cursor, &keys); err != nil {
+			return removed, err
+		}
+		for _, key := range keys {
+			stale, value, err := rs.isStale(conn, key, olderThan)
+			if err != nil {
+				return removed, err
+			}
+			if !stale {
+				continue
+			}
+			n, err := redis.Int(deleteIfUnchanged.Do(conn, key, value))
+			if err != nil {
+				return removed, err
+			}
+			removed += n
+		}
+		if cursor == 0 {
+			return removed, nil
+		}
+	}
+}
+
+func (rs *RedisStore) isStale(conn redis.Conn, key string, olderThan time.Duration) (bool, []byte, error) {
+	recordJSON, err := redis.Bytes(conn.Do("GET", key))
+	if errors.Is(err, redis.ErrNil) {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	ttl, err := redis.Int64(conn.Do("PTTL", key))
+	if err != nil {
+		return false, nil, err
+	}
+	if ttl == -1 {
+		return true, recordJSON, nil
+	}
+	record := &Record{}
+	if err = json.Unmarshal(recordJSON, record); err != nil {
+		return false, nil, nil
+	}
+	stale := record.InProgress() && !record.ReservedAt.IsZero() && time.Since(record.ReservedAt) > olderThan
+	return stale, recordJSON, nil
+}
@@ -0,0 +1,248 @@
+package idempotency
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// fakeRedis keeps strings in memory and understands the commands the store
+// sends. Scripts are always missing from the script cache, so the store
+// falls back to EVAL, which runs deleteIfUnchanged.
+type fakeRedis struct {
+	mu      sync.Mutex
+	values  map[string]string
+	expires map[string]time.Time
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{values: make(map[string]string), expires: make(map[string]time.Time)}
+}
+
+func (fr *fakeRedis) pool() *redis.Pool {
+	return &redis.Pool{Dial: func() (redis.Conn, error) { return fakeConn{fr}, nil }}
+}
+
+func (fr *fakeRedis) get(key string) (string, bool) {
+	if expires, ok := fr.expires[key]; ok && time.Now().After(expires) {
+		delete(fr.values, key)
+		delete(fr.expires, key)
+	}
+	value, ok := fr.values[key]
+	return value, ok
+}
+
+func (fr *fakeRedis) set(key, value string, ttl time.Duration) {
+	fr.values[key] = value
+	delete(fr.expires, key)
+	if ttl > 0 {
+		fr.expires[key] = time.Now().Add(ttl)
+	}
+}
+
+func (fr *fakeRedis) del(key string) int64 {
+	if _, ok := fr.get(key); !ok {
+		return 0
+	}
+	delete(fr.values, key)
+	delete(fr.expires, key)
+	return 1
+}
+
+type fakeConn struct {
+	r *fakeRedis
+}
+
+func (fc fakeConn) Close() error                      { return nil }
+func (fc fakeConn) Err() error                        { return nil }
+func (fc fakeConn) Send(string, ...interface{}) error { return fmt.Errorf("not supported") }
+func (fc fakeConn) Flush() error                      { return fmt.Errorf("not supported") }
+func (fc fakeConn) Receive() (interface{}, error)     { return nil, fmt.Errorf("not supported") }
+
+func (fc fakeConn) Do(command string, args ...interface{}) (interface{}, error) {
+	fr := fc.r
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	s := make([]string, len(args))
+	for i, arg := range args {
+		if b, ok := arg.([]byte); ok {
+			s[i] = string(b)
+		} else {
+			s[i] = fmt.Sprint(arg)
+		}
+	}
+	switch command {
+	case "SET":
+		var nx bool
+		var ttl time.Duration
+		for i := 2; i < len(s); i++ {
+			switch s[i] {
+			case "NX":
+				nx = true
+			case "PX":
+				ms, _ := strconv.Atoi(s[i+1])
+				ttl = time.Duration(ms) * time.Millisecond
+				i++
+			}
+		}
+		if _, ok := fr.get(s[0]); ok && nx {
+			return nil, nil
+		}
+		fr.set(s[0], s[1], ttl)
+		return "OK", nil
+	case "GET":
+		if value, ok := fr.get(s[0]); ok {
+			return []byte(value), nil
+		}
+		return nil, nil
+	case "DEL":
+		return fr.del(s[0]), nil
+	case "PTTL":
+		if _, ok := fr.get(s[0]); !ok {
+			return int64(-2), nil
+		}
+		expires, ok := fr.expires[s[0]]
+		if !ok {
+			return int64(-1), nil
+		}
+		return time.Until(expires).Milliseconds(), nil
+	case "SCAN":
+		prefix := strings.TrimSuffix(s[2], "*")
+		var keys []interface{}
+		for key := range fr.values {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, []byte(key))
+			}
+		}
+		return []interface{}{[]byte("0"), keys}, nil
+	case "EVALSHA":
+		return nil, redis.Error("NOSCRIPT No matching script")
+	case "EVAL":
+		// deleteIfUnchanged: EVAL script 1 key value
+		if value, ok := fr.get(s[2]); ok && value == s[3] {
+			return fr.del(s[2]), nil
+		}
+		return int64(0), nil
+	}
+	return nil, fmt.Errorf("unknown command %s", command)
+}
+
+func TestRedisStoreReserve(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		key      string
+		reserved bool
+	}{
+		{"new key", "", "a", true},
+		{"reserved key", "a", "a", false},
+		{"another key", "a", "b", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewRedisStore(newFakeRedis().pool())
+			if tt.existing != "" {
+				if _, err := store.Reserve(tt.existing, "first", time.Minute); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+			reserved, err := store.Reserve(tt.key, "second", time.Minute)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if reserved != tt.reserved {
+				t.Errorf("Reserve() = %v, want %v", reserved, tt.reserved)
+			}
+		})
+	}
+}
+
+func TestRedisStoreLifecycle(t *testing.T) {
+	store := NewRedisStore(newFakeRedis().pool())
+
+	record, err := store.Get("key")
+	if err != nil || record != nil {
+		t.Fatalf("Get() before Reserve = %v, %v, want nil", record, err)
+	}
+
+	if _, err = store.Reserve("key", "fp", time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	record, err = store.Get("key")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !record.InProgress() || record.Fingerprint != "fp" || record.ReservedAt.IsZero() {
+		t.Errorf("reservation = %+v, want an in-progress record with fingerprint fp", record)
+	}
+
+	saved := Record{Fingerprint: "fp", StatusCode: 201, ContentType: "application/json", Body: []byte(`{"ID":1}`)}
+	if err = store.Save("key", saved, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	record, err = store.Get("key")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if record.InProgress() || record.StatusCode != 201 || string(record.Body) != `{"ID":1}` {
+		t.Errorf("saved record = %+v, want %+v", record, saved)
+	}
+	if reserved, _ := store.Reserve("key", "fp", time.Minute); reserved {
+		t.Error("Reserve() of a saved key = true, want false")
+	}
+
+	if err = store.Release("key"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if record, _ = store.Get("key"); record != nil {
+		t.Errorf("Get() after Release = %+v, want nil", record)
+	}
+	if reserved, _ := store.Reserve("key", "fp", time.Minute); !reserved {
+		t.Error("Reserve() after Release = false, want true")
+	}
+}
+
+func TestRedisStoreCleanStale(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		record  Record
+		ttl     time.Duration
+		removed bool
+	}{
+		{"stale reservation", Record{Fingerprint: "fp", ReservedAt: now.Add(-time.Hour)}, time.Hour, true},
+		{"fresh reservation", Record{Fingerprint: "fp", ReservedAt: now}, time.Hour, false},
+		{"saved response", Record{Fingerprint: "fp", StatusCode: 200, ReservedAt: now.Add(-time.Hour)}, time.Hour, false},
+		{"no expiry", Record{Fingerprint: "fp", StatusCode: 200}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fr := newFakeRedis()
+			recordJSON, err := json.Marshal(tt.record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fr.set(redisKeyPrefix+"key", string(recordJSON), tt.ttl)
+			fr.set("other:key", "kept", 0)
+
+			removed, err := NewRedisStore(fr.pool()).CleanStale(time.Minute)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			_, left := fr.get(redisKeyPrefix + "key")
+			if (removed == 1) != tt.removed || left == tt.removed {
+				t.Errorf("CleanStale() removed %d, key left %v, want removed %v", removed, left, tt.removed)
+			}
+			if _, ok := fr.get("other:key"); !ok {
+				t.Error("CleanStale() removed a key of another prefix")
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package importfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxLineSize limits one NDJSON line, a longer line is not a user record.
+const maxLineSize = 1 << 20
+
+// RowError is returned by Next for a row that can't be parsed. The reader
+// stays usable and continues with the next row.
+type RowError struct {
+	Err error
+}
+
+func (e *RowError) Error() string {
+	return e.Err.Error()
+}
+
+// Reader returns the rows of an import file as values by user field.
+type Reader interface {
+	// Next returns io.EOF after the last row.
+	Next() (map[string]string, error)
+	// Missing returns the fields whose source is not in the file. Only CSV
+	// files have a header to check, for NDJSON it is always empty.
+	Missing() []string
+}
+
+// NewReader reads format from r. sources maps every user field to the
+// CSV column or NDJSON key it is read from.
+func NewReader(format string, r io.Reader, sources map[string]string) (Reader, error) {
+	switch format {
+	case "csv":
+		return newCSVReader(r, sources)
+	case "ndjson":
+		return newNDJSONReader(r, sources), nil
+	}
+	return nil, fmt.Errorf("unknown import format %q", format)
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int
+	missing []string
+}
+
+func newCSVReader(r io.Reader, sources map[string]string) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("the file is empty, a header row is expected")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	reader := &csvReader{r: cr, columns: make(map[string]int, len(sources))}
+	for field, source := range sources {
+		i, ok := index[source]
+		if !ok {
+			reader.missing = append(reader.missing, field)
+			continue
+		}
+		reader.columns[field] = i
+	}
+	return reader, nil
+}
+
+func (cr *csvReader) Next() (map[string]string, error) {
+	record, err := cr.r.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, &RowError{Err: parseErr.Err}
+		}
+		return nil, err
+	}
+	values := make(map[string]string, len(cr.columns))
+	for field, i := range cr.columns {
+		if i < len(record) {
+			values[field] = strings.TrimSpace(record[i])
+		}
+	}
+	return values, nil
+}
+
+func (cr *csvReader) Missing() []string {
+	return cr.missing
+}
+
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	sources map[string]string
+}
+
+func newNDJSONReader(r io.Reader, sources map[string]string) *ndjsonReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	return &ndjsonReader{scanner: scanner, sources: sources}
+}
+
+func (nr *ndjsonReader) Next() (map[string]string, error) {
+	var line []byte
+	for len(line) == 0 {
+		if !nr.scanner.Scan() {
+			if err := nr.scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		line = bytes.TrimSpace(nr.scanner.Bytes())
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal(line, &object); err != nil {
+		return nil, &RowError{Err: fmt.Errorf("invalid JSON object: %s", err)}
+	}
+	values := make(map[string]string, len(nr.sources))
+	for field, source := range nr.sources {
+		switch value := object[source].(type) {
+		case nil:
+		case string:
+			values[field] = strings.TrimSpace(value)
+		case float64, bool:
+			values[field] = fmt.Sprint(value)
+		default:
+			return nil, &RowError{Err: fmt.Errorf("%s: must be a string", source)}
+		}
+	}
+	return values, nil
+}
+
+func (nr *ndjsonReader) Missing() []string {
+	return nil
+}
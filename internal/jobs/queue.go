@@ -0,0 +1,278 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/retry"
+	"go.uber.org/zap"
+)
+
+// Handler does the work of one job kind. Jobs are retried, so a handler
+// must be safe to run again after a failure in the middle. ctx is cancelled
+// when the job is cancelled, the lease is lost or the service stops.
+type Handler func(ctx context.Context, job entity.Job) error
+
+// Store is the part of the storage the queue works with.
+type Store interface {
+	CreateJobStorage(ctx context.Context, job entity.Job, payload []byte) (int64, error)
+	LeaseJobStorage(ctx context.Context, workerID string, kinds []string, now, lockedUntil time.Time) (*entity.Job, error)
+	RenewJobLeaseStorage(ctx context.Context, ID int64, workerID string, lockedUntil time.Time) (bool, bool, error)
+	FinishJobStorage(ctx context.Context, ID int64, workerID, status, errText string, finishedAt time.Time) error
+	RetryJobStorage(ctx context.Context, ID int64, workerID, errText string, runAt time.Time) error
+	ReleaseJobStorage(ctx context.Context, ID int64, workerID string, runAt time.Time) error
+}
+
+type Options struct {
+	Workers      int
+	PollInterval time.Duration
+	Lease        time.Duration
+	MaxAttempts  int
+	Backoff      retry.Backoff
+}
+
+var (
+	errCancelled = errors.New("job was cancelled")
+	errLeaseLost = errors.New("job lease was lost")
+	errShutdown  = errors.New("service is stopping")
+)
+
+// permanentError is a failure that retrying won't fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err so that the job fails at once instead of being
+// retried.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Queue runs jobs stored in Postgres with a pool of workers. Every instance
+// of the service runs its own pool, they share the work through the jobs
+// table.
+type Queue struct {
+	s        Store
+	opts     Options
+	logger   *zap.SugaredLogger
+	workerID string
+	handlers map[string]Handler
+	kinds    []string
+
+	wake    chan struct{}
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	baseCtx context.Context
+	abort   context.CancelCauseFunc
+}
+
+func New(s Store, opts Options, logger *zap.SugaredLogger) *Queue {
+	hostname, _ := os.Hostname()
+	baseCtx, abort := context.WithCancelCause(context.Background())
+	return &Queue{
+		s:        s,
+		opts:     opts,
+		logger:   logger,
+		workerID: fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		handlers: make(map[string]Handler),
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		baseCtx:  baseCtx,
+		abort:    abort,
+	}
+}
+
+// Register must be called before Start.
+func (q *Queue) Register(kind string, h Handler) {
+	q.handlers[kind] = h
+	q.kinds = append(q.kinds, kind)
+}
+
+// Enqueue stores a pending job and wakes up a worker of this instance.
+func (q *Queue) Enqueue(ctx context.Context, job entity.Job, payload []byte) (*entity.Job, error) {
+	job.Status = entity.JobStatusPending
+	job.CreatedAt = time.Now()
+	if job.RunAt.IsZero() {
+		job.RunAt = job.CreatedAt
+	}
+	if job.MaxAttempts == 0 {
+		job.MaxAttempts = q.opts.MaxAttempts
+	}
+	ID, err := q.s.CreateJobStorage(ctx, job, payload)
+	if err != nil {
+		return nil, err
+	}
+	job.ID = ID
+	q.Notify()
+	return &job, nil
+}
+
+// Notify wakes up an idle worker, for jobs put into the queue without
+// Enqueue.
+func (q *Queue) Notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) Start() {
+	for i := 0; i < q.opts.Workers; i++ {
+		q.wg.Add(1)
+		go func(worker int) {
+			defer q.wg.Done()
+			q.work(fmt.Sprintf("%s-%d", q.workerID, worker))
+		}(i)
+	}
+}
+
+// Shutdown stops taking new jobs and waits for the running ones until ctx
+// is done. Then the running jobs are cancelled and given back to the queue,
+// another instance or the next start picks them up.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	close(q.stop)
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		q.abort(errShutdown)
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) work(workerID string) {
+	for {
+		select {
+		case <-q.stop:
+			return
+		default:
+		}
+
+		now := time.Now()
+		job, err := q.s.LeaseJobStorage(q.baseCtx, workerID, q.kinds, now, now.Add(q.opts.Lease))
+		if err != nil {
+			q.logger.Errorf("error in leasing job: %s", err)
+		}
+		if job != nil {
+			q.run(workerID, *job)
+			continue
+		}
+
+		select {
+		case <-q.stop:
+			return
+		case <-q.wake:
+		case <-time.After(q.opts.PollInterval):
+		}
+	}
+}
+
+func (q *Queue) run(workerID string, job entity.Job) {
+	logger := q.logger.With("job_id", job.ID, "job_kind", job.Kind, "attempt", job.Attempts)
+	ctx, cancel := context.WithCancelCause(q.baseCtx)
+	defer cancel(nil)
+
+	renewDone := make(chan struct{})
+	go func() {
+		defer close(renewDone)
+		q.renew(ctx, cancel, workerID, job.ID, logger)
+	}()
+	err := q.call(ctx, job)
+	cancel(nil)
+	<-renewDone
+
+	// The outcome is written with the base context so that it is recorded
+	// even when the job was stopped by shutdown.
+	storeCtx := context.WithoutCancel(q.baseCtx)
+	now := time.Now()
+	cause := context.Cause(ctx)
+	var permanent *permanentError
+	switch {
+	case err == nil:
+		logger.Infof("job succeeded")
+		err = q.s.FinishJobStorage(storeCtx, job.ID, workerID, entity.JobStatusSucceeded, "", now)
+	case errors.Is(cause, errLeaseLost):
+		logger.Warnf("job lease was lost, another worker owns it now: %s", err)
+		return
+	case errors.Is(cause, errShutdown):
+		logger.Warnf("job was stopped by shutdown and given back to the queue: %s", err)
+		err = q.s.ReleaseJobStorage(storeCtx, job.ID, workerID, now)
+	case errors.Is(cause, errCancelled):
+		logger.Infof("job was cancelled")
+		err = q.s.FinishJobStorage(storeCtx, job.ID, workerID, entity.JobStatusCancelled, errCancelled.Error(), now)
+	case errors.As(err, &permanent):
+		logger.Errorf("job failed: %s", err)
+		err = q.s.FinishJobStorage(storeCtx, job.ID, workerID, entity.JobStatusFailed, err.Error(), now)
+	case job.Attempts >= job.MaxAttempts:
+		logger.Errorf("job failed %d times, moving it to dead jobs: %s", job.Attempts, err)
+		err = q.s.FinishJobStorage(storeCtx, job.ID, workerID, entity.JobStatusDead, err.Error(), now)
+	default:
+		runAt := now.Add(q.opts.Backoff.Delay(job.Attempts - 1))
+		logger.Warnf("job failed, retrying at %s: %s", runAt.Format(time.RFC3339), err)
+		err = q.s.RetryJobStorage(storeCtx, job.ID, workerID, err.Error(), runAt)
+	}
+	if err != nil {
+		logger.Errorf("error in recording job outcome: %s", err)
+	}
+}
+
+// call runs the handler and turns its panic into an error, a bug in one
+// job shouldn't stop the worker.
+func (q *Queue) call(ctx context.Context, job entity.Job) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("job panicked: %v", recovered)
+		}
+	}()
+	h, ok := q.handlers[job.Kind]
+	if !ok {
+		return Permanent(fmt.Errorf("no handler for job kind %q", job.Kind))
+	}
+	return h(ctx, job)
+}
+
+// renew extends the lease while the job runs and cancels ctx when the job
+// is cancelled or another worker took it over.
+func (q *Queue) renew(ctx context.Context, cancel context.CancelCauseFunc, workerID string, jobID int64, logger *zap.SugaredLogger) {
+	ticker := time.NewTicker(q.opts.Lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		held, cancelRequested, err := q.s.RenewJobLeaseStorage(ctx, jobID, workerID, time.Now().Add(q.opts.Lease))
+		switch {
+		case err != nil && ctx.Err() == nil:
+			logger.Errorf("error in renewing job lease: %s", err)
+		case err != nil:
+			return
+		case !held:
+			cancel(errLeaseLost)
+			return
+		case cancelRequested:
+			cancel(errCancelled)
+			return
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/retry"
+	"go.uber.org/zap"
+)
+
+// memoryStore keeps jobs in a map and leases them like the jobs table does.
+type memoryStore struct {
+	mu       sync.Mutex
+	jobs     map[int64]*entity.Job
+	lockedBy map[int64]string
+	// released is closed when a job is given back to the queue.
+	released chan struct{}
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		jobs:     map[int64]*entity.Job{},
+		lockedBy: map[int64]string{},
+		released: make(chan struct{}),
+	}
+}
+
+func (s *memoryStore) CreateJobStorage(ctx context.Context, job entity.Job, payload []byte) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job.ID = int64(len(s.jobs) + 1)
+	s.jobs[job.ID] = &job
+	return job.ID, nil
+}
+
+func (s *memoryStore) LeaseJobStorage(ctx context.Context, workerID string, kinds []string, now, lockedUntil time.Time) (*entity.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ID := int64(1); ID <= int64(len(s.jobs)); ID++ {
+		job := s.jobs[ID]
+		if job.Status == entity.JobStatusPending && !job.RunAt.After(now) {
+			job.Status = entity.JobStatusRunning
+			job.Attempts++
+			s.lockedBy[ID] = workerID
+			leased := *job
+			return &leased, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *memoryStore) RenewJobLeaseStorage(ctx context.Context, ID int64, workerID string, lockedUntil time.Time) (bool, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.jobs[ID]
+	if s.lockedBy[ID] != workerID || job.Status != entity.JobStatusRunning {
+		return false, false, nil
+	}
+	return true, job.CancelRequested, nil
+}
+
+func (s *memoryStore) FinishJobStorage(ctx context.Context, ID int64, workerID, status, errText string, finishedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[ID].Status, s.jobs[ID].Error = status, errText
+	return nil
+}
+
+func (s *memoryStore) RetryJobStorage(ctx context.Context, ID int64, workerID, errText string, runAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[ID].Status, s.jobs[ID].Error, s.jobs[ID].RunAt = entity.JobStatusPending, errText, runAt
+	return nil
+}
+
+func (s *memoryStore) ReleaseJobStorage(ctx context.Context, ID int64, workerID string, runAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[ID].Status, s.jobs[ID].RunAt = entity.JobStatusPending, runAt
+	s.jobs[ID].Attempts--
+	close(s.released)
+	return nil
+}
+
+func (s *memoryStore) job(ID int64) entity.Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.jobs[ID]
+}
+
+func (s *memoryStore) update(ID int64, fn func(job *entity.Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.jobs[ID])
+}
+
+func newTestQueue(s Store) *Queue {
+	return New(s, Options{
+		Workers:      2,
+		PollInterval: 5 * time.Millisecond,
+		Lease:        30 * time.Millisecond,
+		MaxAttempts:  3,
+		Backoff:      retry.Backoff{Initial: time.Millisecond, Max: 4 * time.Millisecond},
+	}, zap.NewNop().Sugar())
+}
+
+// waitFinished polls the job until it is finished.
+func waitFinished(t *testing.T, s *memoryStore, ID int64) entity.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job := s.job(ID); job.Finished() {
+			return job
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("job %d is still %s", ID, s.job(ID).Status)
+	return entity.Job{}
+}
+
+func TestQueueOutcomes(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		handler  Handler
+		status   string
+		attempts int
+	}{
+		{"success", "test", func(ctx context.Context, job entity.Job) error { return nil }, entity.JobStatusSucceeded, 1},
+		{"retried until dead", "test", func(ctx context.Context, job entity.Job) error { return errors.New("temporary") }, entity.JobStatusDead, 3},
+		{"success after a retry", "test", func(ctx context.Context, job entity.Job) error {
+			if job.Attempts < 2 {
+				return errors.New("temporary")
+			}
+			return nil
+		}, entity.JobStatusSucceeded, 2},
+		{"permanent failure", "test", func(ctx context.Context, job entity.Job) error { return Permanent(errors.New("bad params")) }, entity.JobStatusFailed, 1},
+		{"panic is retried", "test", func(ctx context.Context, job entity.Job) error { panic("bug") }, entity.JobStatusDead, 3},
+		{"unknown kind", "other", nil, entity.JobStatusFailed, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newMemoryStore()
+			q := newTestQueue(s)
+			q.Register("test", tt.handler)
+			// Leases "other" jobs without a handler for them, like an
+			// instance running an older version would.
+			q.kinds = append(q.kinds, "other")
+			q.Start()
+			defer q.Shutdown(context.Background())
+
+			job, err := q.Enqueue(context.Background(), entity.Job{Kind: tt.kind}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			finished := waitFinished(t, s, job.ID)
+			if finished.Status != tt.status || finished.Attempts != tt.attempts {
+				t.Errorf("job is %s after %d attempts, want %s after %d (error %q)",
+					finished.Status, finished.Attempts, tt.status, tt.attempts, finished.Error)
+			}
+		})
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	s := newMemoryStore()
+	q := newTestQueue(s)
+	started := make(chan struct{})
+	q.Register("test", func(ctx context.Context, job entity.Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	q.Start()
+	defer q.Shutdown(context.Background())
+
+	job, err := q.Enqueue(context.Background(), entity.Job{Kind: "test"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	s.update(job.ID, func(job *entity.Job) { job.CancelRequested = true })
+	if finished := waitFinished(t, s, job.ID); finished.Status != entity.JobStatusCancelled {
+		t.Errorf("job is %s, want %s", finished.Status, entity.JobStatusCancelled)
+	}
+}
+
+func TestQueueLeaseLost(t *testing.T) {
+	s := newMemoryStore()
+	q := newTestQueue(s)
+	stopped := make(chan struct{})
+	q.Register("test", func(ctx context.Context, job entity.Job) error {
+		<-ctx.Done()
+		close(stopped)
+		return ctx.Err()
+	})
+
+	job, err := q.Enqueue(context.Background(), entity.Job{Kind: "test"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Start()
+	defer q.Shutdown(context.Background())
+	for s.job(job.ID).Status != entity.JobStatusRunning {
+		time.Sleep(time.Millisecond)
+	}
+	s.mu.Lock()
+	s.lockedBy[job.ID] = "another-worker"
+	s.mu.Unlock()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler wasn't stopped after the lease was lost")
+	}
+	if got := s.job(job.ID); got.Status != entity.JobStatusRunning {
+		t.Errorf("job is %s, want it left running for its new owner", got.Status)
+	}
+}
+
+func TestQueueShutdownReleasesRunningJob(t *testing.T) {
+	s := newMemoryStore()
+	q := newTestQueue(s)
+	started := make(chan struct{})
+	q.Register("test", func(ctx context.Context, job entity.Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	q.Start()
+
+	job, err := q.Enqueue(context.Background(), entity.Job{Kind: "test"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err = q.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want context.DeadlineExceeded", err)
+	}
+	<-s.released
+	if got := s.job(job.ID); got.Status != entity.JobStatusPending || got.Attempts != 0 {
+		t.Errorf("job is %s after %d attempts, want it pending again", got.Status, got.Attempts)
+	}
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "user_api"
+
+const (
+	CacheHit   = "hit"
+	CacheMiss  = "miss"
+	CacheError = "error"
+
+	TaskSucceeded = "succeeded"
+	TaskFailed    = "failed"
+	TaskSkipped   = "skipped"
+)
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route template, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route template, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Postgres query latency by query name.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"query"})
+
+	Panics = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_panics_total",
+		Help:      "Panics recovered in HTTP handlers.",
+	})
+
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Redis user cache lookups by result: hit, miss or error.",
+	}, []string{"result"})
+
+	ScheduledTaskRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scheduled_task_runs_total",
+		Help:      "Planned runs of scheduled tasks by result: succeeded, failed or skipped because another instance ran it.",
+	}, []string{"task", "result"})
+)
+
+func RegisterPool(pool *pgxpool.Pool) {
+	prometheus.MustRegister(&poolCollector{pool: pool})
+}
+
+func ObserveQuery(query string, start time.Time) {
+	DBQueryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	poolAcquiredConns = poolDesc("acquired_conns", "Connections currently in use.")
+	poolIdleConns     = poolDesc("idle_conns", "Idle connections in the pool.")
+	poolTotalConns    = poolDesc("total_conns", "All open connections in the pool.")
+	poolMaxConns      = poolDesc("max_conns", "Maximum size of the pool.")
+	poolAcquires      = poolDesc("acquires_total", "Connections acquired from the pool.")
+	poolEmptyAcquires = poolDesc("empty_acquires_total", "Acquires that had to wait for a connection.")
+	poolAcquireWait   = poolDesc("acquire_duration_seconds_total", "Time spent acquiring connections.")
+	poolNewConns      = poolDesc("new_conns_total", "Connections opened by the pool.")
+)
+
+type poolCollector struct {
+	pool *pgxpool.Pool
+}
+
+func poolDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil)
+}
+
+func (pc *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		poolAcquiredConns, poolIdleConns, poolTotalConns, poolMaxConns,
+		poolAcquires, poolEmptyAcquires, poolAcquireWait, poolNewConns,
+	} {
+		ch <- desc
+	}
+}
+
+func (pc *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := pc.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireWait, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(poolNewConns, prometheus.CounterValue, float64(stat.NewConnsCount()))
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestPoolCollector(t *testing.T) {
+	// The pool connects lazily, the stats of an unused pool need no server.
+	pool, err := pgxpool.New(context.Background(), "postgres://user@127.0.0.1:1/users?pool_max_conns=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	collector := &poolCollector{pool: pool}
+	if n := testutil.CollectAndCount(collector); n != 8 {
+		t.Errorf("collected %d metrics, want 8", n)
+	}
+	expected := `
+# HELP user_api_db_pool_max_conns Maximum size of the pool.
+# TYPE user_api_db_pool_max_conns gauge
+user_api_db_pool_max_conns 7
+`
+	if err = testutil.CollectAndCompare(collector, strings.NewReader(expected), "user_api_db_pool_max_conns"); err != nil {
+		t.Error(err)
+	}
+}
+
+// queryCount reads the number of observations of query, the histogram is
+// global so tests compare deltas.
+func queryCount(t *testing.T, query string) uint64 {
+	t.Helper()
+	m := &dto.Metric{}
+	if err := DBQueryDuration.WithLabelValues(query).(prometheus.Metric).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestObserveQuery(t *testing.T) {
+	before := queryCount(t, "test_query")
+	ObserveQuery("test_query", time.Now().Add(-time.Millisecond))
+	if after := queryCount(t, "test_query"); after != before+1 {
+		t.Errorf("%d observations of the query, want %d", after, before+1)
+	}
+}
@@ -1,22 +1,84 @@
 package middleware
 
 import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"math/rand"
 	"net/http"
 	"time"
 
+	"github.com/ivanov-nikolay/user-api/internal/logging"
 	"go.uber.org/zap"
 )
 
-func AccessLog(next http.Handler, logger *zap.SugaredLogger) http.Handler {
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+)
+
+// AccessLogOptions controls which requests are logged. Requests to
+// SkipPaths are never logged, other successful requests are logged with
+// probability SampleRate. Failed requests are always logged.
+type AccessLogOptions struct {
+	SampleRate float64
+	SkipPaths  []string
+}
+
+func AccessLog(next http.Handler, logger *zap.SugaredLogger, opts AccessLogOptions) http.Handler {
+	skip := make(map[string]bool, len(opts.SkipPaths))
+	for _, path := range opts.SkipPaths {
+		skip[path] = true
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		logger.Infof("access log middleware start")
 		start := time.Now()
-		next.ServeHTTP(w, r)
-		logger.Infow("New request",
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		requestLogger := logger.With("request_id", requestID)
+		ctx := logging.WithRequestID(r.Context(), requestID)
+		ctx = logging.WithLogger(ctx, requestLogger)
+
+		sw := newStatusWriter(w)
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		if skip[r.URL.Path] {
+			return
+		}
+		if sw.statusCode < http.StatusBadRequest && rand.Float64() >= opts.SampleRate {
+			return
+		}
+		requestLogger.Infow("New request",
 			"method", r.Method,
 			"remote_addr", r.RemoteAddr,
 			"url", r.URL.Path,
+			"status", sw.statusCode,
+			"bytes", sw.bytes,
+			"user_agent", r.UserAgent(),
 			"time", time.Since(start),
 		)
 	})
 }
+
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := crand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ivanov-nikolay/user-api/internal/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAccessLogRequestID(t *testing.T) {
+	var seen string
+	h := AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestIDFromContext(r.Context())
+	}), zap.NewNop().Sugar(), AccessLogOptions{})
+
+	tests := []struct {
+		name     string
+		incoming string
+		kept     bool
+	}{
+		{"client id", "req-42", true},
+		{"no id", "", false},
+		{"id with spaces", "req 42", false},
+		{"too long id", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/users", nil)
+			r.Header.Set(requestIDHeader, tt.incoming)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			returned := w.Header().Get(requestIDHeader)
+			if returned == "" || returned != seen {
+				t.Errorf("returned id %q, handler saw %q", returned, seen)
+			}
+			if (returned == tt.incoming) != tt.kept {
+				t.Errorf("returned id %q for incoming %q, kept = %v", returned, tt.incoming, tt.kept)
+			}
+		})
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		opts   AccessLogOptions
+		logged bool
+	}{
+		{"sampled in", "/users", http.StatusOK, AccessLogOptions{SampleRate: 1}, true},
+		{"sampled out", "/users", http.StatusOK, AccessLogOptions{SampleRate: 0}, false},
+		{"failure is always logged", "/users", http.StatusNotFound, AccessLogOptions{SampleRate: 0}, true},
+		{"skipped path", "/healthz", http.StatusServiceUnavailable, AccessLogOptions{SampleRate: 1, SkipPaths: []string{"/healthz"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.InfoLevel)
+			h := AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}), zap.New(core).Sugar(), tt.opts)
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.Header.Set(requestIDHeader, "req-42")
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got := logs.Len() == 1; got != tt.logged {
+				t.Fatalf("logged = %v, want %v", got, tt.logged)
+			}
+			if !tt.logged {
+				return
+			}
+			fields := logs.All()[0].ContextMap()
+			if fields["request_id"] != "req-42" || fields["status"] != int64(tt.status) {
+				t.Errorf("fields = %v", fields)
+			}
+		})
+	}
+}
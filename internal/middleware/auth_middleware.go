@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/ivanov-nikolay/user-api/internal/auth"
+	"go.uber.org/zap"
+)
+
+func Authenticate(authenticators []auth.Authenticator, logger *zap.SugaredLogger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, a := range authenticators {
+				principal, err := a.Authenticate(r)
+				if errors.Is(err, auth.ErrNoCredentials) {
+					continue
+				}
+				if err != nil {
+					logger.Infof("authentication failed: %s", err)
+					writeError(logger, w, "invalid credentials", http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+				return
+			}
+			writeError(logger, w, "authentication required", http.StatusUnauthorized)
+		})
+	}
+}
+
+func Authorize(policy *auth.Policy, logger *zap.SugaredLogger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := auth.PrincipalFromContext(r.Context())
+			if !ok {
+				writeError(logger, w, "authentication required", http.StatusUnauthorized)
+				return
+			}
+			path, err := mux.CurrentRoute(r).GetPathTemplate()
+			if err != nil {
+				writeError(logger, w, "route is not known", http.StatusForbidden)
+				return
+			}
+
+			scope, ok := policy.RouteScope(r.Method, path)
+			if !ok {
+				writeError(logger, w, fmt.Sprintf("no access policy for %s %s", r.Method, path), http.StatusForbidden)
+				return
+			}
+			if !principal.HasScope(scope) {
+				writeError(logger, w, fmt.Sprintf("scope %s is required", scope), http.StatusForbidden)
+				return
+			}
+
+			rules := policy.FieldRules(r.Method, path)
+			if len(rules) != 0 {
+				reason, err := checkFieldRules(r, principal, rules)
+				if err != nil {
+					writeError(logger, w, fmt.Sprintf("error in reading request body: %s", err), http.StatusBadRequest)
+					return
+				}
+				if reason != "" {
+					writeError(logger, w, reason, http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// checkFieldRules inspects the JSON body and restores it for the handler.
+// Bodies that are not JSON objects are left for the handler to reject.
+// encoding/json matches field names case-insensitively, so every key that
+// the handler could take for the field is checked, not only the exact one.
+func checkFieldRules(r *http.Request, principal *auth.Principal, rules []auth.FieldRule) (string, error) {
+	rBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(rBody))
+
+	fields := map[string]interface{}{}
+	if err = json.Unmarshal(rBody, &fields); err != nil {
+		return "", nil
+	}
+	for _, rule := range rules {
+		if principal.HasScope(rule.Scope) {
+			continue
+		}
+		for key, raw := range fields {
+			if !strings.EqualFold(key, rule.Field) {
+				continue
+			}
+			value := fmt.Sprint(raw)
+			if rule.Restricts(value) {
+				return fmt.Sprintf("only %s may set %s to %q", rule.Scope, rule.Field, value), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func writeError(logger *zap.SugaredLogger, w http.ResponseWriter, message string, statusCode int) {
+	body, err := json.Marshal(map[string]string{"message": message})
+	if err != nil {
+		logger.Errorf("error in coding error message: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	_, err = w.Write(body)
+	if err != nil {
+		logger.Errorf("error in writing response body: %s", err)
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ivanov-nikolay/user-api/internal/auth"
+)
+
+func TestCheckFieldRules(t *testing.T) {
+	rules := auth.DefaultPolicy().FieldRules(http.MethodPut, "/user")
+	writer := &auth.Principal{Subject: "writer", Scopes: []string{auth.ScopeUsersWrite}}
+	admin := &auth.Principal{Subject: "admin", Scopes: []string{auth.ScopeUsersAdmin}}
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		body      string
+		forbidden bool
+	}{
+		{"allowed value", writer, `{"status": "active"}`, false},
+		{"restricted value", writer, `{"status": "banned"}`, true},
+		{"restricted value in another case", writer, `{"Status": "banned"}`, true},
+		{"restricted value in upper case", writer, `{"STATUS": "deleted"}`, true},
+		{"restricted value after an allowed one", writer, `{"status": "active", "Status": "banned"}`, true},
+		{"restricted value before an allowed one", writer, `{"Status": "banned", "status": "active"}`, true},
+		{"admin sets restricted value", admin, `{"Status": "banned"}`, false},
+		{"no field", writer, `{"name": "Ivan"}`, false},
+		{"not an object", writer, `[1, 2]`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/user", strings.NewReader(tt.body))
+			reason, err := checkFieldRules(r, tt.principal, rules)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if (reason != "") != tt.forbidden {
+				t.Errorf("forbidden = %t, want %t (reason %q)", reason != "", tt.forbidden, reason)
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil || string(body) != tt.body {
+				t.Errorf("body was not restored: %q, %v", body, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ivanov-nikolay/user-api/internal/consistency"
+)
+
+// Consistency sends reads of a request to the primary when it asks for it
+// with "Consistency: strong", and always for writes.
+func Consistency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		strong := strings.EqualFold(r.Header.Get(consistency.Header), consistency.Strong)
+		if strong || !isReadMethod(r.Method) {
+			r = r.WithContext(consistency.WithStrong(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ivanov-nikolay/user-api/internal/auth"
+	"github.com/ivanov-nikolay/user-api/internal/idempotency"
+	"go.uber.org/zap"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// Idempotency stores the first response for every Idempotency-Key and
+// replays it for retries. Server errors are not stored so that the client
+// can retry them with the same key.
+func Idempotency(store idempotency.Store, window time.Duration, logger *zap.SugaredLogger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				writeError(logger, w, "Idempotency-Key is too long", http.StatusBadRequest)
+				return
+			}
+
+			rBody, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeError(logger, w, "error in reading request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(rBody))
+
+			storeKey := idempotencyScope(r) + ":" + key
+			fingerprint := requestFingerprint(r, rBody)
+
+			reserved, err := store.Reserve(storeKey, fingerprint, window)
+			if err != nil {
+				logger.Errorf("error in idempotency store, request is handled without it: %s", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !reserved {
+				replayIdempotent(store, storeKey, fingerprint, w, logger)
+				return
+			}
+
+			// A panicking handler leaves the key reserved and every retry
+			// would get a conflict. The reservation is released on the way
+			// out, the panic itself goes on to Recover with its stack.
+			handled := false
+			defer func() {
+				if handled {
+					return
+				}
+				if err := store.Release(storeKey); err != nil {
+					logger.Errorf("error in releasing idempotency key: %s", err)
+				}
+			}()
+			rec := newResponseRecorder()
+			next.ServeHTTP(rec, r)
+			handled = true
+
+			if rec.statusCode >= http.StatusInternalServerError {
+				err = store.Release(storeKey)
+			} else {
+				err = store.Save(storeKey, idempotency.Record{
+					Fingerprint: fingerprint,
+					StatusCode:  rec.statusCode,
+					ContentType: rec.Header().Get("Content-Type"),
+					Body:        rec.body.Bytes(),
+				}, window)
+			}
+			if err != nil {
+				logger.Errorf("error in saving idempotent response: %s", err)
+			}
+			rec.flushTo(w, logger)
+		})
+	}
+}
+
+func replayIdempotent(store idempotency.Store, storeKey, fingerprint string, w http.ResponseWriter, logger *zap.SugaredLogger) {
+	record, err := store.Get(storeKey)
+	if err != nil {
+		logger.Errorf("error in reading idempotent response: %s", err)
+		writeError(logger, w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if record == nil {
+		writeError(logger, w, "request with this Idempotency-Key has expired, retry it", http.StatusConflict)
+		return
+	}
+	if record.Fingerprint != fingerprint {
+		writeError(logger, w, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+		return
+	}
+	if record.InProgress() {
+		writeError(logger, w, "request with this Idempotency-Key is still in progress", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", record.ContentType)
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.StatusCode)
+	if _, err = w.Write(record.Body); err != nil {
+		logger.Errorf("error in writing response body: %s", err)
+	}
+}
+
+// idempotencyScope keeps keys of different clients apart.
+func idempotencyScope(r *http.Request) string {
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+		return principal.Subject
+	}
+	return "anonymous"
+}
+
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type responseRecorder struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{
+		header:     http.Header{},
+		statusCode: http.StatusOK,
+	}
+}
+
+func (rr *responseRecorder) Header() http.Header {
+	return rr.header
+}
+
+func (rr *responseRecorder) WriteHeader(statusCode int) {
+	rr.statusCode = statusCode
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	return rr.body.Write(b)
+}
+
+func (rr *responseRecorder) flushTo(w http.ResponseWriter, logger *zap.SugaredLogger) {
+	for name, values := range rr.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(rr.statusCode)
+	if _, err := w.Write(rr.body.Bytes()); err != nil {
+		logger.Errorf("error in writing response body: %s", err)
+	}
+}
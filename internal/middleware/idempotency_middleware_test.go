@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/idempotency"
+	"go.uber.org/zap"
+)
+
+// memoryIdempotencyStore keeps records in a map and never expires them.
+type memoryIdempotencyStore struct {
+	records map[string]idempotency.Record
+}
+
+func (s *memoryIdempotencyStore) Reserve(key string, fingerprint string, window time.Duration) (bool, error) {
+	if _, ok := s.records[key]; ok {
+		return false, nil
+	}
+	s.records[key] = idempotency.Record{Fingerprint: fingerprint, ReservedAt: time.Now()}
+	return true, nil
+}
+
+func (s *memoryIdempotencyStore) Get(key string) (*idempotency.Record, error) {
+	record, ok := s.records[key]
+	if !ok {
+		return nil, nil
+	}
+	return &record, nil
+}
+
+func (s *memoryIdempotencyStore) Save(key string, record idempotency.Record, window time.Duration) error {
+	s.records[key] = record
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(key string) error {
+	delete(s.records, key)
+	return nil
+}
+
+func postWithKey(h http.Handler, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(body))
+	r.Header.Set("Idempotency-Key", "key-1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	store := &memoryIdempotencyStore{records: map[string]idempotency.Record{}}
+	calls := 0
+	h := Idempotency(store, time.Hour, zap.NewNop().Sugar())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"ID": 1}`))
+	}))
+
+	first := postWithKey(h, `{"name": "Ivan"}`)
+	second := postWithKey(h, `{"name": "Ivan"}`)
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() || second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry = %d %q, want the replayed first response", second.Code, second.Body.String())
+	}
+	if other := postWithKey(h, `{"name": "Olga"}`); other.Code != http.StatusUnprocessableEntity {
+		t.Errorf("other request with the key = %d, want %d", other.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestIdempotencyReleasesKeyOnPanic(t *testing.T) {
+	store := &memoryIdempotencyStore{records: map[string]idempotency.Record{}}
+	panics := true
+	h := Idempotency(store, time.Hour, zap.NewNop().Sugar())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if panics {
+			panic("handler failed")
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	h = Recover(zap.NewNop().Sugar())(h)
+
+	if w := postWithKey(h, `{}`); w.Code != http.StatusInternalServerError {
+		t.Fatalf("panicking request = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if _, ok := store.records["anonymous:key-1"]; ok {
+		t.Fatal("key is still reserved after the panic")
+	}
+	panics = false
+	if w := postWithKey(h, `{}`); w.Code != http.StatusCreated {
+		t.Errorf("retry = %d, want %d", w.Code, http.StatusCreated)
+	}
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ivanov-nikolay/user-api/internal/metrics"
+)
+
+// Metrics labels requests by route template rather than by path, so that
+// user ids don't blow up the number of series.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := newStatusWriter(w)
+		next.ServeHTTP(sw, r)
+
+		route, err := mux.CurrentRoute(r).GetPathTemplate()
+		if err != nil {
+			route = "unknown"
+		}
+		status := strconv.Itoa(sw.statusCode)
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, status).Inc()
+		metrics.HTTPDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	bytes       int
+	wroteHeader bool
+}
+
+func newStatusWriter(w http.ResponseWriter) *statusWriter {
+	return &statusWriter{
+		ResponseWriter: w,
+		statusCode:     http.StatusOK,
+	}
+}
+
+func (sw *statusWriter) WriteHeader(statusCode int) {
+	if !sw.wroteHeader {
+		sw.statusCode = statusCode
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += n
+	return n, err
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/ivanov-nikolay/user-api/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsLabelsByRouteTemplate(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(Metrics)
+	router.HandleFunc("/user/{USER_ID}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["USER_ID"] == "404" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}).Methods(http.MethodGet)
+
+	found := metrics.HTTPRequests.WithLabelValues("/user/{USER_ID}", http.MethodGet, "200")
+	notFound := metrics.HTTPRequests.WithLabelValues("/user/{USER_ID}", http.MethodGet, "404")
+	beforeFound, beforeNotFound := testutil.ToFloat64(found), testutil.ToFloat64(notFound)
+	for _, path := range []string{"/user/1", "/user/2", "/user/404"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if got := testutil.ToFloat64(found) - beforeFound; got != 2 {
+		t.Errorf("counted %v requests with status 200, want 2", got)
+	}
+	if got := testutil.ToFloat64(notFound) - beforeNotFound; got != 1 {
+		t.Errorf("counted %v requests with status 404, want 1", got)
+	}
+}
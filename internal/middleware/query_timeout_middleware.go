@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/querytimeout"
+)
+
+func QueryTimeout(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(querytimeout.With(r.Context(), timeout)))
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/querytimeout"
+)
+
+func TestQueryTimeout(t *testing.T) {
+	var timeout time.Duration
+	var ok bool
+	h := QueryTimeout(2 * time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout, ok = querytimeout.FromContext(r.Context())
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+	if !ok || timeout != 2*time.Second {
+		t.Errorf("timeout in the request = %s, %v, want 2s", timeout, ok)
+	}
+
+	h = QueryTimeout(0)(h)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+	if timeout != 2*time.Second {
+		t.Errorf("inner timeout = %s, the innermost middleware must win", timeout)
+	}
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ivanov-nikolay/user-api/internal/auth"
+	"github.com/ivanov-nikolay/user-api/internal/ratelimit"
+	"go.uber.org/zap"
+)
+
+// allRoutes is the route of the address limit, one bucket covers all the
+// routes.
+const allRoutes = "*"
+
+// RateLimit must run after Authenticate so that authenticated clients are
+// limited by identity rather than by address.
+func RateLimit(limiter *ratelimit.Limiter, logger *zap.SugaredLogger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path, err := mux.CurrentRoute(r).GetPathTemplate()
+			if err != nil {
+				path = r.URL.Path
+			}
+			client := remoteIP(r)
+			if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+				client = principal.Subject
+			}
+			if allow(w, limiter, r.Method, path, client, logger) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// RateLimitIP limits the requests of an address over all routes. It runs
+// before Authenticate, so that bad credentials and requests without them
+// are limited before they cost a token check or an API key lookup.
+func RateLimitIP(limiter *ratelimit.Limiter, logger *zap.SugaredLogger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if allow(w, limiter, allRoutes, allRoutes, remoteIP(r), logger) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// allow takes a token and sets the rate limit headers. It writes 429 and
+// returns false if the client is over the limit. A failing store lets the
+// request through.
+func allow(w http.ResponseWriter, limiter *ratelimit.Limiter, method, path, client string, logger *zap.SugaredLogger) bool {
+	res, err := limiter.Allow(method, path, client)
+	if err != nil {
+		logger.Errorf("error in rate limiter, request is let through: %s", err)
+		return true
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("RateLimit-Reset", ceilSeconds(res.Reset))
+	if !res.Allowed {
+		w.Header().Set("Retry-After", ceilSeconds(res.RetryAfter))
+		writeError(logger, w, "too many requests", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "ip:" + r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
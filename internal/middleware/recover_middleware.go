@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gorilla/mux"
+	"github.com/ivanov-nikolay/user-api/internal/logging"
+	"github.com/ivanov-nikolay/user-api/internal/metrics"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const internalErrorProblem = `{"type": "about:blank", "title": "Internal Server Error", "status": 500, "detail": "internal server error"}`
+
+// Recover must run inside AccessLog so that the stack is logged with the
+// request id, and inside Tracing and Metrics so that the 500 is counted and
+// the span is marked failed. A panic after the response has started can
+// only be logged, the client gets the truncated response.
+func Recover(logger *zap.SugaredLogger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := newStatusWriter(w)
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				metrics.Panics.Inc()
+				logging.FromContext(r.Context(), logger).Errorw("panic in handler",
+					"panic", rec,
+					"method", r.Method,
+					"url", r.URL.Path,
+					"stack", string(debug.Stack()),
+				)
+				trace.SpanFromContext(r.Context()).RecordError(fmt.Errorf("panic: %v", rec))
+				if sw.wroteHeader {
+					return
+				}
+				sw.Header().Set("Content-Type", "application/problem+json")
+				sw.WriteHeader(http.StatusInternalServerError)
+				if _, err := sw.Write([]byte(internalErrorProblem)); err != nil {
+					logger.Errorf("error in writing response body: %s", err)
+				}
+			}()
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/ivanov-nikolay/user-api/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func TestRecoverInsideMetrics(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(Metrics)
+	router.Use(Recover(zap.NewNop().Sugar()))
+	router.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	}).Methods(http.MethodGet)
+
+	requests := metrics.HTTPRequests.WithLabelValues("/panic", http.MethodGet, "500")
+	before := testutil.ToFloat64(requests)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("response = %d %q, want 500 problem", w.Code, w.Header().Get("Content-Type"))
+	}
+	if got := testutil.ToFloat64(requests) - before; got != 1 {
+		t.Errorf("counted %v requests with status 500, want 1", got)
+	}
+}
+
+func TestRecoverAfterResponseStarted(t *testing.T) {
+	h := Recover(zap.NewNop().Sugar())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("partial"))
+		panic("handler failed")
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusOK || w.Body.String() != "partial" {
+		t.Errorf("response = %d %q, want the started response", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,19 @@
+package querytimeout
+
+import (
+	"context"
+	"time"
+)
+
+type timeoutKey struct{}
+
+// With sets the statement_timeout for the queries made with ctx, so that
+// Postgres itself stops a query that runs longer than d.
+func With(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutKey{}, d)
+}
+
+func FromContext(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Value(timeoutKey{}).(time.Duration)
+	return d, ok && d > 0
+}
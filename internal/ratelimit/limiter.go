@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// Limit describes a token bucket: Burst tokens at most, refilled at Rate
+// tokens per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+type Store interface {
+	Take(key string, limit Limit) (Result, error)
+}
+
+type Limiter struct {
+	store        Store
+	defaultLimit Limit
+	routes       map[string]Limit
+}
+
+func New(store Store, defaultLimit Limit) *Limiter {
+	return &Limiter{
+		store:        store,
+		defaultLimit: defaultLimit,
+		routes:       make(map[string]Limit),
+	}
+}
+
+func (l *Limiter) SetRouteLimit(method, path string, limit Limit) {
+	l.routes[method+" "+path] = limit
+}
+
+// Allow takes a token from the bucket of client on the given route. Every
+// route has its own bucket, so a client exhausting one route can still
+// use the others.
+func (l *Limiter) Allow(method, path, client string) (Result, error) {
+	limit, ok := l.routes[method+" "+path]
+	if !ok {
+		limit = l.defaultLimit
+	}
+	return l.store.Take(method+" "+path+"|"+client, limit)
+}
+
+func resultFromTokens(tokens float64, allowed bool, limit Limit) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     secondsToDuration((float64(limit.Burst) - tokens) / limit.Rate),
+	}
+	if !allowed {
+		res.RetryAfter = secondsToDuration((1 - tokens) / limit.Rate)
+	}
+	return res
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
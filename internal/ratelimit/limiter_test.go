@@ -0,0 +1,137 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// slow refills so slowly that the tests don't see a refill.
+var slow = Limit{Rate: 0.001, Burst: 3}
+
+func TestMemoryStoreTake(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     Limit
+		takes     int
+		allowed   bool
+		remaining int
+	}{
+		{"first take", slow, 1, true, 2},
+		{"last token", slow, 3, true, 0},
+		{"over the burst", slow, 4, false, 0},
+		{"burst of one", Limit{Rate: 0.001, Burst: 1}, 2, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			var res Result
+			for i := 0; i < tt.takes; i++ {
+				var err error
+				res, err = store.Take("client", tt.limit)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+			if res.Allowed != tt.allowed || res.Remaining != tt.remaining {
+				t.Errorf("Take() = allowed %v, remaining %d, want %v, %d", res.Allowed, res.Remaining, tt.allowed, tt.remaining)
+			}
+			if res.Limit != tt.limit.Burst {
+				t.Errorf("Limit = %d, want %d", res.Limit, tt.limit.Burst)
+			}
+			if !res.Allowed && res.RetryAfter <= 0 {
+				t.Errorf("RetryAfter = %s, want positive", res.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreRefill(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Rate: 100, Burst: 1}
+	if res, _ := store.Take("client", limit); !res.Allowed {
+		t.Fatal("first take is not allowed")
+	}
+	if res, _ := store.Take("client", limit); res.Allowed {
+		t.Fatal("take over the burst is allowed")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if res, _ := store.Take("client", limit); !res.Allowed {
+		t.Error("take after the refill is not allowed")
+	}
+}
+
+func TestLimiterBuckets(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		client  string
+		allowed bool
+	}{
+		{"same route and client", "GET", "/user/{USER_ID}", "alice", false},
+		{"another client", "GET", "/user/{USER_ID}", "bob", true},
+		{"another route", "PUT", "/user", "alice", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := New(NewMemoryStore(), Limit{Rate: 0.001, Burst: 1})
+			if res, _ := limiter.Allow("GET", "/user/{USER_ID}", "alice"); !res.Allowed {
+				t.Fatal("first request is not allowed")
+			}
+			res, err := limiter.Allow(tt.method, tt.path, tt.client)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if res.Allowed != tt.allowed {
+				t.Errorf("Allow() = %v, want %v", res.Allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestLimiterRouteLimit(t *testing.T) {
+	limiter := New(NewMemoryStore(), Limit{Rate: 0.001, Burst: 1})
+	limiter.SetRouteLimit("GET", "/users", Limit{Rate: 0.001, Burst: 5})
+	tests := []struct {
+		method string
+		path   string
+		limit  int
+	}{
+		{"GET", "/users", 5},
+		{"POST", "/users", 1},
+		{"GET", "/user/{USER_ID}", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			res, err := limiter.Allow(tt.method, tt.path, "alice")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if res.Limit != tt.limit {
+				t.Errorf("Limit = %d, want %d", res.Limit, tt.limit)
+			}
+		})
+	}
+}
+
+func TestResultFromTokens(t *testing.T) {
+	limit := Limit{Rate: 2, Burst: 10}
+	tests := []struct {
+		name    string
+		tokens  float64
+		allowed bool
+		want    Result
+	}{
+		{"full", 10, true, Result{Allowed: true, Limit: 10, Remaining: 10}},
+		{"half", 5.5, true, Result{Allowed: true, Limit: 10, Remaining: 5, Reset: 2250 * time.Millisecond}},
+		{"empty", 0, true, Result{Allowed: true, Limit: 10, Remaining: 0, Reset: 5 * time.Second}},
+		{"denied", 0.5, false, Result{Limit: 10, Remaining: 0, Reset: 4750 * time.Millisecond, RetryAfter: 250 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resultFromTokens(tt.tokens, tt.allowed, limit); got != tt.want {
+				t.Errorf("resultFromTokens() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
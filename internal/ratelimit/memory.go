@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const memorySweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time
+}
+
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (ms *MemoryStore) Take(key string, limit Limit) (Result, error) {
+	now := time.Now()
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.sweep(now)
+
+	b, ok := ms.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		ms.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	res := resultFromTokens(b.tokens, allowed, limit)
+	b.full = now.Add(res.Reset)
+	return res, nil
+}
+
+// sweep drops buckets that have refilled completely, since forgetting a
+// full bucket changes nothing.
+func (ms *MemoryStore) sweep(now time.Time) {
+	if now.Sub(ms.lastSweep) < memorySweepInterval {
+		return
+	}
+	for key, b := range ms.buckets {
+		if now.After(b.full) {
+			delete(ms.buckets, key)
+		}
+	}
+	ms.lastSweep = now
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"strconv"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const redisKeyPrefix = "ratelimit:"
+
+// takeScript refills and takes from a bucket atomically. Redis TIME is used
+// so that instances with skewed clocks share the same notion of now. The
+// token count is returned as a string because Lua numbers are truncated to
+// integers on the way back.
+var takeScript = redis.NewScript(1, `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local data = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(data[1])
+local ts = tonumber(data[2])
+if tokens == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000))
+return {allowed, tostring(tokens)}
+`)
+
+type RedisStore struct {
+	pool *redis.Pool
+}
+
+func NewRedisStore(pool *redis.Pool) *RedisStore {
+	return &RedisStore{pool: pool}
+}
+
+func (rs *RedisStore) Take(key string, limit Limit) (Result, error) {
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	reply, err := redis.Values(takeScript.Do(conn, redisKeyPrefix+key, limit.Rate, limit.Burst))
+	if err != nil {
+		return Result{}, err
+	}
+	var allowed int
+	var tokensStr string
+	if _, err = redis.Scan(reply, &allowed, &tokensStr); err != nil {
+		return Result{}, err
+	}
+	tokens, err := strconv.ParseFloat(tokensStr, 64)
+	if err != nil {
+		return Result{}, err
+	}
+	return resultFromTokens(tokens, allowed == 1, limit), nil
+}
@@ -0,0 +1,56 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{2, 400 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{50, time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if d := b.Delay(tt.attempt); d < tt.max/2 || d > tt.max {
+				t.Fatalf("Delay(%d) = %s, want between %s and %s", tt.attempt, d, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+func TestDoRetriesUntilSuccess(t *testing.T) {
+	calls := 0
+	err := Do(context.Background(), Backoff{Initial: time.Millisecond, Max: 2 * time.Millisecond}, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Do() = %v after %d calls, want success after 3", err, calls)
+	}
+}
+
+func TestDoStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	failure := errors.New("connection refused")
+	err := Do(ctx, Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond}, func(ctx context.Context) error {
+		return failure
+	})
+	if !errors.Is(err, failure) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Do() = %v, want the last error and the deadline", err)
+	}
+}
@@ -0,0 +1,131 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/metrics"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+// Locker makes sure a planned run of a task happens on one instance only.
+type Locker interface {
+	RunExclusiveStorage(ctx context.Context, name string, scheduledAt time.Time, fn func(ctx context.Context) error) (bool, error)
+}
+
+type Task struct {
+	Name     string
+	Schedule string
+	Run      func(ctx context.Context) error
+}
+
+type scheduledTask struct {
+	Task
+	schedule cron.Schedule
+}
+
+// Scheduler runs tasks on cron schedules. Every instance of the service
+// runs the same schedules, the Locker picks the one that does the work.
+type Scheduler struct {
+	locker Locker
+	logger *zap.SugaredLogger
+	tasks  []scheduledTask
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func New(locker Locker, logger *zap.SugaredLogger) *Scheduler {
+	return &Scheduler{locker: locker, logger: logger}
+}
+
+// Add registers a task, a task with an empty schedule is disabled. The
+// schedule has the standard five cron fields or is a descriptor like
+// @hourly or @every 10m.
+func (s *Scheduler) Add(task Task) error {
+	if task.Schedule == "" {
+		s.logger.Infof("scheduled task %s is disabled", task.Name)
+		return nil
+	}
+	schedule, err := cron.ParseStandard(task.Schedule)
+	if err != nil {
+		return fmt.Errorf("task %s: %w", task.Name, err)
+	}
+	s.tasks = append(s.tasks, scheduledTask{Task: task, schedule: schedule})
+	return nil
+}
+
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	for _, task := range s.tasks {
+		s.wg.Add(1)
+		go func(task scheduledTask) {
+			defer s.wg.Done()
+			s.loop(ctx, task)
+		}(task)
+	}
+}
+
+// Shutdown stops the schedules and waits for the running tasks until ctx
+// is done. Running tasks are cancelled right away, they are all safe to
+// stop and run again at the next planned time.
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	if s.cancel == nil {
+		return nil
+	}
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, task scheduledTask) {
+	for {
+		next := nextRun(task.schedule, time.Now())
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.run(ctx, task, next)
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, task scheduledTask, scheduledAt time.Time) {
+	logger := s.logger.With("task", task.Name, "scheduled_at", scheduledAt)
+	start := time.Now()
+	ran, err := s.locker.RunExclusiveStorage(ctx, task.Name, scheduledAt, task.Run)
+	switch {
+	case err != nil:
+		metrics.ScheduledTaskRuns.WithLabelValues(task.Name, metrics.TaskFailed).Inc()
+		logger.Errorf("scheduled task failed after %s: %s", time.Since(start), err)
+	case !ran:
+		metrics.ScheduledTaskRuns.WithLabelValues(task.Name, metrics.TaskSkipped).Inc()
+		logger.Debugf("scheduled task runs on another instance")
+	default:
+		metrics.ScheduledTaskRuns.WithLabelValues(task.Name, metrics.TaskSucceeded).Inc()
+		logger.Infof("scheduled task finished in %s", time.Since(start))
+	}
+}
+
+// nextRun aligns @every schedules to multiples of the delay, so that all
+// instances plan the same runs and the Locker can tell a run was done.
+func nextRun(schedule cron.Schedule, now time.Time) time.Time {
+	if every, ok := schedule.(cron.ConstantDelaySchedule); ok {
+		return now.Truncate(every.Delay).Add(every.Delay)
+	}
+	return schedule.Next(now)
+}
@@ -0,0 +1,186 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+// memoryLocker remembers the last run of every task, like the
+// scheduled_tasks table, and lets one caller run a task at a time.
+type memoryLocker struct {
+	mu        sync.Mutex
+	running   map[string]bool
+	lastRunAt map[string]time.Time
+}
+
+func newMemoryLocker() *memoryLocker {
+	return &memoryLocker{running: map[string]bool{}, lastRunAt: map[string]time.Time{}}
+}
+
+func (l *memoryLocker) RunExclusiveStorage(ctx context.Context, name string, scheduledAt time.Time, fn func(ctx context.Context) error) (bool, error) {
+	l.mu.Lock()
+	if l.running[name] || !l.lastRunAt[name].Before(scheduledAt) {
+		l.mu.Unlock()
+		return false, nil
+	}
+	l.running[name] = true
+	l.mu.Unlock()
+
+	err := fn(ctx)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.running[name] = false
+	if err == nil {
+		l.lastRunAt[name] = scheduledAt
+	}
+	return true, err
+}
+
+// taskRuns reads the run counter, it is global so tests compare deltas.
+func taskRuns(name, result string) float64 {
+	return testutil.ToFloat64(metrics.ScheduledTaskRuns.WithLabelValues(name, result))
+}
+
+func TestAdd(t *testing.T) {
+	s := New(newMemoryLocker(), zap.NewNop().Sugar())
+	for _, schedule := range []string{"", "@hourly", "@every 10m", "30 3 * * *"} {
+		if err := s.Add(Task{Name: "task", Schedule: schedule}); err != nil {
+			t.Errorf("Add(%q) error: %v", schedule, err)
+		}
+	}
+	if len(s.tasks) != 3 {
+		t.Errorf("%d tasks are scheduled, want 3 without the disabled one", len(s.tasks))
+	}
+	for _, schedule := range []string{"every hour", "* * *", "@every soon"} {
+		if err := s.Add(Task{Name: "task", Schedule: schedule}); err == nil {
+			t.Errorf("Add(%q) error = nil, want the schedule rejected", schedule)
+		}
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 7, 42, 0, time.UTC)
+	tests := []struct {
+		schedule string
+		want     time.Time
+	}{
+		{"@every 10m", time.Date(2024, 3, 1, 12, 10, 0, 0, time.UTC)},
+		{"@every 1h", time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)},
+		{"30 3 * * *", time.Date(2024, 3, 2, 3, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		schedule, err := cron.ParseStandard(tt.schedule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := nextRun(schedule, now); !got.Equal(tt.want) {
+			t.Errorf("nextRun(%q) = %s, want %s", tt.schedule, got, tt.want)
+		}
+	}
+
+	// Instances started at different times plan the same run.
+	every, _ := cron.ParseStandard("@every 10m")
+	if a, b := nextRun(every, now), nextRun(every, now.Add(-6*time.Minute)); !a.Equal(b) {
+		t.Errorf("nextRun() = %s and %s, want the same run", a, b)
+	}
+}
+
+func TestRunOnOneInstance(t *testing.T) {
+	locker := newMemoryLocker()
+	runs := 0
+	task := Task{Name: "test_run_once", Schedule: "@hourly", Run: func(ctx context.Context) error {
+		runs++
+		return nil
+	}}
+	instances := []*Scheduler{New(locker, zap.NewNop().Sugar()), New(locker, zap.NewNop().Sugar())}
+	for _, s := range instances {
+		if err := s.Add(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scheduledAt := time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)
+	succeeded := taskRuns(task.Name, metrics.TaskSucceeded)
+	skipped := taskRuns(task.Name, metrics.TaskSkipped)
+
+	for _, s := range instances {
+		s.run(context.Background(), s.tasks[0], scheduledAt)
+	}
+	if runs != 1 {
+		t.Errorf("task ran %d times, want once", runs)
+	}
+	succeeded = taskRuns(task.Name, metrics.TaskSucceeded) - succeeded
+	skipped = taskRuns(task.Name, metrics.TaskSkipped) - skipped
+	if succeeded != 1 || skipped != 1 {
+		t.Errorf("runs succeeded %v, skipped %v, want 1 and 1", succeeded, skipped)
+	}
+
+	instances[0].run(context.Background(), instances[0].tasks[0], scheduledAt.Add(time.Hour))
+	if runs != 2 {
+		t.Errorf("task ran %d times, want the next planned run done too", runs)
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	locker := newMemoryLocker()
+	fails := true
+	s := New(locker, zap.NewNop().Sugar())
+	err := s.Add(Task{Name: "test_run_failure", Schedule: "@hourly", Run: func(ctx context.Context) error {
+		if fails {
+			return errors.New("connection reset")
+		}
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduledAt := time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)
+	failed := taskRuns("test_run_failure", metrics.TaskFailed)
+	succeeded := taskRuns("test_run_failure", metrics.TaskSucceeded)
+
+	s.run(context.Background(), s.tasks[0], scheduledAt)
+	if failed := taskRuns("test_run_failure", metrics.TaskFailed) - failed; failed != 1 {
+		t.Errorf("failed runs = %v, want 1", failed)
+	}
+	// A failed run isn't recorded as done, another instance may retry it.
+	fails = false
+	s.run(context.Background(), s.tasks[0], scheduledAt)
+	if succeeded := taskRuns("test_run_failure", metrics.TaskSucceeded) - succeeded; succeeded != 1 {
+		t.Errorf("succeeded runs = %v, want the failed run retried", succeeded)
+	}
+}
+
+func TestShutdownCancelsRunningTasks(t *testing.T) {
+	if err := New(newMemoryLocker(), zap.NewNop().Sugar()).Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown() of a scheduler that wasn't started = %v", err)
+	}
+
+	s := New(newMemoryLocker(), zap.NewNop().Sugar())
+	started := make(chan struct{})
+	err := s.Add(Task{Name: "test_shutdown", Schedule: "@every 1s", Run: func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	select {
+	case <-started:
+	case <-time.After(3 * time.Second):
+		t.Fatal("task didn't run")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = s.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown() error: %v", err)
+	}
+}
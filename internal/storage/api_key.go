@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/dto"
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/jackc/pgx/v5"
+)
+
+type APIKeyStorage interface {
+	CreateAPIKeyStorage(ctx context.Context, key entity.APIKey, keyHash string) (int64, error)
+	ListAPIKeysStorage(ctx context.Context) ([]entity.APIKey, error)
+	RevokeAPIKeyStorage(ctx context.Context, ID int64, revokedAt time.Time) (bool, error)
+	UseAPIKeyStorage(ctx context.Context, keyHash string, usedAt time.Time) (*entity.APIKey, error)
+}
+
+const apiKeyColumns = "id, name, prefix, scopes, created_at, last_used_at, expires_at, revoked_at"
+
+func (ps *DBStorage) CreateAPIKeyStorage(ctx context.Context, key entity.APIKey, keyHash string) (int64, error) {
+	queryCtx, end := startQuery(ctx, "create_api_key")
+	defer end()
+	var lastInsertId int64
+	err := ps.db.QueryRow(queryCtx, createAPIKeyStmt,
+		key.Name,
+		keyHash,
+		key.Prefix,
+		strings.Join(key.Scopes, " "),
+		key.CreatedAt,
+		getNullOrTime(key.ExpiresAt),
+	).Scan(&lastInsertId)
+	if err != nil {
+		return 0, err
+	}
+	return lastInsertId, nil
+}
+
+func (ps *DBStorage) ListAPIKeysStorage(ctx context.Context) ([]entity.APIKey, error) {
+	queryCtx, end := startQuery(ctx, "list_api_keys")
+	defer end()
+	rows, err := ps.db.Query(queryCtx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []entity.APIKey
+	for rows.Next() {
+		var key dto.APIKeyDB
+		err = rows.Scan(&key.ID, &key.Name, &key.Prefix, &key.Scopes, &key.CreatedAt, &key.LastUsedAt, &key.ExpiresAt, &key.RevokedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key.ConvertToAPIKey())
+	}
+	return keys, rows.Err()
+}
+
+func (ps *DBStorage) RevokeAPIKeyStorage(ctx context.Context, ID int64, revokedAt time.Time) (bool, error) {
+	queryCtx, end := startQuery(ctx, "revoke_api_key")
+	defer end()
+	res, err := ps.db.Exec(queryCtx,
+		"UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL",
+		revokedAt,
+		ID,
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() > 0, nil
+}
+
+// UseAPIKeyStorage returns the active key with the given hash and records
+// the time it was used, if the recorded one is more than a minute old. The
+// returned key has the time recorded before. Revoked and expired keys are
+// reported as not found.
+func (ps *DBStorage) UseAPIKeyStorage(ctx context.Context, keyHash string, usedAt time.Time) (*entity.APIKey, error) {
+	queryCtx, end := startQuery(ctx, "use_api_key")
+	defer end()
+	key := &dto.APIKeyDB{}
+	err := ps.db.QueryRow(queryCtx, useAPIKeyStmt,
+		keyHash,
+		usedAt,
+	).Scan(&key.ID, &key.Name, &key.Prefix, &key.Scopes, &key.CreatedAt, &key.LastUsedAt, &key.ExpiresAt, &key.RevokedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	convertedKey := key.ConvertToAPIKey()
+	return &convertedKey, nil
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const uniqueViolationCode = "23505"
+
+type AttributeStorage interface {
+	CreateAttributeStorage(ctx context.Context, attr entity.Attribute) (bool, error)
+	ListAttributesStorage(ctx context.Context) ([]entity.Attribute, error)
+	DeleteAttributeStorage(ctx context.Context, name string) (bool, error)
+}
+
+// UniqueAttributeError means another user already has the value of a
+// unique attribute.
+type UniqueAttributeError struct {
+	Name string
+}
+
+func (e *UniqueAttributeError) Error() string {
+	return fmt.Sprintf("value of attribute %s is already taken", e.Name)
+}
+
+// attributeIndexPrefix starts the names of the unique indexes, it tells a
+// violation of one of them from other unique violations.
+const attributeIndexPrefix = "users_attr_"
+
+func attributeIndexName(name string) string {
+	return attributeIndexPrefix + name + "_key"
+}
+
+// attributeNamePattern is the form of registered attribute names. Names are
+// put into SQL as is, so storage checks them itself and doesn't rely on the
+// callers.
+var attributeNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+func validAttributeName(name string) bool {
+	return attributeNamePattern.MatchString(name)
+}
+
+// attributeValue is the text of the attribute in SQL. The name must pass
+// validAttributeName.
+func attributeValue(name string) string {
+	return "(attributes->>'" + name + "')"
+}
+
+// attributeError turns the violation of a unique attribute index into
+// *UniqueAttributeError.
+func attributeError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode && strings.HasPrefix(pgErr.ConstraintName, attributeIndexPrefix) {
+		name := strings.TrimSuffix(strings.TrimPrefix(pgErr.ConstraintName, attributeIndexPrefix), "_key")
+		return &UniqueAttributeError{Name: name}
+	}
+	return err
+}
+
+// CreateAttributeStorage registers the attribute and builds the unique
+// index of a unique one. The index is built CONCURRENTLY so that users
+// stay writable meanwhile, which can't happen in a transaction. If the
+// build fails, for example because users already share a value, the
+// invalid index and the registry row are removed. It returns false if an
+// attribute with the name already exists.
+func (ps *DBStorage) CreateAttributeStorage(ctx context.Context, attr entity.Attribute) (bool, error) {
+	if !validAttributeName(attr.Name) {
+		return false, fmt.Errorf("bad attribute name %q", attr.Name)
+	}
+	queryCtx, end := startQuery(ctx, "create_attribute")
+	defer end()
+
+	res, err := ps.db.Exec(queryCtx,
+		`INSERT INTO user_attributes (name, type, required, is_unique, rules, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (name) DO NOTHING`,
+		attr.Name,
+		attr.Type,
+		attr.Required,
+		attr.Unique,
+		attr.Rules,
+		attr.CreatedAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	if res.RowsAffected() == 0 {
+		return false, nil
+	}
+	if !attr.Unique {
+		return true, nil
+	}
+	index := pgx.Identifier{attributeIndexName(attr.Name)}.Sanitize()
+	_, err = ps.db.Exec(queryCtx, fmt.Sprintf("CREATE UNIQUE INDEX CONCURRENTLY %s ON users (%s) WHERE merged_into IS NULL",
+		index, attributeValue(attr.Name)))
+	if err != nil {
+		// The request may be gone, the cleanup must still run.
+		ps.dropAttribute(context.WithoutCancel(ctx), attr.Name, index)
+		return false, err
+	}
+	return true, nil
+}
+
+// dropAttribute undoes a failed creation: a failed concurrent build leaves
+// an INVALID index behind, which still slows down the writes.
+func (ps *DBStorage) dropAttribute(ctx context.Context, name, index string) {
+	_, err := ps.db.Exec(ctx, "DROP INDEX CONCURRENTLY IF EXISTS "+index)
+	if err != nil {
+		log.Printf("error in dropping index %s: %s", index, err)
+	}
+	_, err = ps.db.Exec(ctx, "DELETE FROM user_attributes WHERE name = $1", name)
+	if err != nil {
+		log.Printf("error in removing attribute %s: %s", name, err)
+	}
+}
+
+func (ps *DBStorage) ListAttributesStorage(ctx context.Context) ([]entity.Attribute, error) {
+	queryCtx, end := startQuery(ctx, "list_attributes")
+	defer end()
+	rows, err := ps.db.Query(queryCtx,
+		"SELECT name, type, required, is_unique, rules, created_at FROM user_attributes ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attrs []entity.Attribute
+	for rows.Next() {
+		var attr entity.Attribute
+		err = rows.Scan(&attr.Name, &attr.Type, &attr.Required, &attr.Unique, &attr.Rules, &attr.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+	}
+	return attrs, rows.Err()
+}
+
+// DeleteAttributeStorage removes the attribute from the registry and its
+// values from all users, so that a later attribute with the same name
+// doesn't meet values of another type. The cached users are dropped.
+func (ps *DBStorage) DeleteAttributeStorage(ctx context.Context, name string) (bool, error) {
+	queryCtx, end := startQuery(ctx, "delete_attribute")
+	defer end()
+	tx, err := ps.db.Begin(queryCtx)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		err = tx.Rollback(ctx)
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Printf("error in rolling back attribute removal: %s", err)
+		}
+	}()
+
+	res, err := tx.Exec(queryCtx, "DELETE FROM user_attributes WHERE name = $1", name)
+	if err != nil {
+		return false, err
+	}
+	if res.RowsAffected() == 0 {
+		return false, nil
+	}
+	_, err = tx.Exec(queryCtx, "DROP INDEX IF EXISTS "+pgx.Identifier{attributeIndexName(name)}.Sanitize())
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(queryCtx, "UPDATE users SET attributes = attributes - $1::text WHERE attributes ? $1::text", name)
+	if err != nil {
+		return false, err
+	}
+	if err = tx.Commit(queryCtx); err != nil {
+		return false, err
+	}
+	ps.goBackground(ctx, func(ctx context.Context) { ps.dropUsersFromRedis(ctx) })
+	return true, nil
+}
+
+func (ps *DBStorage) dropUsersFromRedis(ctx context.Context) {
+	conn := ps.redisPool.Get()
+	defer conn.Close()
+
+	_, err := redisDo(ctx, conn, "DEL", "users")
+	if err != nil {
+		fmt.Printf("Error dropping users from Redis: %s\n", err)
+	}
+}
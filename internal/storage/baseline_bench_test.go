@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/filters"
+	_ "github.com/jackc/pgx/stdlib"
+)
+
+// sqlBaseline runs the queries of the storage through database/sql and the
+// pgx v3 driver it used before the move to pgxpool, so that the benchmarks
+// can be compared with benchstat. Before the move bulk inserts were single
+// INSERTs in one transaction.
+type sqlBaseline struct {
+	db *sql.DB
+}
+
+func newSQLBaseline(b *testing.B) *sqlBaseline {
+	b.Helper()
+	dsn := os.Getenv("BENCH_POSTGRES_DSN")
+	if dsn == "" {
+		b.Skip("BENCH_POSTGRES_DSN is not set")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		b.Fatalf("connecting to postgres: %s", err)
+	}
+	b.Cleanup(func() {
+		_, err := db.Exec("DELETE FROM users WHERE surname = $1", benchUserSurname)
+		if err != nil {
+			b.Errorf("removing benchmark users: %s", err)
+		}
+		db.Close()
+	})
+	return &sqlBaseline{db: db}
+}
+
+func (sb *sqlBaseline) createUser(ctx context.Context, user entity.User) (int64, error) {
+	query := "INSERT INTO users (surname, name, gender, status, join_date, status_changed_at"
+	values := []interface{}{user.Surname, user.Name, user.Gender, user.Status, user.JoinDate, user.StatusChangedAt}
+	if user.Patronymic != "" {
+		query += ", patronymic"
+		values = append(values, user.Patronymic)
+	}
+	if !user.Birthday.IsZero() {
+		query += ", birthday"
+		values = append(values, user.Birthday)
+	}
+	query += ") VALUES ("
+	for i := range values {
+		query += fmt.Sprintf("$%d", i+1)
+		if i < len(values)-1 {
+			query += ", "
+		}
+	}
+	query += ") RETURNING id"
+
+	var ID int64
+	err := sb.db.QueryRowContext(ctx, query, values...).Scan(&ID)
+	return ID, err
+}
+
+func (sb *sqlBaseline) createUsers(ctx context.Context, users []entity.User) error {
+	tx, err := sb.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx,
+		`INSERT INTO users (surname, name, patronymic, gender, status, birthday, join_date, status_changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, user := range users {
+		_, err = stmt.ExecContext(ctx, user.Surname, user.Name, getNullOrStr(user.Patronymic), user.Gender,
+			user.Status, getNullOrTime(user.Birthday), user.JoinDate, user.StatusChangedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (sb *sqlBaseline) searchUsers(ctx context.Context, filter filters.Filter) ([]entity.User, error) {
+	query, values := buildSearchQuery(filter)
+	rows, err := sb.db.QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []entity.User
+	for rows.Next() {
+		var (
+			user                  entity.User
+			patronymic, banReason sql.NullString
+			birthday, bannedUntil sql.NullTime
+			mergedInto            sql.NullInt64
+			attributes            []byte
+		)
+		err = rows.Scan(&user.ID, &user.Name, &user.Surname, &patronymic, &user.Gender, &user.Status, &birthday,
+			&user.JoinDate, &mergedInto, &user.StatusChangedAt, &bannedUntil, &banReason, &attributes)
+		if err != nil {
+			return nil, err
+		}
+		user.Patronymic, user.Birthday = patronymic.String, birthday.Time
+		user.BannedUntil, user.BanReason = bannedUntil.Time, banReason.String
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func BenchmarkCreateUserBaseline(b *testing.B) {
+	sb := newSQLBaseline(b)
+	ctx := context.Background()
+	users := benchUsers(b.N)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := sb.createUser(ctx, users[i]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCreateUsersBaseline(b *testing.B) {
+	for _, size := range []int{100, 1000} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			sb := newSQLBaseline(b)
+			ctx := context.Background()
+			users := benchUsers(size)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := sb.createUsers(ctx, users); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSearchUsersBaseline(b *testing.B) {
+	sb := newSQLBaseline(b)
+	ctx := context.Background()
+	if err := sb.createUsers(ctx, benchUsers(10000)); err != nil {
+		b.Fatal(err)
+	}
+	filter := filters.Filter{
+		FullName:         benchUserSurname,
+		AttributesToSort: "name",
+		SortAsk:          true,
+		Limit:            50,
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := sb.searchUsers(ctx, filter); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
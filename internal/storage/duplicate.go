@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/ivanov-nikolay/user-api/internal/dto"
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+type DuplicateStorage interface {
+	GetDuplicateCandidatesStorage(ctx context.Context, user entity.User) ([]entity.User, error)
+	GetDuplicateCandidatePairsStorage(ctx context.Context, after [2]int64, limit int) ([][2]entity.User, error)
+}
+
+// GetDuplicateCandidatesStorage returns users that may be the same person
+// as user: with the same birthday, or with the same name and surname when
+// either birthday is unknown. Deleted and merged users and user itself are
+// skipped.
+func (ps *DBStorage) GetDuplicateCandidatesStorage(ctx context.Context, user entity.User) ([]entity.User, error) {
+	queryCtx, end := startQuery(ctx, "get_duplicate_candidates")
+	defer end()
+	rows, err := ps.db.Query(queryCtx,
+		`SELECT id, name, surname, patronymic, gender, status, birthday, join_date FROM users
+		WHERE id <> $1 AND status <> 'deleted' AND merged_into IS NULL AND (
+			($2::timestamp IS NOT NULL AND birthday::date = $2::date)
+			OR (($2::timestamp IS NULL OR birthday IS NULL) AND lower(surname) = lower($3) AND lower(name) = lower($4))
+		)`,
+		user.ID,
+		getNullOrTime(user.Birthday),
+		user.Surname,
+		user.Name,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []entity.User
+	for rows.Next() {
+		var candidate dto.UserDB
+		err = rows.Scan(&candidate.ID, &candidate.Name, &candidate.Surname, &candidate.Patronymic, &candidate.Gender, &candidate.Status, &candidate.Birthday, &candidate.JoinDate)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, candidate.ConvertToUser())
+	}
+	return users, rows.Err()
+}
+
+// GetDuplicateCandidatePairsStorage applies the candidate rules of
+// GetDuplicateCandidatesStorage to the whole table. Each pair is returned
+// once, with the lower id first. Pairs are ordered by their ids and paged
+// by them: at most limit pairs that come after the ids of after. The query
+// runs with the statement_timeout of ctx.
+func (ps *DBStorage) GetDuplicateCandidatePairsStorage(ctx context.Context, after [2]int64, limit int) ([][2]entity.User, error) {
+	queryCtx, end := startQuery(ctx, "get_duplicate_candidate_pairs")
+	defer end()
+
+	var pairs [][2]entity.User
+	err := readWithTimeout(queryCtx, ps.db, func(q querier) error {
+		rows, err := q.Query(queryCtx,
+			`SELECT a.id, a.name, a.surname, a.patronymic, a.gender, a.status, a.birthday, a.join_date,
+				b.id, b.name, b.surname, b.patronymic, b.gender, b.status, b.birthday, b.join_date
+			FROM users a JOIN users b ON a.id < b.id AND (
+				a.birthday::date = b.birthday::date
+				OR ((a.birthday IS NULL OR b.birthday IS NULL) AND lower(a.surname) = lower(b.surname) AND lower(a.name) = lower(b.name))
+			)
+			WHERE a.status <> 'deleted' AND b.status <> 'deleted' AND a.merged_into IS NULL AND b.merged_into IS NULL
+				AND (a.id, b.id) > ($1, $2)
+			ORDER BY a.id, b.id
+			LIMIT $3`,
+			after[0],
+			after[1],
+			limit,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var a, b dto.UserDB
+			err = rows.Scan(
+				&a.ID, &a.Name, &a.Surname, &a.Patronymic, &a.Gender, &a.Status, &a.Birthday, &a.JoinDate,
+				&b.ID, &b.Name, &b.Surname, &b.Patronymic, &b.Gender, &b.Status, &b.Birthday, &b.JoinDate,
+			)
+			if err != nil {
+				return err
+			}
+			pairs = append(pairs, [2]entity.User{a.ConvertToUser(), b.ConvertToUser()})
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
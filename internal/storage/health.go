@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// SchemaVersion is the version _postgres/db.sql records in
+// schema_migrations. Bump both together when the schema changes.
+const SchemaVersion = 8
+
+func (ps *DBStorage) PingPostgresStorage(ctx context.Context) error {
+	return ps.db.Ping(ctx)
+}
+
+func (ps *DBStorage) PingRedisStorage(ctx context.Context) error {
+	conn, err := ps.redisPool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = redis.DoContext(conn, ctx, "PING")
+	return err
+}
+
+func (ps *DBStorage) CheckSchemaStorage(ctx context.Context) error {
+	var version int
+	err := ps.db.QueryRow(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version < SchemaVersion {
+		return fmt.Errorf("schema version is %d, %d is required", version, SchemaVersion)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestSchemaVersionMatchesSchema(t *testing.T) {
+	schema, err := os.ReadFile("../../_postgres/db.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pattern := regexp.MustCompile(`INSERT INTO schema_migrations \(version\) VALUES \((\d+)\)`)
+	latest := 0
+	for _, match := range pattern.FindAllSubmatch(schema, -1) {
+		version, err := strconv.Atoi(string(match[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		latest = max(latest, version)
+	}
+	if latest != SchemaVersion {
+		t.Errorf("db.sql records version %d, SchemaVersion is %d", latest, SchemaVersion)
+	}
+}
@@ -0,0 +1,402 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/dto"
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/jackc/pgx/v5"
+)
+
+type JobStorage interface {
+	CreateJobStorage(ctx context.Context, job entity.Job, payload []byte) (int64, error)
+	GetJobStorage(ctx context.Context, ID int64) (*entity.Job, error)
+	ListJobsStorage(ctx context.Context, status, kind string, limit int) ([]entity.Job, error)
+	GetJobPayloadStorage(ctx context.Context, ID int64) ([]byte, error)
+	LeaseJobStorage(ctx context.Context, workerID string, kinds []string, now, lockedUntil time.Time) (*entity.Job, error)
+	RenewJobLeaseStorage(ctx context.Context, ID int64, workerID string, lockedUntil time.Time) (bool, bool, error)
+	FinishJobStorage(ctx context.Context, ID int64, workerID, status, errText string, finishedAt time.Time) error
+	RetryJobStorage(ctx context.Context, ID int64, workerID, errText string, runAt time.Time) error
+	ReleaseJobStorage(ctx context.Context, ID int64, workerID string, runAt time.Time) error
+	CancelJobStorage(ctx context.Context, ID int64, finishedAt time.Time) (*entity.Job, error)
+	RequeueJobStorage(ctx context.Context, ID int64, runAt time.Time) (*entity.Job, error)
+	SetJobTotalStorage(ctx context.Context, ID int64, total int) error
+	SaveImportChunkStorage(ctx context.Context, jobID int64, users []entity.User, rowErrors []entity.JobRowError, progress entity.JobProgress) error
+	ExportJobRowErrorsStorage(ctx context.Context, ID int64, fn func(rowError entity.JobRowError) error) error
+}
+
+const jobColumns = `id, kind, status, params, total_rows, processed_rows, succeeded_rows, failed_rows,
+	attempts, max_attempts, run_at, cancel_requested, error, created_by, created_at, started_at, finished_at`
+
+func scanJob(row pgx.Row) (*entity.Job, error) {
+	job := &dto.JobDB{}
+	err := row.Scan(&job.ID, &job.Kind, &job.Status, &job.Params, &job.TotalRows, &job.ProcessedRows, &job.SucceededRows, &job.FailedRows,
+		&job.Attempts, &job.MaxAttempts, &job.RunAt, &job.CancelRequested, &job.Error, &job.CreatedBy, &job.CreatedAt, &job.StartedAt, &job.FinishedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	convertedJob := job.ConvertToJob()
+	return &convertedJob, nil
+}
+
+func (ps *DBStorage) CreateJobStorage(ctx context.Context, job entity.Job, payload []byte) (int64, error) {
+	queryCtx, end := startQuery(ctx, "create_job")
+	defer end()
+	var lastInsertId int64
+	err := ps.db.QueryRow(queryCtx,
+		`INSERT INTO jobs (kind, status, params, payload, max_attempts, run_at, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		job.Kind,
+		job.Status,
+		[]byte(job.Params),
+		payload,
+		job.MaxAttempts,
+		job.RunAt,
+		getNullOrStr(job.CreatedBy),
+		job.CreatedAt,
+	).Scan(&lastInsertId)
+	if err != nil {
+		return 0, err
+	}
+	return lastInsertId, nil
+}
+
+func (ps *DBStorage) GetJobStorage(ctx context.Context, ID int64) (*entity.Job, error) {
+	queryCtx, end := startQuery(ctx, "get_job")
+	defer end()
+	return scanJob(ps.db.QueryRow(queryCtx, "SELECT "+jobColumns+" FROM jobs WHERE id = $1", ID))
+}
+
+// ListJobsStorage returns the newest jobs first. Empty status or kind
+// matches any.
+func (ps *DBStorage) ListJobsStorage(ctx context.Context, status, kind string, limit int) ([]entity.Job, error) {
+	queryCtx, end := startQuery(ctx, "list_jobs")
+	defer end()
+	rows, err := ps.db.Query(queryCtx,
+		`SELECT `+jobColumns+` FROM jobs
+		WHERE ($1::text = '' OR status = $1) AND ($2::text = '' OR kind = $2)
+		ORDER BY id DESC LIMIT $3`,
+		status,
+		kind,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := make([]entity.Job, 0)
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+func (ps *DBStorage) GetJobPayloadStorage(ctx context.Context, ID int64) ([]byte, error) {
+	queryCtx, end := startQuery(ctx, "get_job_payload")
+	defer end()
+	var payload []byte
+	err := ps.db.QueryRow(queryCtx, "SELECT payload FROM jobs WHERE id = $1", ID).Scan(&payload)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return payload, err
+}
+
+// LeaseJobStorage takes the oldest job of kinds that is due, or whose
+// worker stopped renewing its lease, and gives it to workerID until
+// lockedUntil. Jobs locked by other workers are skipped instead of waited
+// for, so workers never block each other. nil means there is nothing to do.
+func (ps *DBStorage) LeaseJobStorage(ctx context.Context, workerID string, kinds []string, now, lockedUntil time.Time) (*entity.Job, error) {
+	queryCtx, end := startQuery(ctx, "lease_job")
+	defer end()
+	return scanJob(ps.db.QueryRow(queryCtx,
+		`UPDATE jobs SET status = $1, attempts = attempts + 1, locked_by = $2, locked_until = $3,
+			started_at = COALESCE(started_at, $4)
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE kind = ANY($5) AND (
+				(status = $6 AND run_at <= $4) OR
+				(status = $1 AND locked_until < $4))
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING `+jobColumns,
+		entity.JobStatusRunning,
+		workerID,
+		lockedUntil,
+		now,
+		kinds,
+		entity.JobStatusPending,
+	))
+}
+
+// RenewJobLeaseStorage extends the lease of workerID. It reports whether
+// the worker still holds the job and whether cancellation was requested.
+func (ps *DBStorage) RenewJobLeaseStorage(ctx context.Context, ID int64, workerID string, lockedUntil time.Time) (bool, bool, error) {
+	queryCtx, end := startQuery(ctx, "renew_job_lease")
+	defer end()
+	var cancelRequested bool
+	err := ps.db.QueryRow(queryCtx,
+		`UPDATE jobs SET locked_until = $1 WHERE id = $2 AND locked_by = $3 AND status = $4
+		RETURNING cancel_requested`,
+		lockedUntil,
+		ID,
+		workerID,
+		entity.JobStatusRunning,
+	).Scan(&cancelRequested)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	return true, cancelRequested, nil
+}
+
+// FinishJobStorage records the outcome of a job held by workerID. The
+// payload of a succeeded job isn't needed anymore and is dropped, the
+// others keep it so they can be retried by hand.
+func (ps *DBStorage) FinishJobStorage(ctx context.Context, ID int64, workerID, status, errText string, finishedAt time.Time) error {
+	queryCtx, end := startQuery(ctx, "finish_job")
+	defer end()
+	_, err := ps.db.Exec(queryCtx,
+		`UPDATE jobs SET status = $1, error = $2, finished_at = $3, locked_by = NULL, locked_until = NULL,
+			payload = CASE WHEN $1::text = $4::text THEN NULL ELSE payload END
+		WHERE id = $5 AND locked_by = $6`,
+		status,
+		getNullOrStr(errText),
+		finishedAt,
+		entity.JobStatusSucceeded,
+		ID,
+		workerID,
+	)
+	return err
+}
+
+// RetryJobStorage puts a failed attempt back into the queue until runAt.
+func (ps *DBStorage) RetryJobStorage(ctx context.Context, ID int64, workerID, errText string, runAt time.Time) error {
+	queryCtx, end := startQuery(ctx, "retry_job")
+	defer end()
+	_, err := ps.db.Exec(queryCtx,
+		`UPDATE jobs SET status = $1, error = $2, run_at = $3, locked_by = NULL, locked_until = NULL
+		WHERE id = $4 AND locked_by = $5`,
+		entity.JobStatusPending,
+		errText,
+		runAt,
+		ID,
+		workerID,
+	)
+	return err
+}
+
+// ReleaseJobStorage gives a job back to the queue without counting the
+// attempt, it is used when the worker stops.
+func (ps *DBStorage) ReleaseJobStorage(ctx context.Context, ID int64, workerID string, runAt time.Time) error {
+	queryCtx, end := startQuery(ctx, "release_job")
+	defer end()
+	_, err := ps.db.Exec(queryCtx,
+		`UPDATE jobs SET status = $1, attempts = attempts - 1, run_at = $2, locked_by = NULL, locked_until = NULL
+		WHERE id = $3 AND locked_by = $4`,
+		entity.JobStatusPending,
+		runAt,
+		ID,
+		workerID,
+	)
+	return err
+}
+
+// CancelJobStorage cancels a pending job at once and asks the worker of a
+// running one to stop. nil is returned when the job doesn't exist or is
+// already finished.
+func (ps *DBStorage) CancelJobStorage(ctx context.Context, ID int64, finishedAt time.Time) (*entity.Job, error) {
+	queryCtx, end := startQuery(ctx, "cancel_job")
+	defer end()
+	return scanJob(ps.db.QueryRow(queryCtx,
+		`UPDATE jobs SET
+			status = CASE WHEN status = $1 THEN $2 ELSE status END,
+			finished_at = CASE WHEN status = $1 THEN $3 ELSE finished_at END,
+			cancel_requested = true
+		WHERE id = $4 AND status IN ($1, $5)
+		RETURNING `+jobColumns,
+		entity.JobStatusPending,
+		entity.JobStatusCancelled,
+		finishedAt,
+		ID,
+		entity.JobStatusRunning,
+	))
+}
+
+// RequeueJobStorage runs a failed, dead or cancelled job again with a
+// fresh set of attempts. nil is returned when the job doesn't exist or is
+// in another status.
+func (ps *DBStorage) RequeueJobStorage(ctx context.Context, ID int64, runAt time.Time) (*entity.Job, error) {
+	queryCtx, end := startQuery(ctx, "requeue_job")
+	defer end()
+	return scanJob(ps.db.QueryRow(queryCtx,
+		`UPDATE jobs SET status = $1, attempts = 0, run_at = $2, cancel_requested = false, error = NULL, finished_at = NULL
+		WHERE id = $3 AND status IN ($4, $5, $6)
+		RETURNING `+jobColumns,
+		entity.JobStatusPending,
+		runAt,
+		ID,
+		entity.JobStatusFailed,
+		entity.JobStatusDead,
+		entity.JobStatusCancelled,
+	))
+}
+
+func (ps *DBStorage) SetJobTotalStorage(ctx context.Context, ID int64, total int) error {
+	queryCtx, end := startQuery(ctx, "set_job_total")
+	defer end()
+	_, err := ps.db.Exec(queryCtx, "UPDATE jobs SET total_rows = $1 WHERE id = $2", total, ID)
+	return err
+}
+
+// ImportConflictError means some users of an import chunk have a value of a
+// unique attribute that is already taken. Nothing of the chunk is saved.
+// Conflicts maps the index of such a user in the chunk to its error.
+type ImportConflictError struct {
+	Conflicts map[int]*UniqueAttributeError
+}
+
+func (e *ImportConflictError) Error() string {
+	return fmt.Sprintf("%d users of the chunk have taken values of unique attributes", len(e.Conflicts))
+}
+
+// SaveImportChunkStorage inserts the valid users of a chunk, records the
+// invalid rows and moves the progress of the job, all in one transaction.
+// Users are nil in a dry run. If a unique attribute rejects the chunk, an
+// *ImportConflictError tells which users to leave out.
+func (ps *DBStorage) SaveImportChunkStorage(ctx context.Context, jobID int64, users []entity.User, rowErrors []entity.JobRowError, progress entity.JobProgress) error {
+	queryCtx, end := startQuery(ctx, "save_import_chunk")
+	defer end()
+	tx, err := ps.db.Begin(queryCtx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = tx.Rollback(ctx)
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Printf("error in rolling back import chunk: %s", err)
+		}
+	}()
+
+	if len(users) > 0 {
+		_, err = tx.CopyFrom(queryCtx, pgx.Identifier{"users"}, userCopyColumns, userCopySource(users))
+		var uniqueErr *UniqueAttributeError
+		if err = attributeError(err); errors.As(err, &uniqueErr) {
+			return ps.findImportConflicts(ctx, users, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(rowErrors) > 0 {
+		_, err = tx.CopyFrom(queryCtx,
+			pgx.Identifier{"job_row_errors"},
+			[]string{"job_id", "row_number", "errors"},
+			pgx.CopyFromSlice(len(rowErrors), func(i int) ([]interface{}, error) {
+				errorsJSON, err := json.Marshal(rowErrors[i].Errors)
+				if err != nil {
+					return nil, err
+				}
+				return []interface{}{jobID, rowErrors[i].Row, errorsJSON}, nil
+			}),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(queryCtx,
+		"UPDATE jobs SET processed_rows = $1, succeeded_rows = $2, failed_rows = $3 WHERE id = $4",
+		progress.Processed,
+		progress.Succeeded,
+		progress.Failed,
+		jobID,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(queryCtx)
+}
+
+// findImportConflicts inserts the users one by one, each under its own
+// savepoint, to find the ones a unique attribute rejects, and rolls all of
+// them back. A conflict with a user written meanwhile may be gone by then,
+// copyErr is returned if none is left.
+func (ps *DBStorage) findImportConflicts(ctx context.Context, users []entity.User, copyErr error) error {
+	queryCtx, end := startQuery(ctx, "find_import_conflicts")
+	defer end()
+	tx, err := ps.db.Begin(queryCtx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = tx.Rollback(ctx)
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Printf("error in rolling back import conflicts: %s", err)
+		}
+	}()
+
+	conflicts := make(map[int]*UniqueAttributeError)
+	for i := range users {
+		savepoint, err := tx.Begin(queryCtx)
+		if err != nil {
+			return err
+		}
+		_, err = savepoint.CopyFrom(queryCtx, pgx.Identifier{"users"}, userCopyColumns, userCopySource(users[i:i+1]))
+		var uniqueErr *UniqueAttributeError
+		if errors.As(attributeError(err), &uniqueErr) {
+			conflicts[i] = uniqueErr
+			if err = savepoint.Rollback(queryCtx); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = savepoint.Commit(queryCtx); err != nil {
+			return err
+		}
+	}
+	if len(conflicts) == 0 {
+		return copyErr
+	}
+	return &ImportConflictError{Conflicts: conflicts}
+}
+
+func (ps *DBStorage) ExportJobRowErrorsStorage(ctx context.Context, ID int64, fn func(rowError entity.JobRowError) error) error {
+	queryCtx, end := startQuery(ctx, "export_job_row_errors")
+	defer end()
+	rows, err := ps.db.Query(queryCtx, "SELECT row_number, errors FROM job_row_errors WHERE job_id = $1 ORDER BY row_number", ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rowError entity.JobRowError
+		err = rows.Scan(&rowError.Row, &rowError.Errors)
+		if err != nil {
+			return err
+		}
+		if err = fn(rowError); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
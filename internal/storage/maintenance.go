@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/ivanov-nikolay/user-api/internal/dto"
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/jackc/pgx/v5"
+)
+
+// userReadsKey is a sorted set of user IDs scored by how often they were
+// read. Scores are halved after every cache warm-up, so it follows what is
+// read now rather than what was read once.
+const userReadsKey = "users:reads"
+
+type MaintenanceStorage interface {
+	PurgeDeletedUsersStorage(ctx context.Context, before time.Time, batchSize int) (int64, error)
+	ListExpiredBansStorage(ctx context.Context, now time.Time, limit int) ([]int64, error)
+	WarmCacheStorage(ctx context.Context, limit int) (int, error)
+	RunExclusiveStorage(ctx context.Context, name string, scheduledAt time.Time, fn func(ctx context.Context) error) (bool, error)
+}
+
+// PurgeDeletedUsersStorage removes users that have been in status deleted
+// since before, together with their history. Users that others were merged
+// into are kept, the merged users redirect to them. It deletes in batches
+// so that a large purge doesn't hold locks on many rows at once.
+func (ps *DBStorage) PurgeDeletedUsersStorage(ctx context.Context, before time.Time, batchSize int) (int64, error) {
+	var purged int64
+	for {
+		IDs, err := ps.purgeDeletedBatch(ctx, before, batchSize)
+		if err != nil {
+			return purged, err
+		}
+		purged += int64(len(IDs))
+		for _, ID := range IDs {
+			if err = ps.deleteUserFromRedis(ctx, ID); err != nil {
+				log.Printf("error in removing purged user %d from cache: %s", ID, err)
+			}
+		}
+		if len(IDs) < batchSize {
+			return purged, nil
+		}
+	}
+}
+
+func (ps *DBStorage) purgeDeletedBatch(ctx context.Context, before time.Time, batchSize int) ([]int64, error) {
+	queryCtx, end := startQuery(ctx, "purge_deleted_users")
+	defer end()
+	rows, err := ps.db.Query(queryCtx,
+		`DELETE FROM users WHERE id IN (
+			SELECT id FROM users WHERE status = $1 AND status_changed_at < $2
+				AND NOT EXISTS (SELECT 1 FROM users v WHERE v.merged_into = users.id)
+			LIMIT $3)
+		RETURNING id`,
+		entity.UserStatusDeleted,
+		before,
+		batchSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var IDs []int64
+	for rows.Next() {
+		var ID int64
+		if err = rows.Scan(&ID); err != nil {
+			return nil, err
+		}
+		IDs = append(IDs, ID)
+	}
+	return IDs, rows.Err()
+}
+
+// ListExpiredBansStorage returns the IDs of banned users whose ban ended
+// before now, the earliest ended first. Merged users are skipped: they can't
+// be updated any more.
+func (ps *DBStorage) ListExpiredBansStorage(ctx context.Context, now time.Time, limit int) ([]int64, error) {
+	queryCtx, end := startQuery(ctx, "list_expired_bans")
+	defer end()
+	rows, err := ps.db.Query(queryCtx,
+		`SELECT id FROM users
+		WHERE status = $1 AND banned_until IS NOT NULL AND banned_until <= $2
+		AND merged_into IS NULL
+		ORDER BY banned_until LIMIT $3`,
+		entity.UserStatusBanned,
+		now,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var IDs []int64
+	for rows.Next() {
+		var ID int64
+		if err = rows.Scan(&ID); err != nil {
+			return nil, err
+		}
+		IDs = append(IDs, ID)
+	}
+	return IDs, rows.Err()
+}
+
+// countUserRead is called in the background for every user read by ID.
+func (ps *DBStorage) countUserRead(ctx context.Context, ID int64) {
+	conn := ps.redisPool.Get()
+	defer conn.Close()
+
+	_, err := redisDo(ctx, conn, "ZINCRBY", userReadsKey, 1, ID)
+	if err != nil {
+		fmt.Printf("Error counting user read: %s\n", err)
+	}
+}
+
+// WarmCacheStorage puts the limit most read users that are not cached
+// into the cache and returns how many were added.
+func (ps *DBStorage) WarmCacheStorage(ctx context.Context, limit int) (int, error) {
+	conn := ps.redisPool.Get()
+	defer conn.Close()
+
+	IDs, err := redis.Int64s(redisDo(ctx, conn, "ZREVRANGE", userReadsKey, 0, limit-1))
+	if err != nil {
+		return 0, err
+	}
+	if len(IDs) == 0 {
+		return 0, nil
+	}
+	args := redis.Args{}.Add("users").AddFlat(IDs)
+	cached, err := redis.Values(redisDo(ctx, conn, "HMGET", args...))
+	if err != nil {
+		return 0, err
+	}
+	var missing []int64
+	for i, value := range cached {
+		if value == nil {
+			missing = append(missing, IDs[i])
+		}
+	}
+
+	users, err := ps.getUsersByIDs(ctx, missing)
+	if err != nil {
+		return 0, err
+	}
+	for _, user := range users {
+		ps.saveUserToRedis(ctx, user)
+	}
+
+	_, err = redisDo(ctx, conn, "ZUNIONSTORE", userReadsKey, 1, userReadsKey, "WEIGHTS", 0.5)
+	if err == nil {
+		_, err = redisDo(ctx, conn, "ZREMRANGEBYSCORE", userReadsKey, "-inf", "(1")
+	}
+	if err != nil {
+		return len(users), fmt.Errorf("error in decaying read counts: %w", err)
+	}
+	return len(users), nil
+}
+
+func (ps *DBStorage) getUsersByIDs(ctx context.Context, IDs []int64) ([]entity.User, error) {
+	if len(IDs) == 0 {
+		return nil, nil
+	}
+	queryCtx, end := startQuery(ctx, "get_users_by_ids")
+	defer end()
+	rows, err := ps.db.Query(queryCtx,
+		`SELECT `+userColumns+`
+		FROM users WHERE id = ANY($1) AND merged_into IS NULL`,
+		IDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []entity.User
+	for rows.Next() {
+		user := dto.UserDB{}
+		err = scanUser(rows, &user)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user.ConvertToUser())
+	}
+	return users, rows.Err()
+}
+
+// RunExclusiveStorage runs fn for the run of task name planned at
+// scheduledAt, unless another instance is running the task or has already
+// done that run. It reports whether fn was run. The task holds a session
+// advisory lock on its own connection for the whole run.
+func (ps *DBStorage) RunExclusiveStorage(ctx context.Context, name string, scheduledAt time.Time, fn func(ctx context.Context) error) (bool, error) {
+	conn, err := ps.db.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Release()
+
+	key := advisoryLockKey(name)
+	var locked bool
+	err = conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked)
+	if err != nil || !locked {
+		return false, err
+	}
+	defer func() {
+		_, err := conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", key)
+		if err != nil {
+			// The lock belongs to the session, closing the connection is
+			// the only other way to release it.
+			log.Printf("error in releasing lock of task %s, closing the connection: %s", name, err)
+			conn.Conn().Close(context.WithoutCancel(ctx))
+		}
+	}()
+
+	var lastRunAt time.Time
+	err = conn.QueryRow(ctx, "SELECT last_run_at FROM scheduled_tasks WHERE name = $1", name).Scan(&lastRunAt)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return false, err
+	}
+	if !lastRunAt.Before(scheduledAt) {
+		return false, nil
+	}
+
+	if err = fn(ctx); err != nil {
+		return true, err
+	}
+	_, err = conn.Exec(ctx,
+		`INSERT INTO scheduled_tasks (name, last_run_at) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET last_run_at = EXCLUDED.last_run_at`,
+		name,
+		scheduledAt,
+	)
+	return true, err
+}
+
+func advisoryLockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("user-api:" + name))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/dto"
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/jackc/pgx/v5"
+)
+
+type MergeFunc func(survivor, victim entity.User) (entity.User, []byte, error)
+
+type MergeStorage interface {
+	MergeUsersStorage(ctx context.Context, survivorID, victimID int64, merge MergeFunc) (*entity.User, error)
+}
+
+// MergeUsersStorage locks both users and lets merge build the surviving
+// record and the history details from them. The survivor is then updated,
+// the victim and everything already merged into it are pointed at the
+// survivor and the merge is written to the history of both users, all in
+// one transaction. If either user doesn't exist nil is returned.
+func (ps *DBStorage) MergeUsersStorage(ctx context.Context, survivorID, victimID int64, merge MergeFunc) (*entity.User, error) {
+	queryCtx, end := startQuery(ctx, "merge_users")
+	defer end()
+	tx, err := ps.db.Begin(queryCtx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = tx.Rollback(ctx)
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Printf("error in rolling back merge: %s", err)
+		}
+	}()
+
+	users, err := getUsersForUpdate(queryCtx, tx, survivorID, victimID)
+	if err != nil {
+		return nil, err
+	}
+	survivor, survivorFound := users[survivorID]
+	victim, victimFound := users[victimID]
+	if !survivorFound || !victimFound {
+		return nil, nil
+	}
+
+	merged, details, err := merge(survivor, victim)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	batch := &pgx.Batch{}
+	batch.Queue(
+		`UPDATE users SET
+		"surname" = $1,
+		"name" = $2,
+		"patronymic" = $3,
+		"gender" = $4,
+		"status" = $5,
+		"birthday" = $6,
+		"join_date" = $7,
+		"status_changed_at" = $8,
+		"banned_until" = $9,
+		"ban_reason" = $10
+		WHERE id = $11`,
+		merged.Surname,
+		merged.Name,
+		getNullOrStr(merged.Patronymic),
+		merged.Gender,
+		merged.Status,
+		getNullOrTime(merged.Birthday),
+		merged.JoinDate,
+		merged.StatusChangedAt,
+		getNullOrTime(merged.BannedUntil),
+		getNullOrStr(merged.BanReason),
+		survivorID,
+	)
+	batch.Queue(
+		"UPDATE users SET merged_into = $1 WHERE id = $2 OR merged_into = $2",
+		survivorID,
+		victimID,
+	)
+	for _, event := range []struct {
+		userID int64
+		name   string
+	}{
+		{survivorID, "merged"},
+		{victimID, "merged_into"},
+	} {
+		batch.Queue(
+			"INSERT INTO user_history (user_id, event, details, created_at) VALUES ($1, $2, $3::jsonb, $4)",
+			event.userID,
+			event.name,
+			string(details),
+			now,
+		)
+	}
+	if err = tx.SendBatch(queryCtx, batch).Close(); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(queryCtx); err != nil {
+		return nil, err
+	}
+
+	ps.goBackground(ctx, func(ctx context.Context) { ps.saveUserToRedis(ctx, merged) })
+	ps.goBackground(ctx, func(ctx context.Context) { ps.deleteUserFromRedis(ctx, victimID) })
+	return &merged, nil
+}
+
+// getUsersForUpdate locks the rows in id order, so that concurrent merges
+// of the same pair can't deadlock.
+func getUsersForUpdate(ctx context.Context, tx pgx.Tx, firstID, secondID int64) (map[int64]entity.User, error) {
+	users := make(map[int64]entity.User, 2)
+	rows, err := tx.Query(ctx,
+		`SELECT `+userColumns+` FROM users
+		WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`,
+		firstID,
+		secondID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user dto.UserDB
+		err = scanUser(rows, &user)
+		if err != nil {
+			return nil, err
+		}
+		users[user.ID] = user.ConvertToUser()
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/ivanov-nikolay/user-api/internal/metrics"
+	"github.com/ivanov-nikolay/user-api/internal/tracing"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startQuery starts the span of a storage method. The returned function
+// ends it and records the method latency. Every statement the method sends
+// gets a child span from StatementTracer.
+func startQuery(ctx context.Context, name string) (context.Context, func()) {
+	start := time.Now()
+	ctx, span := tracing.Tracer().Start(ctx, "postgres "+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			attribute.String("db.operation.name", name),
+		),
+	)
+	return ctx, func() {
+		metrics.ObserveQuery(name, start)
+		span.End()
+	}
+}
+
+// StatementTracer is the tracer of the pgx connections. It starts a span
+// for every statement, batch and COPY under the span of the storage method.
+// Statements without a parent span, like the pings of the health checks,
+// are not traced.
+type StatementTracer struct{}
+
+var (
+	_ pgx.QueryTracer    = StatementTracer{}
+	_ pgx.BatchTracer    = StatementTracer{}
+	_ pgx.CopyFromTracer = StatementTracer{}
+)
+
+func (StatementTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	text := statementText(data.SQL)
+	return startStatement(ctx, statementOperation(text), semconv.DBQueryText(text))
+}
+
+func (StatementTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	endStatement(trace.SpanFromContext(ctx), data.Err)
+}
+
+// batchStatements carries the end of the previous statement of a batch,
+// the results of a batch are read one after another.
+type batchStatements struct {
+	traced   bool
+	previous time.Time
+}
+
+type batchStatementsKey struct{}
+
+func (StatementTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	statements := &batchStatements{traced: trace.SpanFromContext(ctx).SpanContext().IsValid()}
+	ctx = startStatement(ctx, "batch", attribute.Int("db.operation.batch.size", data.Batch.Len()))
+	statements.previous = time.Now()
+	return context.WithValue(ctx, batchStatementsKey{}, statements)
+}
+
+func (StatementTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	statements, ok := ctx.Value(batchStatementsKey{}).(*batchStatements)
+	if !ok || !statements.traced {
+		return
+	}
+	text := statementText(data.SQL)
+	_, span := tracing.Tracer().Start(ctx, "postgres "+statementOperation(text),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(statements.previous),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperationName(statementOperation(text)), semconv.DBQueryText(text)),
+	)
+	statements.previous = time.Now()
+	endStatement(span, data.Err)
+}
+
+func (StatementTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	endStatement(trace.SpanFromContext(ctx), data.Err)
+}
+
+func (StatementTracer) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	return startStatement(ctx, "COPY", semconv.DBCollectionName(data.TableName.Sanitize()))
+}
+
+func (StatementTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	endStatement(trace.SpanFromContext(ctx), data.Err)
+}
+
+func startStatement(ctx context.Context, operation string, attrs ...attribute.KeyValue) context.Context {
+	if !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		return ctx
+	}
+	ctx, _ = tracing.Tracer().Start(ctx, "postgres "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, semconv.DBSystemPostgreSQL, semconv.DBOperationName(operation))...),
+	)
+	return ctx
+}
+
+// endStatement ends the span of a statement. A context without a parent
+// span got no statement span, ending its span does nothing.
+func endStatement(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// statementText resolves the name of a prepared statement to its text.
+func statementText(sql string) string {
+	if text, ok := preparedStatements[sql]; ok {
+		return text
+	}
+	return sql
+}
+
+// statementOperation is the first word of the statement, for example
+// SELECT or BEGIN.
+func statementOperation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	return strings.ToUpper(fields[0])
+}
+
+func redisDo(ctx context.Context, conn redis.Conn, command string, args ...interface{}) (interface{}, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "redis "+command,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemRedis,
+			attribute.String("db.operation.name", command),
+		),
+	)
+	defer span.End()
+
+	reply, err := redis.DoContext(conn, ctx, command, args...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return reply, err
+}
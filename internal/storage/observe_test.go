@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func spanNames(spans []sdktrace.ReadOnlySpan) []string {
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	return names
+}
+
+func TestStatementTracer(t *testing.T) {
+	recorder := recordSpans(t)
+	tracer := StatementTracer{}
+
+	ctx, end := startQuery(context.Background(), "update_user")
+	for _, sql := range []string{"begin", getUserStmt, "commit"} {
+		queryCtx := tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: sql})
+		tracer.TraceQueryEnd(queryCtx, nil, pgx.TraceQueryEndData{})
+	}
+	copyCtx := tracer.TraceCopyFromStart(ctx, nil, pgx.TraceCopyFromStartData{TableName: pgx.Identifier{"users"}})
+	tracer.TraceCopyFromEnd(copyCtx, nil, pgx.TraceCopyFromEndData{Err: errors.New("copy failed")})
+	end()
+
+	spans := recorder.Ended()
+	want := []string{"postgres BEGIN", "postgres SELECT", "postgres COMMIT", "postgres COPY", "postgres update_user"}
+	if got := spanNames(spans); !reflect.DeepEqual(got, want) {
+		t.Fatalf("spans = %v, want %v", got, want)
+	}
+	method := spans[len(spans)-1].SpanContext().SpanID()
+	for _, span := range spans[:len(spans)-1] {
+		if span.Parent().SpanID() != method {
+			t.Errorf("span %q is not a child of the storage method", span.Name())
+		}
+	}
+	if spans[3].Status().Code != codes.Error {
+		t.Errorf("failed COPY has status %v, want error", spans[3].Status().Code)
+	}
+}
+
+func TestStatementTracerBatch(t *testing.T) {
+	recorder := recordSpans(t)
+	tracer := StatementTracer{}
+
+	ctx, end := startQuery(context.Background(), "merge_users")
+	batch := &pgx.Batch{}
+	batch.Queue("UPDATE users SET merged_into = $1 WHERE id = $2", 1, 2)
+	batch.Queue("INSERT INTO user_history (user_id) VALUES ($1)", 1)
+	batchCtx := tracer.TraceBatchStart(ctx, nil, pgx.TraceBatchStartData{Batch: batch})
+	for _, query := range batch.QueuedQueries {
+		tracer.TraceBatchQuery(batchCtx, nil, pgx.TraceBatchQueryData{SQL: query.SQL})
+	}
+	tracer.TraceBatchEnd(batchCtx, nil, pgx.TraceBatchEndData{})
+	end()
+
+	want := []string{"postgres UPDATE", "postgres INSERT", "postgres batch", "postgres merge_users"}
+	if got := spanNames(recorder.Ended()); !reflect.DeepEqual(got, want) {
+		t.Errorf("spans = %v, want %v", got, want)
+	}
+}
+
+func TestStatementTracerWithoutParent(t *testing.T) {
+	recorder := recordSpans(t)
+	tracer := StatementTracer{}
+
+	ctx := tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: "-- ping"})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+	if spans := recorder.Ended(); len(spans) != 0 {
+		t.Errorf("spans = %v, want none", spanNames(spans))
+	}
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/consistency"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type replicaSet struct {
+	pools   []*pgxpool.Pool
+	healthy []atomic.Bool
+	next    atomic.Uint64
+}
+
+// UseReplicas routes user reads to the replicas in turn, skipping those
+// that failed the last health check. The checks run every checkInterval
+// until ctx is done.
+func (ps *DBStorage) UseReplicas(ctx context.Context, pools []*pgxpool.Pool, checkInterval time.Duration) {
+	if len(pools) == 0 {
+		return
+	}
+	rs := &replicaSet{
+		pools:   pools,
+		healthy: make([]atomic.Bool, len(pools)),
+	}
+	rs.check(ctx, checkInterval)
+	ps.replicas = rs
+
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				rs.check(ctx, checkInterval)
+			}
+		}
+	}()
+}
+
+func (rs *replicaSet) check(ctx context.Context, timeout time.Duration) {
+	for i, pool := range rs.pools {
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		rs.healthy[i].Store(pool.Ping(pingCtx) == nil)
+		cancel()
+	}
+}
+
+func (rs *replicaSet) pick() *pgxpool.Pool {
+	start := rs.next.Add(1)
+	for i := range rs.pools {
+		n := int((start + uint64(i)) % uint64(len(rs.pools)))
+		if rs.healthy[n].Load() {
+			return rs.pools[n]
+		}
+	}
+	return nil
+}
+
+// reader returns the pool for a read that may see slightly stale data: a
+// healthy replica unless ctx asks for strong consistency, the primary
+// otherwise.
+func (ps *DBStorage) reader(ctx context.Context) *pgxpool.Pool {
+	if ps.replicas == nil || consistency.IsStrong(ctx) {
+		return ps.db
+	}
+	if pool := ps.replicas.pick(); pool != nil {
+		return pool
+	}
+	return ps.db
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Hot statements with a fixed text are prepared by name on every new
+// connection and executed by that name. Queries built at runtime, like
+// search, are prepared and cached by pgx on first use.
+const (
+	getUserStmt      = "get_user"
+	updateUserStmt   = "update_user"
+	deleteUserStmt   = "delete_user"
+	useAPIKeyStmt    = "use_api_key"
+	createAPIKeyStmt = "create_api_key"
+)
+
+var preparedStatements = map[string]string{
+	getUserStmt: "SELECT " + userColumns + " FROM users WHERE id = $1",
+	updateUserStmt: `UPDATE users SET 
+		"surname" = $1,
+		"name" = $2,
+		"patronymic" = $3,
+		"gender" = $4,
+		"status" = $5,
+		"birthday" = $6,
+		"status_changed_at" = $8,
+		"banned_until" = $9,
+		"ban_reason" = $10,
+		"attributes" = $11
+		WHERE id = $7 AND merged_into IS NULL`,
+	deleteUserStmt: "DELETE FROM users WHERE id = $1",
+	// last_used_at is written at most once a minute, so a busy key doesn't
+	// cost a row update per request. The key is returned either way.
+	useAPIKeyStmt: `WITH active AS (
+			SELECT ` + apiKeyColumns + ` FROM api_keys
+			WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > $2)
+		), touched AS (
+			UPDATE api_keys SET last_used_at = $2
+			WHERE id IN (SELECT id FROM active) AND (last_used_at IS NULL OR last_used_at < $2 - interval '1 minute')
+		)
+		SELECT ` + apiKeyColumns + ` FROM active`,
+	createAPIKeyStmt: `INSERT INTO api_keys (name, key_hash, prefix, scopes, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+}
+
+// PrepareStatements is meant to be the AfterConnect hook of the pool.
+func PrepareStatements(ctx context.Context, conn *pgx.Conn) error {
+	for name, sql := range preparedStatements {
+		if _, err := conn.Prepare(ctx, name, sql); err != nil {
+			return err
+		}
+	}
+	return nil
+}
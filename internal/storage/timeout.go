@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ivanov-nikolay/user-api/internal/querytimeout"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrQueryTimeout is returned when Postgres cancels a query because it ran
+// longer than the statement_timeout of its context.
+var ErrQueryTimeout = errors.New("query exceeded its time budget")
+
+const queryCanceledCode = "57014"
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// readWithTimeout runs fn on db, inside a read-only transaction with SET
+// LOCAL statement_timeout when ctx carries a timeout.
+func readWithTimeout(ctx context.Context, db *pgxpool.Pool, fn func(q querier) error) error {
+	timeout, ok := querytimeout.FromContext(ctx)
+	if !ok {
+		return fn(db)
+	}
+
+	tx, err := db.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	_, err = tx.Exec(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout.Milliseconds()))
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == queryCanceledCode {
+			return fmt.Errorf("%w: %s", ErrQueryTimeout, pgErr.Message)
+		}
+		return err
+	}
+	return tx.Commit(ctx)
+}
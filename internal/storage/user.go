@@ -1,46 +1,88 @@
 package storage
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/ivanov-nikolay/user-api/internal/consistency"
 	"github.com/ivanov-nikolay/user-api/internal/dto"
 	"github.com/ivanov-nikolay/user-api/internal/entity"
 	"github.com/ivanov-nikolay/user-api/internal/filters"
+	"github.com/ivanov-nikolay/user-api/internal/metrics"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Storage interface {
-	CreateUserStorage(user entity.User) (int64, error)
-	DeleteUserStorage(ID int64) (bool, error)
-	UpdateUserStorage(user entity.User) (bool, error)
-	GetUserByIDStorage(ID int64) (*entity.User, error)
-	SearchUsersStorage(filters filters.Filter) ([]entity.User, error)
+	CreateUserStorage(ctx context.Context, user entity.User) (int64, error)
+	DeleteUserStorage(ctx context.Context, ID int64) (bool, error)
+	UpdateUserStorage(ctx context.Context, ID int64, update UpdateFunc) (*entity.User, error)
+	GetUserByIDStorage(ctx context.Context, ID int64) (*entity.User, error)
+	SearchUsersStorage(ctx context.Context, filters filters.Filter) ([]entity.User, error)
+	ExportUsersStorage(ctx context.Context, filter filters.Filter, fn func(user entity.User) error) error
+	CreateUsersStorage(ctx context.Context, users []entity.User) (int64, error)
+	DuplicateStorage
+	MergeStorage
+	JobStorage
+	MaintenanceStorage
+	AttributeStorage
 }
 
 type DBStorage struct {
-	db         *sql.DB
-	redisConn  redis.Conn
+	db         *pgxpool.Pool
+	replicas   *replicaSet
+	redisPool  *redis.Pool
 	expireTime int
+	background sync.WaitGroup
 }
 
-func New(db *sql.DB, redisConn redis.Conn) *DBStorage {
+func New(db *pgxpool.Pool, redisPool *redis.Pool) *DBStorage {
 	return &DBStorage{
 		db:         db,
-		redisConn:  redisConn,
+		redisPool:  redisPool,
 		expireTime: 24 * 60 * 2,
 	}
 }
 
-func (ps *DBStorage) CreateUserStorage(user entity.User) (int64, error) {
+// goBackground runs cache updates without holding up the request. Shutdown
+// waits for them, so they aren't lost when the service stops.
+func (ps *DBStorage) goBackground(ctx context.Context, f func(ctx context.Context)) {
+	ctx = context.WithoutCancel(ctx)
+	ps.background.Add(1)
+	go func() {
+		defer ps.background.Done()
+		f(ctx)
+	}()
+}
+
+func (ps *DBStorage) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		ps.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (ps *DBStorage) CreateUserStorage(ctx context.Context, user entity.User) (int64, error) {
+	queryCtx, end := startQuery(ctx, "create_user")
+	defer end()
 	var lastInsertId int64
-	query := "INSERT INTO users (surname, name, gender, status, join_date"
-	values := []interface{}{user.Surname, user.Name, user.Gender, user.Status, user.JoinDate}
+	query := "INSERT INTO users (surname, name, gender, status, join_date, status_changed_at, attributes"
+	values := []interface{}{user.Surname, user.Name, user.Gender, user.Status, user.JoinDate, user.StatusChangedAt, attributesOrEmpty(user.Attributes)}
 
 	if user.Patronymic != "" {
 		query += ", patronymic"
@@ -61,65 +103,102 @@ func (ps *DBStorage) CreateUserStorage(user entity.User) (int64, error) {
 	}
 	query += ") RETURNING id"
 
-	err := ps.db.QueryRow(query, values...).Scan(&lastInsertId)
+	err := ps.db.QueryRow(queryCtx, query, values...).Scan(&lastInsertId)
 	if err != nil {
-		return 0, err
+		return 0, attributeError(err)
 	}
 	user.ID = lastInsertId
-	go ps.saveUserToRedis(user)
+	ps.goBackground(ctx, func(ctx context.Context) { ps.saveUserToRedis(ctx, user) })
 	return lastInsertId, nil
 
 }
 
-func (ps *DBStorage) DeleteUserStorage(ID int64) (bool, error) {
-	result, err := ps.db.Exec(
-		"DELETE FROM users WHERE id = $1",
-		ID,
-	)
-	if err != nil {
-		return false, err
-	}
-	num, err := result.RowsAffected()
+func (ps *DBStorage) DeleteUserStorage(ctx context.Context, ID int64) (bool, error) {
+	queryCtx, end := startQuery(ctx, "delete_user")
+	defer end()
+	result, err := ps.db.Exec(queryCtx, deleteUserStmt, ID)
 	if err != nil {
 		return false, err
 	}
-	if num > 0 {
-		go ps.deleteUserFromRedis(ID)
+	if result.RowsAffected() > 0 {
+		ps.goBackground(ctx, func(ctx context.Context) { ps.deleteUserFromRedis(ctx, ID) })
 		return true, nil
 	}
 	return false, nil
 }
 
-func (ps *DBStorage) UpdateUserStorage(user entity.User) (bool, error) {
-	res, err := ps.db.Exec(
-		`UPDATE users SET 
-		"surname" = $1,
-		"name" = $2,
-		"patronymic" = $3,
-		"gender" = $4,
-		"status" = $5,
-		"birthday" = $6
-		WHERE id = $7`,
+// UpdateFunc builds the new record of a locked user. details are written
+// to the history of the user as a status change, nil details write nothing.
+type UpdateFunc func(current entity.User) (entity.User, []byte, error)
+
+// UpdateUserStorage locks the user and lets update build the new record
+// from the current one, so that checks of the current status can't race
+// with another update. If the user doesn't exist or was merged into another
+// one nil is returned.
+func (ps *DBStorage) UpdateUserStorage(ctx context.Context, ID int64, update UpdateFunc) (*entity.User, error) {
+	queryCtx, end := startQuery(ctx, "update_user")
+	defer end()
+	tx, err := ps.db.Begin(queryCtx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = tx.Rollback(ctx)
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Printf("error in rolling back update: %s", err)
+		}
+	}()
+
+	current := &dto.UserDB{}
+	err = scanUser(tx.QueryRow(queryCtx, "SELECT "+userColumns+" FROM users WHERE id = $1 FOR UPDATE", ID), current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if current.MergedInto != nil {
+		return nil, nil
+	}
+
+	user, details, err := update(current.ConvertToUser())
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(queryCtx, updateUserStmt,
 		user.Surname,
 		user.Name,
 		getNullOrStr(user.Patronymic),
 		user.Gender,
 		user.Status,
 		getNullOrTime(user.Birthday),
-		user.ID,
+		ID,
+		user.StatusChangedAt,
+		getNullOrTime(user.BannedUntil),
+		getNullOrStr(user.BanReason),
+		attributesOrEmpty(user.Attributes),
 	)
 	if err != nil {
-		return false, err
+		return nil, attributeError(err)
 	}
-	num, err := res.RowsAffected()
-	if err != nil {
-		return false, err
+	if details != nil {
+		_, err = tx.Exec(queryCtx,
+			"INSERT INTO user_history (user_id, event, details, created_at) VALUES ($1, $2, $3::jsonb, $4)",
+			ID,
+			"status_changed",
+			string(details),
+			user.StatusChangedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
 	}
-	if num > 0 {
-		go ps.saveUserToRedis(user)
-		return true, nil
+
+	if err = tx.Commit(queryCtx); err != nil {
+		return nil, err
 	}
-	return false, nil
+	ps.goBackground(ctx, func(ctx context.Context) { ps.saveUserToRedis(ctx, user) })
+	return &user, nil
 }
 
 func getNullOrTime(tm time.Time) interface{} {
@@ -129,6 +208,15 @@ func getNullOrTime(tm time.Time) interface{} {
 	return tm
 }
 
+// attributesOrEmpty keeps a user without attributes from being stored as
+// a JSON null.
+func attributesOrEmpty(attributes map[string]interface{}) map[string]interface{} {
+	if attributes == nil {
+		return map[string]interface{}{}
+	}
+	return attributes
+}
+
 func getNullOrStr(str string) interface{} {
 	if str == "" {
 		return nil
@@ -136,29 +224,79 @@ func getNullOrStr(str string) interface{} {
 	return str
 }
 
-func (ps *DBStorage) GetUserByIDStorage(ID int64) (*entity.User, error) {
-	userRD, err := ps.getUserFromRedis(ID)
-	if err == nil || userRD != nil {
-		fmt.Println("User got from redis")
-		return userRD, nil
+// GetUserByIDStorage reads the user from the cache, then from the database.
+// Strong reads skip the cache: it is refreshed in the background after a
+// write and may still hold the old record.
+func (ps *DBStorage) GetUserByIDStorage(ctx context.Context, ID int64) (*entity.User, error) {
+	if !consistency.IsStrong(ctx) {
+		userRD, err := ps.getUserFromRedis(ctx, ID)
+		switch {
+		case err == nil:
+			metrics.CacheRequests.WithLabelValues(metrics.CacheHit).Inc()
+			ps.goBackground(ctx, func(ctx context.Context) { ps.countUserRead(ctx, ID) })
+			return userRD, nil
+		case errors.Is(err, redis.ErrNil):
+			metrics.CacheRequests.WithLabelValues(metrics.CacheMiss).Inc()
+		default:
+			metrics.CacheRequests.WithLabelValues(metrics.CacheError).Inc()
+		}
 	}
+
+	queryCtx, end := startQuery(ctx, "get_user")
+	defer end()
 	user := &dto.UserDB{}
-	err = ps.db.
-		QueryRow(`SELECT id, name, surname, patronymic, gender, status, birthday, join_date FROM users WHERE id = $1`, ID).
-		Scan(&user.ID, &user.Name, &user.Surname, &user.Patronymic, &user.Gender, &user.Status, &user.Birthday, &user.JoinDate)
+	err := scanUser(ps.reader(ctx).QueryRow(queryCtx, getUserStmt, ID), user)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
 	convertedUser := user.ConvertToUser()
+	ps.goBackground(ctx, func(ctx context.Context) { ps.countUserRead(ctx, ID) })
 	return &convertedUser, nil
 }
 
-func (ps *DBStorage) SearchUsersStorage(filter filters.Filter) ([]entity.User, error) {
-	query := "SELECT id, name, surname, patronymic, gender, status, birthday, join_date FROM users WHERE 1=1"
-	var values []interface{}
+const userColumns = "id, name, surname, patronymic, gender, status, birthday, join_date, merged_into, status_changed_at, banned_until, ban_reason, attributes"
+
+// scanUser reads a row selected with userColumns.
+func scanUser(row pgx.Row, user *dto.UserDB) error {
+	return row.Scan(&user.ID, &user.Name, &user.Surname, &user.Patronymic, &user.Gender, &user.Status, &user.Birthday,
+		&user.JoinDate, &user.MergedInto, &user.StatusChangedAt, &user.BannedUntil, &user.BanReason, &user.Attributes)
+}
+
+func (ps *DBStorage) SearchUsersStorage(ctx context.Context, filter filters.Filter) ([]entity.User, error) {
+	queryCtx, end := startQuery(ctx, "search_users")
+	defer end()
+	query, values := buildSearchQuery(filter)
+
+	var users []entity.User
+	err := readWithTimeout(queryCtx, ps.reader(ctx), func(q querier) error {
+		rows, err := q.Query(queryCtx, query, values...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var user dto.UserDB
+			err = scanUser(rows, &user)
+			if err != nil {
+				return err
+			}
+			userConverted := user.ConvertToUser()
+			users = append(users, userConverted)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func buildSearchQuery(filter filters.Filter) (query string, values []interface{}) {
+	query = "SELECT " + userColumns + " FROM users WHERE merged_into IS NULL"
 
 	if filter.Gender != "" {
 		query += " AND gender = $" + strconv.Itoa(len(values)+1)
@@ -175,8 +313,20 @@ func (ps *DBStorage) SearchUsersStorage(filter filters.Filter) ([]entity.User, e
 		values = append(values, "%"+filter.FullName+"%")
 	}
 
-	if filter.AttributesToSort != "" {
-		query += " ORDER BY " + filter.AttributesToSort
+	for _, attr := range filter.Attributes {
+		if !validAttributeName(attr.Name) {
+			// No user has an attribute with such a name.
+			query += " AND FALSE"
+			continue
+		}
+		// Containment compares values by type and can use the GIN index.
+		condition, _ := json.Marshal(map[string]interface{}{attr.Name: attr.Value})
+		query += " AND attributes @> $" + strconv.Itoa(len(values)+1) + "::jsonb"
+		values = append(values, string(condition))
+	}
+
+	if expression, ok := sortExpression(filter); ok {
+		query += " ORDER BY " + expression
 		if filter.SortDesc {
 			query += " DESC"
 		} else if filter.SortAsk {
@@ -192,46 +342,109 @@ func (ps *DBStorage) SearchUsersStorage(filter filters.Filter) ([]entity.User, e
 		query += " OFFSET $" + strconv.Itoa(len(values)+1)
 		values = append(values, filter.Offset)
 	}
+	return query, values
+}
 
-	rows, err := ps.db.Query(query, values...)
-	if err != nil {
-		return nil, err
+// sortExpression returns the column to sort by or, for an attribute, its
+// value cast to the attribute type. Dates in 2006-01-02 sort as text. It
+// reports false when the search isn't sorted or is sorted by something
+// that is neither in filters.SortColumns nor a valid attribute name, the
+// sort param never reaches the query unchecked.
+func sortExpression(filter filters.Filter) (string, bool) {
+	if filter.AttributesToSort == "" {
+		return "", false
 	}
-	defer func() {
-		err = rows.Close()
-		if err != nil {
-			log.Printf("error in closing db rows: %s", err)
-		}
-	}()
+	name, ok := strings.CutPrefix(filter.AttributesToSort, filters.AttributePrefix)
+	if !ok {
+		column, ok := filters.SortColumns[filter.AttributesToSort]
+		return column, ok
+	}
+	if !validAttributeName(name) {
+		return "", false
+	}
+	switch filter.SortAttributeType {
+	case entity.AttributeTypeInteger, entity.AttributeTypeNumber:
+		return attributeValue(name) + "::numeric", true
+	case entity.AttributeTypeBoolean:
+		return attributeValue(name) + "::boolean", true
+	}
+	return attributeValue(name), true
+}
 
-	var users []entity.User
-	for rows.Next() {
-		var user dto.UserDB
-		err = rows.Scan(&user.ID, &user.Name, &user.Surname, &user.Patronymic, &user.Gender, &user.Status, &user.Birthday, &user.JoinDate)
+// ExportUsersStorage calls fn for every user matching filter while reading
+// them from the cursor, so the result is never held in memory. An error
+// from fn stops the export and is returned. The query runs with the
+// statement_timeout of ctx like search does.
+func (ps *DBStorage) ExportUsersStorage(ctx context.Context, filter filters.Filter, fn func(user entity.User) error) error {
+	queryCtx, end := startQuery(ctx, "export_users")
+	defer end()
+	query, values := buildSearchQuery(filter)
+
+	return readWithTimeout(queryCtx, ps.reader(ctx), func(q querier) error {
+		rows, err := q.Query(queryCtx, query, values...)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		userConverted := user.ConvertToUser()
-		users = append(users, userConverted)
-	}
+		defer rows.Close()
+
+		for rows.Next() {
+			var user dto.UserDB
+			err = scanUser(rows, &user)
+			if err != nil {
+				return err
+			}
+			if err = fn(user.ConvertToUser()); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
 
-	return users, nil
+var userCopyColumns = []string{"surname", "name", "patronymic", "gender", "status", "birthday", "join_date", "status_changed_at", "attributes"}
+
+func userCopySource(users []entity.User) pgx.CopyFromSource {
+	return pgx.CopyFromSlice(len(users), func(i int) ([]interface{}, error) {
+		user := users[i]
+		return []interface{}{
+			user.Surname,
+			user.Name,
+			getNullOrStr(user.Patronymic),
+			user.Gender,
+			user.Status,
+			getNullOrTime(user.Birthday),
+			user.JoinDate,
+			user.StatusChangedAt,
+			attributesOrEmpty(user.Attributes),
+		}, nil
+	})
 }
 
-func (ps *DBStorage) saveUserToRedis(user entity.User) {
+// CreateUsersStorage inserts users with COPY and returns how many were
+// inserted. The cache is not filled, users get there on first read.
+func (ps *DBStorage) CreateUsersStorage(ctx context.Context, users []entity.User) (int64, error) {
+	queryCtx, end := startQuery(ctx, "copy_users")
+	defer end()
+	return ps.db.CopyFrom(queryCtx, pgx.Identifier{"users"}, userCopyColumns, userCopySource(users))
+}
+
+func (ps *DBStorage) saveUserToRedis(ctx context.Context, user entity.User) {
 	userJSON, err := json.Marshal(user)
 	if err != nil {
 		fmt.Printf("Error marshalling user: %s\n", err)
 		return
 	}
 
-	_, err = ps.redisConn.Do("HSET", "users", user.ID, userJSON)
+	conn := ps.redisPool.Get()
+	defer conn.Close()
+
+	_, err = redisDo(ctx, conn, "HSET", "users", user.ID, userJSON)
 	if err != nil {
 		fmt.Printf("Error saving user to Redis: %s\n", err)
 		return
 	}
 
-	_, err = ps.redisConn.Do("EXPIRE", "users", ps.expireTime)
+	_, err = redisDo(ctx, conn, "EXPIRE", "users", ps.expireTime)
 	if err != nil {
 		fmt.Printf("Error setting expire time for users hashset: %s\n", err)
 	}
@@ -239,10 +452,13 @@ func (ps *DBStorage) saveUserToRedis(user entity.User) {
 	fmt.Println("User added to Redis hashset")
 }
 
-func (ps *DBStorage) getUserFromRedis(userID int64) (*entity.User, error) {
-	userJSON, err := redis.Bytes(ps.redisConn.Do("HGET", "users", userID))
+func (ps *DBStorage) getUserFromRedis(ctx context.Context, userID int64) (*entity.User, error) {
+	conn := ps.redisPool.Get()
+	defer conn.Close()
+
+	userJSON, err := redis.Bytes(redisDo(ctx, conn, "HGET", "users", userID))
 	if err != nil {
-		return nil, fmt.Errorf("error getting user from Redis: %s", err)
+		return nil, fmt.Errorf("error getting user from Redis: %w", err)
 	}
 
 	var user entity.User
@@ -254,8 +470,11 @@ func (ps *DBStorage) getUserFromRedis(userID int64) (*entity.User, error) {
 	return &user, nil
 }
 
-func (ps *DBStorage) deleteUserFromRedis(userID int64) error {
-	_, err := ps.redisConn.Do("HDEL", "users", userID)
+func (ps *DBStorage) deleteUserFromRedis(ctx context.Context, userID int64) error {
+	conn := ps.redisPool.Get()
+	defer conn.Close()
+
+	_, err := redisDo(ctx, conn, "HDEL", "users", userID)
 	if err != nil {
 		return fmt.Errorf("error deleting user from Redis: %s", err)
 	}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/filters"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The benchmarks need a database with the schema of _postgres/db.sql in
+// BENCH_POSTGRES_DSN, they are skipped without it. BENCH_REDIS_ADDR is the
+// cache, localhost:6379 by default. The users they insert have the surname
+// benchUserSurname and are removed afterwards.
+const benchUserSurname = "Benchmark"
+
+func newBenchStorage(b *testing.B) *DBStorage {
+	b.Helper()
+	dsn := os.Getenv("BENCH_POSTGRES_DSN")
+	if dsn == "" {
+		b.Skip("BENCH_POSTGRES_DSN is not set")
+	}
+	redisAddr := os.Getenv("BENCH_REDIS_ADDR")
+	if redisAddr == "" {
+		redisAddr = "localhost:6379"
+	}
+
+	ctx := context.Background()
+	db, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		b.Fatalf("connecting to postgres: %s", err)
+	}
+	redisPool := &redis.Pool{
+		MaxIdle: 10,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redisAddr)
+		},
+	}
+	s := New(db, redisPool)
+	b.Cleanup(func() {
+		if err := s.Shutdown(ctx); err != nil {
+			b.Errorf("waiting for cache updates: %s", err)
+		}
+		_, err := db.Exec(ctx, "DELETE FROM users WHERE surname = $1", benchUserSurname)
+		if err != nil {
+			b.Errorf("removing benchmark users: %s", err)
+		}
+		db.Close()
+		redisPool.Close()
+	})
+	return s
+}
+
+func benchUsers(n int) []entity.User {
+	now := time.Now()
+	users := make([]entity.User, n)
+	for i := range users {
+		users[i] = entity.User{
+			Name:            "User" + strconv.Itoa(i),
+			Surname:         benchUserSurname,
+			Gender:          "male",
+			Status:          entity.UserStatusActive,
+			JoinDate:        now,
+			StatusChangedAt: now,
+		}
+	}
+	return users
+}
+
+func BenchmarkCreateUserStorage(b *testing.B) {
+	s := newBenchStorage(b)
+	ctx := context.Background()
+	users := benchUsers(b.N)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.CreateUserStorage(ctx, users[i]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCreateUsersStorage(b *testing.B) {
+	for _, size := range []int{100, 1000} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			s := newBenchStorage(b)
+			ctx := context.Background()
+			users := benchUsers(size)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.CreateUsersStorage(ctx, users); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSearchUsersStorage(b *testing.B) {
+	s := newBenchStorage(b)
+	ctx := context.Background()
+	if _, err := s.CreateUsersStorage(ctx, benchUsers(10000)); err != nil {
+		b.Fatal(err)
+	}
+	filter := filters.Filter{
+		FullName:         benchUserSurname,
+		AttributesToSort: "name",
+		SortAsk:          true,
+		Limit:            50,
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.SearchUsersStorage(ctx, filter); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
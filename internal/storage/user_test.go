@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/filters"
+)
+
+func TestBuildSearchQuerySort(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter filters.Filter
+		order  string
+	}{
+		{"no sort", filters.Filter{}, ""},
+		{"column", filters.Filter{AttributesToSort: "surname", SortDesc: true}, " ORDER BY surname DESC"},
+		{"column without direction", filters.Filter{AttributesToSort: "join_date"}, " ORDER BY join_date"},
+		{"injection without direction", filters.Filter{AttributesToSort: "id; DROP TABLE users"}, ""},
+		{"injection with direction", filters.Filter{AttributesToSort: "(SELECT 1)", SortAsk: true}, ""},
+		{"attribute", filters.Filter{AttributesToSort: "attr.level", SortAttributeType: "integer", SortAsk: true}, " ORDER BY (attributes->>'level')::numeric ASC"},
+		{"attribute injection", filters.Filter{AttributesToSort: "attr.x') DESC, (SELECT pg_sleep(10)) --", SortAsk: true}, ""},
+		{"attribute uppercase", filters.Filter{AttributesToSort: "attr.Level"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := buildSearchQuery(tt.filter)
+			_, order, _ := strings.Cut(query, " ORDER BY ")
+			if order != "" {
+				order = " ORDER BY " + order
+			}
+			if order != tt.order {
+				t.Errorf("ORDER BY part = %q, want %q", order, tt.order)
+			}
+		})
+	}
+}
+
+func TestBuildSearchQueryAttributes(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes []filters.AttributeFilter
+		condition  string
+		values     []interface{}
+	}{
+		{"attribute", []filters.AttributeFilter{{Name: "team", Value: "core"}}, " AND attributes @> $1::jsonb", []interface{}{`{"team":"core"}`}},
+		{"bad name", []filters.AttributeFilter{{Name: "team'--", Value: "core"}}, " AND FALSE", nil},
+		{
+			"bad name among good ones",
+			[]filters.AttributeFilter{{Name: "level", Value: int64(2)}, {Name: "Team", Value: "core"}},
+			" AND attributes @> $1::jsonb AND FALSE",
+			[]interface{}{`{"level":2}`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, values := buildSearchQuery(filters.Filter{Attributes: tt.attributes})
+			_, condition, _ := strings.Cut(query, "merged_into IS NULL")
+			if condition != tt.condition {
+				t.Errorf("conditions = %q, want %q", condition, tt.condition)
+			}
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Errorf("values = %v, want %v", values, tt.values)
+			}
+		})
+	}
+}
+
+func TestShutdownWaitsForBackground(t *testing.T) {
+	ps := &DBStorage{}
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	ps.goBackground(ctx, func(ctx context.Context) {
+		<-release
+		done <- ctx.Err()
+	})
+	cancel()
+
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer shortCancel()
+	if err := ps.Shutdown(shortCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() with a running update = %v, want deadline exceeded", err)
+	}
+
+	close(release)
+	if err := ps.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("background update saw %v, the request context must not cancel it", err)
+	}
+}
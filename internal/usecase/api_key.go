@@ -0,0 +1,96 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/storage"
+	"github.com/ivanov-nikolay/user-api/internal/tracing"
+)
+
+const (
+	apiKeyPrefix      = "uak_"
+	apiKeyRandomBytes = 32
+	apiKeyShownPrefix = 12
+)
+
+type APIKeyUseCase interface {
+	CreateAPIKeyUseCase(ctx context.Context, key entity.APIKey) (*entity.APIKey, string, error)
+	ListAPIKeysUseCase(ctx context.Context) ([]entity.APIKey, error)
+	RevokeAPIKeyUseCase(ctx context.Context, ID int64) (bool, error)
+	AuthenticateAPIKeyUseCase(ctx context.Context, rawKey string) (*entity.APIKey, error)
+}
+
+type APIKeyAppUseCase struct {
+	s storage.APIKeyStorage
+}
+
+func NewAPIKeyUseCase(s storage.APIKeyStorage) *APIKeyAppUseCase {
+	return &APIKeyAppUseCase{s: s}
+}
+
+// CreateAPIKeyUseCase returns the stored key together with its plaintext
+// value. Only the hash is persisted, so the plaintext can't be shown again.
+func (ak *APIKeyAppUseCase) CreateAPIKeyUseCase(ctx context.Context, key entity.APIKey) (*entity.APIKey, string, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "APIKeyAppUseCase.CreateAPIKeyUseCase")
+	defer span.End()
+
+	random := make([]byte, apiKeyRandomBytes)
+	if _, err := rand.Read(random); err != nil {
+		return nil, "", fmt.Errorf("key generation error: %s", err)
+	}
+	rawKey := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(random)
+
+	key.Prefix = rawKey[:apiKeyShownPrefix]
+	key.CreatedAt = time.Now()
+	ID, err := ak.s.CreateAPIKeyStorage(ctx, key, hashAPIKey(rawKey))
+	if err != nil {
+		return nil, "", fmt.Errorf("storage error: %s", err)
+	}
+	key.ID = ID
+	return &key, rawKey, nil
+}
+
+func (ak *APIKeyAppUseCase) ListAPIKeysUseCase(ctx context.Context) ([]entity.APIKey, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "APIKeyAppUseCase.ListAPIKeysUseCase")
+	defer span.End()
+
+	keys, err := ak.s.ListAPIKeysStorage(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %s", err)
+	}
+	return keys, nil
+}
+
+func (ak *APIKeyAppUseCase) RevokeAPIKeyUseCase(ctx context.Context, ID int64) (bool, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "APIKeyAppUseCase.RevokeAPIKeyUseCase")
+	defer span.End()
+
+	wasRevoked, err := ak.s.RevokeAPIKeyStorage(ctx, ID, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("storage error: %s", err)
+	}
+	return wasRevoked, nil
+}
+
+func (ak *APIKeyAppUseCase) AuthenticateAPIKeyUseCase(ctx context.Context, rawKey string) (*entity.APIKey, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "APIKeyAppUseCase.AuthenticateAPIKeyUseCase")
+	defer span.End()
+
+	key, err := ak.s.UseAPIKeyStorage(ctx, hashAPIKey(rawKey), time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %s", err)
+	}
+	return key, nil
+}
+
+func hashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,86 @@
+package usecase
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+// memoryAPIKeys checks keys like UseAPIKeyStorage: by hash, skipping
+// revoked and expired ones.
+type memoryAPIKeys struct {
+	keys   []entity.APIKey
+	hashes []string
+}
+
+func (m *memoryAPIKeys) CreateAPIKeyStorage(ctx context.Context, key entity.APIKey, keyHash string) (int64, error) {
+	key.ID = int64(len(m.keys) + 1)
+	m.keys = append(m.keys, key)
+	m.hashes = append(m.hashes, keyHash)
+	return key.ID, nil
+}
+
+func (m *memoryAPIKeys) ListAPIKeysStorage(ctx context.Context) ([]entity.APIKey, error) {
+	return m.keys, nil
+}
+
+func (m *memoryAPIKeys) RevokeAPIKeyStorage(ctx context.Context, ID int64, revokedAt time.Time) (bool, error) {
+	key := &m.keys[ID-1]
+	if !key.RevokedAt.IsZero() {
+		return false, nil
+	}
+	key.RevokedAt = revokedAt
+	return true, nil
+}
+
+func (m *memoryAPIKeys) UseAPIKeyStorage(ctx context.Context, keyHash string, usedAt time.Time) (*entity.APIKey, error) {
+	for i, hash := range m.hashes {
+		key := m.keys[i]
+		if hash == keyHash && key.RevokedAt.IsZero() && (key.ExpiresAt.IsZero() || key.ExpiresAt.After(usedAt)) {
+			return &key, nil
+		}
+	}
+	return nil, nil
+}
+
+func TestAPIKeyUseCase(t *testing.T) {
+	s := &memoryAPIKeys{}
+	ak := NewAPIKeyUseCase(s)
+	ctx := context.Background()
+
+	key, rawKey, err := ak.CreateAPIKeyUseCase(ctx, entity.APIKey{Name: "billing", Scopes: []string{"users:read"}})
+	if err != nil {
+		t.Fatalf("CreateAPIKeyUseCase() error: %v", err)
+	}
+	if !strings.HasPrefix(rawKey, apiKeyPrefix) || key.Prefix != rawKey[:apiKeyShownPrefix] {
+		t.Errorf("key %q has prefix %q", rawKey, key.Prefix)
+	}
+	if s.hashes[0] == rawKey || strings.Contains(s.hashes[0], rawKey[len(apiKeyPrefix):]) {
+		t.Error("plaintext key is stored")
+	}
+	_, otherKey, err := ak.CreateAPIKeyUseCase(ctx, entity.APIKey{Name: "billing"})
+	if err != nil {
+		t.Fatalf("CreateAPIKeyUseCase() error: %v", err)
+	}
+	if otherKey == rawKey {
+		t.Error("two keys have the same value")
+	}
+
+	found, err := ak.AuthenticateAPIKeyUseCase(ctx, rawKey)
+	if err != nil || found == nil || found.ID != key.ID {
+		t.Fatalf("AuthenticateAPIKeyUseCase() = %+v, %v, want key %d", found, err, key.ID)
+	}
+	if found, _ = ak.AuthenticateAPIKeyUseCase(ctx, rawKey+"x"); found != nil {
+		t.Errorf("wrong key authenticated as %d", found.ID)
+	}
+
+	if revoked, _ := ak.RevokeAPIKeyUseCase(ctx, key.ID); !revoked {
+		t.Error("RevokeAPIKeyUseCase() = false, want true")
+	}
+	if found, _ = ak.AuthenticateAPIKeyUseCase(ctx, rawKey); found != nil {
+		t.Error("revoked key authenticated")
+	}
+}
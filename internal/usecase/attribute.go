@@ -0,0 +1,306 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/filters"
+	"github.com/ivanov-nikolay/user-api/internal/storage"
+	"github.com/ivanov-nikolay/user-api/internal/tracing"
+)
+
+type AttributeUseCase interface {
+	CreateAttributeUseCase(ctx context.Context, attr entity.Attribute) (*entity.Attribute, error)
+	ListAttributesUseCase(ctx context.Context) ([]entity.Attribute, error)
+	DeleteAttributeUseCase(ctx context.Context, name string) (bool, error)
+}
+
+var ErrAttributeExists = errors.New("attribute with this name already exists")
+
+// AttributeError lists the attribute values of a user that don't match the
+// registry.
+type AttributeError struct {
+	Errors []string
+}
+
+func (e *AttributeError) Error() string {
+	return strings.Join(e.Errors, "; ")
+}
+
+// FilterError means a search refers to an attribute that isn't registered
+// or compares it with a value of another type.
+type FilterError struct {
+	Err error
+}
+
+func (e *FilterError) Error() string {
+	return e.Err.Error()
+}
+
+type AttributeAppUseCase struct {
+	s storage.Storage
+}
+
+func NewAttributeUseCase(s storage.Storage) *AttributeAppUseCase {
+	return &AttributeAppUseCase{s: s}
+}
+
+// CreateAttributeUseCase returns ErrAttributeExists if the name is taken.
+// A required attribute is only checked on later writes, existing users
+// get it on their next update.
+func (atu *AttributeAppUseCase) CreateAttributeUseCase(ctx context.Context, attr entity.Attribute) (*entity.Attribute, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "AttributeAppUseCase.CreateAttributeUseCase")
+	defer span.End()
+
+	if attr.Rules.Pattern != "" {
+		if _, err := attributePattern(attr.Rules.Pattern); err != nil {
+			return nil, fmt.Errorf("bad pattern: %s", err)
+		}
+	}
+	attr.CreatedAt = time.Now()
+	created, err := atu.s.CreateAttributeStorage(ctx, attr)
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %s", err)
+	}
+	if !created {
+		return nil, ErrAttributeExists
+	}
+	return &attr, nil
+}
+
+func (atu *AttributeAppUseCase) ListAttributesUseCase(ctx context.Context) ([]entity.Attribute, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "AttributeAppUseCase.ListAttributesUseCase")
+	defer span.End()
+
+	attrs, err := atu.s.ListAttributesStorage(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %s", err)
+	}
+	return attrs, nil
+}
+
+// DeleteAttributeUseCase removes the attribute together with its values.
+func (atu *AttributeAppUseCase) DeleteAttributeUseCase(ctx context.Context, name string) (bool, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "AttributeAppUseCase.DeleteAttributeUseCase")
+	defer span.End()
+
+	deleted, err := atu.s.DeleteAttributeStorage(ctx, name)
+	if err != nil {
+		return false, fmt.Errorf("storage error: %s", err)
+	}
+	return deleted, nil
+}
+
+// checkAttributes validates the values against the registry and returns
+// them without null values. Errors are worded like the ones of the user
+// DTOs.
+func (au *AppUseCase) checkAttributes(ctx context.Context, values map[string]interface{}) (map[string]interface{}, error) {
+	attrs, err := au.s.ListAttributesStorage(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %s", err)
+	}
+	checked, validationErrors := validateAttributes(attrs, values)
+	if len(validationErrors) != 0 {
+		return nil, &AttributeError{Errors: validationErrors}
+	}
+	return checked, nil
+}
+
+// uniqueAttributeOr turns a taken value of a unique attribute into
+// *AttributeError and wraps other storage errors.
+func uniqueAttributeOr(err error) error {
+	var uniqueErr *storage.UniqueAttributeError
+	if errors.As(err, &uniqueErr) {
+		return &AttributeError{Errors: []string{fmt.Sprintf("attributes.%s: value is already taken", uniqueErr.Name)}}
+	}
+	return fmt.Errorf("storage error: %s", err)
+}
+
+func validateAttributes(attrs []entity.Attribute, values map[string]interface{}) (map[string]interface{}, []string) {
+	var validationErrors []string
+	registered := make(map[string]bool, len(attrs))
+	checked := make(map[string]interface{}, len(values))
+	for _, attr := range attrs {
+		registered[attr.Name] = true
+		value, ok := values[attr.Name]
+		if !ok || value == nil {
+			if attr.Required {
+				validationErrors = append(validationErrors, fmt.Sprintf("attributes.%s: non zero value required", attr.Name))
+			}
+			continue
+		}
+		if err := validateAttribute(attr, value); err != nil {
+			validationErrors = append(validationErrors, fmt.Sprintf("attributes.%s: %s", attr.Name, err))
+			continue
+		}
+		checked[attr.Name] = value
+	}
+	var unknown []string
+	for name := range values {
+		if !registered[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		validationErrors = append(validationErrors, fmt.Sprintf("attributes.%s: unknown attribute", name))
+	}
+	return checked, validationErrors
+}
+
+func validateAttribute(attr entity.Attribute, value interface{}) error {
+	rules := attr.Rules
+	switch attr.Type {
+	case entity.AttributeTypeString:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("must be a string")
+		}
+		length := utf8.RuneCountInString(s)
+		if rules.MinLength != nil && length < *rules.MinLength {
+			return fmt.Errorf("must be at least %d characters", *rules.MinLength)
+		}
+		if rules.MaxLength != nil && length > *rules.MaxLength {
+			return fmt.Errorf("must be at most %d characters", *rules.MaxLength)
+		}
+		if rules.Pattern != "" {
+			re, err := attributePattern(rules.Pattern)
+			if err != nil || !re.MatchString(s) {
+				return fmt.Errorf("does not match %s", rules.Pattern)
+			}
+		}
+		if len(rules.Enum) != 0 && !isOneOf(s, rules.Enum) {
+			return fmt.Errorf("must be one of %s", strings.Join(rules.Enum, ", "))
+		}
+	case entity.AttributeTypeInteger, entity.AttributeTypeNumber:
+		n, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("must be a number")
+		}
+		if attr.Type == entity.AttributeTypeInteger && n != math.Trunc(n) {
+			return fmt.Errorf("must be an integer")
+		}
+		if rules.Min != nil && n < *rules.Min {
+			return fmt.Errorf("must be at least %v", *rules.Min)
+		}
+		if rules.Max != nil && n > *rules.Max {
+			return fmt.Errorf("must be at most %v", *rules.Max)
+		}
+	case entity.AttributeTypeBoolean:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("must be true or false")
+		}
+	case entity.AttributeTypeDate:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("must be a date like 2006-01-02")
+		}
+		if _, err := time.Parse(time.DateOnly, s); err != nil {
+			return fmt.Errorf("must be a date like 2006-01-02")
+		}
+	}
+	return nil
+}
+
+// compiledPatterns caches the compiled patterns of the attributes by their
+// text, so a pattern is compiled once and not on every write.
+var compiledPatterns sync.Map
+
+func attributePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := compiledPatterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	compiledPatterns.Store(pattern, re)
+	return re, nil
+}
+
+func isOneOf(s string, values []string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveFilter checks the attributes the filter refers to against the
+// registry and converts the filter values to the attribute types.
+func (au *AppUseCase) resolveFilter(ctx context.Context, filter filters.Filter) (filters.Filter, error) {
+	sortName, sortByAttribute := strings.CutPrefix(filter.AttributesToSort, filters.AttributePrefix)
+	if len(filter.Attributes) == 0 && !sortByAttribute {
+		return filter, nil
+	}
+	attrs, err := au.s.ListAttributesStorage(ctx)
+	if err != nil {
+		return filter, fmt.Errorf("storage error: %s", err)
+	}
+	types := make(map[string]string, len(attrs))
+	for _, attr := range attrs {
+		types[attr.Name] = attr.Type
+	}
+
+	resolved := make([]filters.AttributeFilter, 0, len(filter.Attributes))
+	for _, attrFilter := range filter.Attributes {
+		attrType, ok := types[attrFilter.Name]
+		if !ok {
+			return filter, &FilterError{Err: fmt.Errorf("unknown attribute %s", attrFilter.Name)}
+		}
+		attrFilter.Value, err = parseAttributeValue(attrType, attrFilter.Raw)
+		if err != nil {
+			return filter, &FilterError{Err: fmt.Errorf("attribute %s: %s", attrFilter.Name, err)}
+		}
+		resolved = append(resolved, attrFilter)
+	}
+	filter.Attributes = resolved
+
+	if sortByAttribute {
+		attrType, ok := types[sortName]
+		if !ok {
+			return filter, &FilterError{Err: fmt.Errorf("unknown attribute %s", sortName)}
+		}
+		filter.SortAttributeType = attrType
+	}
+	return filter, nil
+}
+
+func parseAttributeValue(attrType, raw string) (interface{}, error) {
+	switch attrType {
+	case entity.AttributeTypeInteger:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be an integer")
+		}
+		return n, nil
+	case entity.AttributeTypeNumber:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, fmt.Errorf("must be a number")
+		}
+		return n, nil
+	case entity.AttributeTypeBoolean:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("must be true or false")
+		}
+		return b, nil
+	case entity.AttributeTypeDate:
+		if _, err := time.Parse(time.DateOnly, raw); err != nil {
+			return nil, fmt.Errorf("must be a date like 2006-01-02")
+		}
+	}
+	return raw, nil
+}
@@ -0,0 +1,164 @@
+package usecase
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+func intPtr(n int) *int { return &n }
+
+func floatPtr(n float64) *float64 { return &n }
+
+var testAttributes = []entity.Attribute{
+	{Name: "code", Type: entity.AttributeTypeString, Rules: entity.AttributeRules{MinLength: intPtr(2), MaxLength: intPtr(4), Pattern: `^[A-Z]+$`}},
+	{Name: "team", Type: entity.AttributeTypeString, Required: true, Rules: entity.AttributeRules{Enum: []string{"core", "ops"}}},
+	{Name: "level", Type: entity.AttributeTypeInteger, Rules: entity.AttributeRules{Min: floatPtr(1), Max: floatPtr(10)}},
+	{Name: "score", Type: entity.AttributeTypeNumber},
+	{Name: "remote", Type: entity.AttributeTypeBoolean},
+	{Name: "hired_on", Type: entity.AttributeTypeDate},
+}
+
+func TestValidateAttributes(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]interface{}
+		checked map[string]interface{}
+		errors  []string
+	}{
+		{
+			name: "valid values",
+			values: map[string]interface{}{
+				"code": "AB", "team": "core", "level": float64(3), "score": 4.5, "remote": true, "hired_on": "2024-02-29",
+			},
+			checked: map[string]interface{}{
+				"code": "AB", "team": "core", "level": float64(3), "score": 4.5, "remote": true, "hired_on": "2024-02-29",
+			},
+		},
+		{
+			name:    "null values are dropped",
+			values:  map[string]interface{}{"team": "ops", "code": nil},
+			checked: map[string]interface{}{"team": "ops"},
+		},
+		{
+			name:    "required attribute missing",
+			values:  map[string]interface{}{"level": float64(2)},
+			checked: map[string]interface{}{"level": float64(2)},
+			errors:  []string{"attributes.team: non zero value required"},
+		},
+		{
+			name:    "required attribute is null",
+			values:  map[string]interface{}{"team": nil},
+			checked: map[string]interface{}{},
+			errors:  []string{"attributes.team: non zero value required"},
+		},
+		{
+			name:    "string rules",
+			values:  map[string]interface{}{"team": "dev", "code": "abc"},
+			checked: map[string]interface{}{},
+			errors:  []string{"attributes.code: does not match ^[A-Z]+$", "attributes.team: must be one of core, ops"},
+		},
+		{
+			name:    "length counts characters",
+			values:  map[string]interface{}{"team": "core", "code": "ABCDE"},
+			checked: map[string]interface{}{"team": "core"},
+			errors:  []string{"attributes.code: must be at most 4 characters"},
+		},
+		{
+			name:    "number rules",
+			values:  map[string]interface{}{"team": "core", "level": 2.5, "score": "high"},
+			checked: map[string]interface{}{"team": "core"},
+			errors:  []string{"attributes.level: must be an integer", "attributes.score: must be a number"},
+		},
+		{
+			name:    "number out of range",
+			values:  map[string]interface{}{"team": "core", "level": float64(11)},
+			checked: map[string]interface{}{"team": "core"},
+			errors:  []string{"attributes.level: must be at most 10"},
+		},
+		{
+			name:    "boolean and date",
+			values:  map[string]interface{}{"team": "core", "remote": "yes", "hired_on": "2023-02-29"},
+			checked: map[string]interface{}{"team": "core"},
+			errors:  []string{"attributes.remote: must be true or false", "attributes.hired_on: must be a date like 2006-01-02"},
+		},
+		{
+			name:    "unknown attributes are sorted",
+			values:  map[string]interface{}{"team": "core", "zeta": 1, "alpha": 2},
+			checked: map[string]interface{}{"team": "core"},
+			errors:  []string{"attributes.alpha: unknown attribute", "attributes.zeta: unknown attribute"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checked, validationErrors := validateAttributes(testAttributes, tt.values)
+			if !reflect.DeepEqual(checked, tt.checked) {
+				t.Errorf("checked = %v, want %v", checked, tt.checked)
+			}
+			if !reflect.DeepEqual(validationErrors, tt.errors) {
+				t.Errorf("errors = %q, want %q", validationErrors, tt.errors)
+			}
+		})
+	}
+}
+
+func TestImportAttributes(t *testing.T) {
+	tests := []struct {
+		name       string
+		values     map[string]string
+		attributes map[string]interface{}
+		errors     []string
+	}{
+		{
+			name:       "values are converted",
+			values:     map[string]string{"attr.team": "ops", "attr.level": "3", "attr.score": "0.5", "attr.remote": "true"},
+			attributes: map[string]interface{}{"team": "ops", "level": float64(3), "score": 0.5, "remote": true},
+		},
+		{
+			name:       "empty values are not set",
+			values:     map[string]string{"attr.team": "core", "attr.code": ""},
+			attributes: map[string]interface{}{"team": "core"},
+		},
+		{
+			name:       "required attribute missing",
+			values:     map[string]string{"name": "Ivan"},
+			attributes: map[string]interface{}{},
+			errors:     []string{"attributes.team: non zero value required"},
+		},
+		{
+			name:       "bad values fail validation",
+			values:     map[string]string{"attr.team": "core", "attr.level": "three", "attr.remote": "maybe"},
+			attributes: map[string]interface{}{"team": "core"},
+			errors:     []string{"attributes.level: must be a number", "attributes.remote: must be true or false"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attributes, validationErrors := importAttributes(testAttributes, tt.values)
+			if !reflect.DeepEqual(attributes, tt.attributes) {
+				t.Errorf("attributes = %v, want %v", attributes, tt.attributes)
+			}
+			if !reflect.DeepEqual(validationErrors, tt.errors) {
+				t.Errorf("errors = %q, want %q", validationErrors, tt.errors)
+			}
+		})
+	}
+}
+
+func TestAttributePatternIsCached(t *testing.T) {
+	first, err := attributePattern(`^[a-z]+$`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	second, err := attributePattern(`^[a-z]+$`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if first != second {
+		t.Error("the pattern was compiled twice")
+	}
+	if _, err = attributePattern(`([a-z]`); err == nil {
+		t.Error("a bad pattern compiled")
+	}
+}
@@ -0,0 +1,147 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/storage"
+	"github.com/ivanov-nikolay/user-api/internal/tracing"
+)
+
+type DuplicateError struct {
+	IDs []int64
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("likely duplicate of users %v", e.IDs)
+}
+
+func (au *AppUseCase) findDuplicates(ctx context.Context, user entity.User) ([]int64, error) {
+	candidates, err := au.s.GetDuplicateCandidatesStorage(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	var IDs []int64
+	for _, candidate := range candidates {
+		if isLikelyDuplicate(user, candidate) {
+			IDs = append(IDs, candidate.ID)
+		}
+	}
+	return IDs, nil
+}
+
+// FindDuplicateClustersUseCase groups users that are likely duplicates of
+// each other. Likeness is transitive here: if a matches b and b matches c,
+// all three end up in one cluster. Clusters are built from a page of at
+// most limit candidate pairs after the pair after, a cluster can continue
+// on the next page. The returned ids start the next page, they are nil on
+// the last one.
+func (au *AppUseCase) FindDuplicateClustersUseCase(ctx context.Context, after [2]int64, limit int) ([][]entity.User, *[2]int64, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "AppUseCase.FindDuplicateClustersUseCase")
+	defer span.End()
+
+	pairs, err := au.s.GetDuplicateCandidatePairsStorage(ctx, after, limit)
+	if errors.Is(err, storage.ErrQueryTimeout) {
+		return nil, nil, ErrQueryTimeout
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("storage error: %s", err)
+	}
+	var next *[2]int64
+	if len(pairs) == limit {
+		last := pairs[len(pairs)-1]
+		next = &[2]int64{last[0].ID, last[1].ID}
+	}
+
+	parent := map[int64]int64{}
+	users := map[int64]entity.User{}
+	var find func(ID int64) int64
+	find = func(ID int64) int64 {
+		if parent[ID] != ID {
+			parent[ID] = find(parent[ID])
+		}
+		return parent[ID]
+	}
+	for _, pair := range pairs {
+		if !isLikelyDuplicate(pair[0], pair[1]) {
+			continue
+		}
+		for _, user := range pair {
+			if _, ok := parent[user.ID]; !ok {
+				parent[user.ID] = user.ID
+				users[user.ID] = user
+			}
+		}
+		parent[find(pair[1].ID)] = find(pair[0].ID)
+	}
+
+	byRoot := map[int64][]entity.User{}
+	for ID, user := range users {
+		root := find(ID)
+		byRoot[root] = append(byRoot[root], user)
+	}
+	clusters := make([][]entity.User, 0, len(byRoot))
+	for _, cluster := range byRoot {
+		sort.Slice(cluster, func(i, j int) bool { return cluster[i].ID < cluster[j].ID })
+		clusters = append(clusters, cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i][0].ID < clusters[j][0].ID })
+	return clusters, next, nil
+}
+
+// isLikelyDuplicate treats two users as the same person when their
+// birthdays don't contradict each other and every name part is equal or
+// differs by a typo. A missing patronymic matches any patronymic.
+func isLikelyDuplicate(a, b entity.User) bool {
+	if !a.Birthday.IsZero() && !b.Birthday.IsZero() {
+		ay, am, ad := a.Birthday.Date()
+		by, bm, bd := b.Birthday.Date()
+		if ay != by || am != bm || ad != bd {
+			return false
+		}
+	}
+	if !namesMatch(a.Surname, b.Surname) || !namesMatch(a.Name, b.Name) {
+		return false
+	}
+	if a.Patronymic == "" || b.Patronymic == "" {
+		return true
+	}
+	return namesMatch(a.Patronymic, b.Patronymic)
+}
+
+func namesMatch(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if a == b {
+		return true
+	}
+	allowed := 1
+	if len([]rune(a)) > 6 {
+		allowed = 2
+	}
+	return levenshtein(a, b) <= allowed
+}
+
+func levenshtein(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	curr := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		curr[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(br)]
+}
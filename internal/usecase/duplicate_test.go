@@ -0,0 +1,146 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"ivan", "ivan", 0},
+		{"ivan", "ivn", 1},
+		{"ivan", "iwan", 1},
+		{"ivan", "ivana", 1},
+		{"petrov", "pterov", 2},
+		{"", "abc", 3},
+		{"иванов", "иваноф", 1},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.distance {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.distance)
+		}
+	}
+}
+
+func TestIsLikelyDuplicate(t *testing.T) {
+	birthday := time.Date(1990, 5, 1, 0, 0, 0, 0, time.UTC)
+	ivan := entity.User{Name: "Ivan", Surname: "Petrov", Patronymic: "Sergeevich", Birthday: birthday}
+	tests := []struct {
+		name  string
+		other entity.User
+		match bool
+	}{
+		{"same", ivan, true},
+		{"other case", entity.User{Name: "IVAN", Surname: "petrov", Patronymic: "sergeevich", Birthday: birthday}, true},
+		{"typo in name", entity.User{Name: "Ivn", Surname: "Petrov", Birthday: birthday}, true},
+		{"two typos in short name", entity.User{Name: "Iwn", Surname: "Petrov", Birthday: birthday}, false},
+		{"two typos in long patronymic", entity.User{Name: "Ivan", Surname: "Petrov", Patronymic: "Sergevic", Birthday: birthday}, true},
+		{"other birthday", entity.User{Name: "Ivan", Surname: "Petrov", Birthday: birthday.AddDate(0, 0, 1)}, false},
+		{"same day at another time", entity.User{Name: "Ivan", Surname: "Petrov", Birthday: birthday.Add(10 * time.Hour)}, true},
+		{"unknown birthday", entity.User{Name: "Ivan", Surname: "Petrov"}, true},
+		{"other surname", entity.User{Name: "Ivan", Surname: "Sidorov", Birthday: birthday}, false},
+		{"other patronymic", entity.User{Name: "Ivan", Surname: "Petrov", Patronymic: "Olegovich", Birthday: birthday}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isLikelyDuplicate(ivan, tt.other); got != tt.match {
+				t.Errorf("isLikelyDuplicate() = %v, want %v", got, tt.match)
+			}
+			if got := isLikelyDuplicate(tt.other, ivan); got != tt.match {
+				t.Errorf("isLikelyDuplicate() reversed = %v, want %v", got, tt.match)
+			}
+		})
+	}
+}
+
+func TestCreateUserUseCaseDuplicate(t *testing.T) {
+	s := newMemoryStorage(
+		entity.User{ID: 1, Name: "Ivan", Surname: "Petrov", Status: entity.UserStatusActive},
+		entity.User{ID: 2, Name: "Ivan", Surname: "Petrov", Status: entity.UserStatusDeleted},
+	)
+	au := New(s)
+	user := entity.User{Name: "Ivn", Surname: "Petrov", Gender: "male", Status: entity.UserStatusActive}
+
+	_, err := au.CreateUserUseCase(context.Background(), user, false)
+	var duplicateErr *DuplicateError
+	if !errors.As(err, &duplicateErr) || !reflect.DeepEqual(duplicateErr.IDs, []int64{1}) {
+		t.Fatalf("CreateUserUseCase() error = %v, want duplicate of [1]", err)
+	}
+	created, err := au.CreateUserUseCase(context.Background(), user, true)
+	if err != nil || created.ID != 3 {
+		t.Errorf("forced CreateUserUseCase() = %+v, %v, want user 3", created, err)
+	}
+}
+
+// pairStorage returns the candidate pairs after the given pair.
+type pairStorage struct {
+	*memoryStorage
+	pairs [][2]entity.User
+}
+
+func (s *pairStorage) GetDuplicateCandidatePairsStorage(ctx context.Context, after [2]int64, limit int) ([][2]entity.User, error) {
+	var page [][2]entity.User
+	for _, pair := range s.pairs {
+		if pair[0].ID > after[0] || pair[0].ID == after[0] && pair[1].ID > after[1] {
+			page = append(page, pair)
+		}
+	}
+	if len(page) > limit {
+		page = page[:limit]
+	}
+	return page, nil
+}
+
+func TestFindDuplicateClustersUseCase(t *testing.T) {
+	user := func(ID int64, name string) entity.User {
+		return entity.User{ID: ID, Name: name, Surname: "Petrov"}
+	}
+	s := &pairStorage{memoryStorage: newMemoryStorage(), pairs: [][2]entity.User{
+		{user(1, "Ivan"), user(2, "Ivn")},
+		{user(1, "Ivan"), user(5, "Oleg")},
+		{user(2, "Ivn"), user(3, "Ivan")},
+		{user(4, "Anna"), user(6, "Anya")},
+		{user(7, "Olga"), user(8, "Olga")},
+	}}
+	au := New(s)
+
+	clusters, next, err := au.FindDuplicateClustersUseCase(context.Background(), [2]int64{}, 4)
+	if err != nil {
+		t.Fatalf("FindDuplicateClustersUseCase() error: %v", err)
+	}
+	var IDs [][]int64
+	for _, cluster := range clusters {
+		var clusterIDs []int64
+		for _, user := range cluster {
+			clusterIDs = append(clusterIDs, user.ID)
+		}
+		IDs = append(IDs, clusterIDs)
+	}
+	if want := [][]int64{{1, 2, 3}, {4, 6}}; !reflect.DeepEqual(IDs, want) {
+		t.Errorf("clusters = %v, want %v", IDs, want)
+	}
+	if next == nil || *next != [2]int64{4, 6} {
+		t.Fatalf("next = %v, want [4 6]", next)
+	}
+
+	clusters, next, err = au.FindDuplicateClustersUseCase(context.Background(), *next, 4)
+	if err != nil || len(clusters) != 1 || next != nil {
+		t.Errorf("last page = %d clusters, next %v, error %v, want 1 cluster and no next page", len(clusters), next, err)
+	}
+}
+
+func TestFindDuplicateClustersUseCaseTimeout(t *testing.T) {
+	_, _, err := New(timeoutStorage{newMemoryStorage()}).FindDuplicateClustersUseCase(context.Background(), [2]int64{}, 10)
+	if !errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("error = %v, want ErrQueryTimeout", err)
+	}
+}
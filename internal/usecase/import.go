@@ -0,0 +1,311 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/dto"
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/importfile"
+	"github.com/ivanov-nikolay/user-api/internal/jobs"
+	"github.com/ivanov-nikolay/user-api/internal/storage"
+	"github.com/ivanov-nikolay/user-api/internal/tracing"
+)
+
+// importChunkRows is how many rows are written in one transaction. The
+// progress of a job moves after every chunk.
+const importChunkRows = 1000
+
+type ImportUseCase interface {
+	StartImportUseCase(ctx context.Context, params dto.UserImport, payload []byte, createdBy string) (*entity.Job, error)
+}
+
+// ImportFileError means the uploaded file can't be imported at all, for
+// example because a required column is missing.
+type ImportFileError struct {
+	Err error
+}
+
+func (e *ImportFileError) Error() string {
+	return e.Err.Error()
+}
+
+type ImportAppUseCase struct {
+	s     storage.Storage
+	queue *jobs.Queue
+}
+
+func NewImportUseCase(s storage.Storage, queue *jobs.Queue) *ImportAppUseCase {
+	return &ImportAppUseCase{s: s, queue: queue}
+}
+
+// StartImportUseCase checks the header of the file and puts the file into
+// the job queue, ImportUsersJob does the import. Imported users skip the
+// duplicate check of POST /user, it would take a query per row.
+func (iu *ImportAppUseCase) StartImportUseCase(ctx context.Context, params dto.UserImport, payload []byte, createdBy string) (*entity.Job, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ImportAppUseCase.StartImportUseCase")
+	defer span.End()
+
+	attrs, err := iu.s.ListAttributesStorage(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %s", err)
+	}
+	if unknown := unknownMappedAttributes(params, attrs); len(unknown) > 0 {
+		return nil, &ImportFileError{Err: fmt.Errorf("mapping refers to unknown attributes %s", strings.Join(unknown, ", "))}
+	}
+	reader, err := importfile.NewReader(params.Format, bytes.NewReader(payload), params.Sources(attributeNames(attrs)))
+	if err != nil {
+		return nil, &ImportFileError{Err: err}
+	}
+	if missing := requiredMissing(params, reader.Missing()); len(missing) > 0 {
+		return nil, &ImportFileError{Err: fmt.Errorf("columns for %s are missing in the header", strings.Join(missing, ", "))}
+	}
+
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("params encoding error: %s", err)
+	}
+	job, err := iu.queue.Enqueue(ctx, entity.Job{
+		Kind:      entity.JobKindImportUsers,
+		Params:    paramsJSON,
+		CreatedBy: createdBy,
+	}, payload)
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %s", err)
+	}
+	return job, nil
+}
+
+// requiredMissing returns the missing fields that every row needs or that
+// were mapped explicitly, a typo in the mapping shouldn't import empty
+// values silently.
+func requiredMissing(params dto.UserImport, missing []string) []string {
+	var result []string
+	for _, field := range missing {
+		_, mapped := params.Mapping[field]
+		required := false
+		for _, f := range dto.RequiredImportFields {
+			required = required || f == field
+		}
+		if mapped || required {
+			result = append(result, field)
+		}
+	}
+	return result
+}
+
+// ImportUsersJob is the job handler of imports. It reads the file twice:
+// first to count the rows for the progress, then to import them. Rows are
+// numbered from 1, the CSV header is not counted. A chunk is saved together
+// with the progress, so a retried job skips the rows that were already
+// processed instead of importing them twice.
+func (iu *ImportAppUseCase) ImportUsersJob(ctx context.Context, job entity.Job) error {
+	ctx, span := tracing.Tracer().Start(ctx, "ImportAppUseCase.ImportUsersJob")
+	defer span.End()
+
+	var params dto.UserImport
+	if err := json.Unmarshal(job.Params, &params); err != nil {
+		return jobs.Permanent(fmt.Errorf("params decoding error: %s", err))
+	}
+	payload, err := iu.s.GetJobPayloadStorage(ctx, job.ID)
+	if err != nil {
+		return fmt.Errorf("storage error: %s", err)
+	}
+
+	attrs, err := iu.s.ListAttributesStorage(ctx)
+	if err != nil {
+		return fmt.Errorf("storage error: %s", err)
+	}
+	sources := params.Sources(attributeNames(attrs))
+
+	total, err := countRows(params, sources, payload)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	if job.Progress.Total != total {
+		if err = iu.s.SetJobTotalStorage(ctx, job.ID, total); err != nil {
+			return fmt.Errorf("storage error: %s", err)
+		}
+	}
+
+	reader, err := importfile.NewReader(params.Format, bytes.NewReader(payload), sources)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	var (
+		progress  = job.Progress
+		users     []entity.User
+		userRows  []int
+		rowErrors []entity.JobRowError
+		joinDate  = time.Now()
+	)
+	flush := func() error {
+		if params.DryRun {
+			users, userRows = nil, nil
+		}
+		for {
+			err := iu.s.SaveImportChunkStorage(ctx, job.ID, users, rowErrors, progress)
+			var conflictErr *storage.ImportConflictError
+			if errors.As(err, &conflictErr) {
+				// The rows with taken values fail like invalid ones, the
+				// rest of the chunk is saved again without them.
+				users, userRows, rowErrors = dropConflicts(users, userRows, rowErrors, conflictErr.Conflicts)
+				progress.Succeeded -= len(conflictErr.Conflicts)
+				progress.Failed += len(conflictErr.Conflicts)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("storage error: %s", err)
+			}
+			break
+		}
+		users, userRows, rowErrors = users[:0], userRows[:0], rowErrors[:0]
+		return nil
+	}
+	progress.Total = total
+	for row := 1; ; row++ {
+		values, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if row <= job.Progress.Processed {
+			continue
+		}
+		var rowErr *importfile.RowError
+		switch {
+		case errors.As(err, &rowErr):
+			rowErrors = append(rowErrors, entity.JobRowError{Row: row, Errors: []string{rowErr.Error()}})
+			progress.Failed++
+		case err != nil:
+			return jobs.Permanent(fmt.Errorf("reading row %d: %s", row, err))
+		default:
+			user, validationErrors := dto.UserCreateFromImport(values)
+			attributes, attributeErrors := importAttributes(attrs, values)
+			validationErrors = append(validationErrors, attributeErrors...)
+			if len(validationErrors) > 0 {
+				rowErrors = append(rowErrors, entity.JobRowError{Row: row, Errors: validationErrors})
+				progress.Failed++
+				break
+			}
+			converted := user.ConvertToUser()
+			converted.Attributes = attributes
+			converted.JoinDate = joinDate
+			converted.StatusChangedAt = joinDate
+			users = append(users, converted)
+			userRows = append(userRows, row)
+			progress.Succeeded++
+		}
+		progress.Processed++
+		if progress.Processed%importChunkRows == 0 {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if progress.Processed%importChunkRows != 0 || progress.Processed == 0 {
+		return flush()
+	}
+	return nil
+}
+
+// dropConflicts moves the users with taken unique values, given by their
+// index in users, from the chunk to the row errors. rows are the row
+// numbers of users.
+func dropConflicts(users []entity.User, rows []int, rowErrors []entity.JobRowError, conflicts map[int]*storage.UniqueAttributeError) ([]entity.User, []int, []entity.JobRowError) {
+	keptUsers := make([]entity.User, 0, len(users))
+	keptRows := make([]int, 0, len(rows))
+	for i := range users {
+		if err, ok := conflicts[i]; ok {
+			rowErrors = append(rowErrors, entity.JobRowError{Row: rows[i], Errors: []string{fmt.Sprintf("attributes.%s: value is already taken", err.Name)}})
+			continue
+		}
+		keptUsers = append(keptUsers, users[i])
+		keptRows = append(keptRows, rows[i])
+	}
+	return keptUsers, keptRows, rowErrors
+}
+
+func attributeNames(attrs []entity.Attribute) []string {
+	names := make([]string, 0, len(attrs))
+	for _, attr := range attrs {
+		names = append(names, attr.Name)
+	}
+	return names
+}
+
+// unknownMappedAttributes returns the attribute fields of the mapping that
+// are not registered.
+func unknownMappedAttributes(params dto.UserImport, attrs []entity.Attribute) []string {
+	registered := make(map[string]bool, len(attrs))
+	for _, attr := range attrs {
+		registered[attr.Name] = true
+	}
+	var unknown []string
+	for field := range params.Mapping {
+		name, ok := strings.CutPrefix(field, dto.ImportAttributePrefix)
+		if ok && !registered[name] {
+			unknown = append(unknown, field)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// importAttributes reads the attributes of a row and validates them like
+// the attributes of POST /user. An empty value means the attribute is not
+// set. Values are converted to the JSON types of the attributes, a value
+// that doesn't convert is left as text and fails the validation.
+func importAttributes(attrs []entity.Attribute, values map[string]string) (map[string]interface{}, []string) {
+	attributes := make(map[string]interface{}, len(attrs))
+	for _, attr := range attrs {
+		raw := values[dto.ImportAttributePrefix+attr.Name]
+		if raw == "" {
+			continue
+		}
+		attributes[attr.Name] = importAttributeValue(attr.Type, raw)
+	}
+	return validateAttributes(attrs, attributes)
+}
+
+func importAttributeValue(attrType, raw string) interface{} {
+	switch attrType {
+	case entity.AttributeTypeInteger, entity.AttributeTypeNumber:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err == nil && !math.IsNaN(n) && !math.IsInf(n, 0) {
+			return n
+		}
+	case entity.AttributeTypeBoolean:
+		if b, err := strconv.ParseBool(raw); err == nil {
+			return b
+		}
+	}
+	return raw
+}
+
+func countRows(params dto.UserImport, sources map[string]string, payload []byte) (int, error) {
+	reader, err := importfile.NewReader(params.Format, bytes.NewReader(payload), sources)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for {
+		_, err = reader.Next()
+		if errors.Is(err, io.EOF) {
+			return total, nil
+		}
+		var rowErr *importfile.RowError
+		if err != nil && !errors.As(err, &rowErr) {
+			return 0, fmt.Errorf("reading row %d: %s", total+1, err)
+		}
+		total++
+	}
+}
@@ -0,0 +1,105 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ivanov-nikolay/user-api/internal/dto"
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/storage"
+)
+
+// importStorage saves import chunks like the postgres storage, with one
+// unique attribute: email.
+type importStorage struct {
+	*memoryStorage
+	payload   []byte
+	taken     map[interface{}]bool
+	saved     []entity.User
+	rowErrors []entity.JobRowError
+	progress  entity.JobProgress
+}
+
+func (s *importStorage) ListAttributesStorage(ctx context.Context) ([]entity.Attribute, error) {
+	return []entity.Attribute{{Name: "email", Type: entity.AttributeTypeString, Unique: true}}, nil
+}
+
+func (s *importStorage) GetJobPayloadStorage(ctx context.Context, ID int64) ([]byte, error) {
+	return s.payload, nil
+}
+
+func (s *importStorage) SetJobTotalStorage(ctx context.Context, ID int64, total int) error {
+	s.progress.Total = total
+	return nil
+}
+
+func (s *importStorage) SaveImportChunkStorage(ctx context.Context, jobID int64, users []entity.User, rowErrors []entity.JobRowError, progress entity.JobProgress) error {
+	conflicts := make(map[int]*storage.UniqueAttributeError)
+	chunk := make(map[interface{}]bool)
+	for i, user := range users {
+		email, ok := user.Attributes["email"]
+		if ok && (s.taken[email] || chunk[email]) {
+			conflicts[i] = &storage.UniqueAttributeError{Name: "email"}
+		}
+		chunk[email] = ok
+	}
+	if len(conflicts) > 0 {
+		return &storage.ImportConflictError{Conflicts: conflicts}
+	}
+	for email, ok := range chunk {
+		s.taken[email] = ok
+	}
+	s.saved = append(s.saved, users...)
+	s.rowErrors = append(s.rowErrors, rowErrors...)
+	s.progress = progress
+	return nil
+}
+
+func TestImportUsersJob(t *testing.T) {
+	s := &importStorage{
+		memoryStorage: newMemoryStorage(),
+		payload: []byte("name,surname,gender,status,attr.email\n" +
+			"Ivan,Petrov,male,active,ivan@example.com\n" +
+			"Olga,Petrova,female,active,taken@example.com\n" +
+			"Anna,Smirnova,female,active,anna@example.com\n" +
+			"Anna,Smirnova,female,active,anna@example.com\n" +
+			"Oleg,Popov,male,banned,oleg@example.com\n" +
+			"Pavel,Orlov,male,active,\n"),
+		taken: map[interface{}]bool{"taken@example.com": true},
+	}
+	params, err := json.Marshal(dto.UserImport{Format: "csv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = NewImportUseCase(s, nil).ImportUsersJob(context.Background(), entity.Job{ID: 1, Params: params})
+	if err != nil {
+		t.Fatalf("ImportUsersJob() error: %v", err)
+	}
+
+	var names []string
+	for _, user := range s.saved {
+		names = append(names, user.Name)
+	}
+	if want := []string{"Ivan", "Anna", "Pavel"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("saved users = %v, want %v", names, want)
+	}
+	sort.Slice(s.rowErrors, func(i, j int) bool { return s.rowErrors[i].Row < s.rowErrors[j].Row })
+	var rows []int
+	for _, rowError := range s.rowErrors {
+		rows = append(rows, rowError.Row)
+	}
+	if want := []int{2, 4, 5}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("failed rows = %v, want %v", rows, want)
+	}
+	if want := "attributes.email: value is already taken"; s.rowErrors[0].Errors[0] != want {
+		t.Errorf("row 2 error = %q, want %q", s.rowErrors[0].Errors[0], want)
+	}
+	want := entity.JobProgress{Total: 6, Processed: 6, Succeeded: 3, Failed: 3}
+	if s.progress != want {
+		t.Errorf("progress = %+v, want %+v", s.progress, want)
+	}
+}
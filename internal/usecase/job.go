@@ -0,0 +1,123 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/jobs"
+	"github.com/ivanov-nikolay/user-api/internal/storage"
+	"github.com/ivanov-nikolay/user-api/internal/tracing"
+)
+
+type JobUseCase interface {
+	GetJobUseCase(ctx context.Context, ID int64) (*entity.Job, error)
+	ListJobsUseCase(ctx context.Context, status, kind string, limit int) ([]entity.Job, error)
+	CancelJobUseCase(ctx context.Context, ID int64) (*entity.Job, error)
+	RetryJobUseCase(ctx context.Context, ID int64) (*entity.Job, error)
+	ExportJobErrorsUseCase(ctx context.Context, ID int64, fn func(rowError entity.JobRowError) error) (bool, error)
+}
+
+var (
+	ErrJobFinished     = errors.New("job is already finished")
+	ErrJobNotRetryable = errors.New("only failed, dead and cancelled jobs can be retried")
+)
+
+type JobAppUseCase struct {
+	s     storage.Storage
+	queue *jobs.Queue
+}
+
+func NewJobUseCase(s storage.Storage, queue *jobs.Queue) *JobAppUseCase {
+	return &JobAppUseCase{s: s, queue: queue}
+}
+
+func (ju *JobAppUseCase) GetJobUseCase(ctx context.Context, ID int64) (*entity.Job, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "JobAppUseCase.GetJobUseCase")
+	defer span.End()
+
+	job, err := ju.s.GetJobStorage(ctx, ID)
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %s", err)
+	}
+	return job, nil
+}
+
+func (ju *JobAppUseCase) ListJobsUseCase(ctx context.Context, status, kind string, limit int) ([]entity.Job, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "JobAppUseCase.ListJobsUseCase")
+	defer span.End()
+
+	jobList, err := ju.s.ListJobsStorage(ctx, status, kind, limit)
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %s", err)
+	}
+	return jobList, nil
+}
+
+// CancelJobUseCase cancels a pending job at once. A running job is only
+// marked, its worker stops it within a third of the lease. nil is returned
+// if the job doesn't exist and ErrJobFinished if it can't be cancelled.
+func (ju *JobAppUseCase) CancelJobUseCase(ctx context.Context, ID int64) (*entity.Job, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "JobAppUseCase.CancelJobUseCase")
+	defer span.End()
+
+	job, err := ju.s.CancelJobStorage(ctx, ID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %s", err)
+	}
+	if job != nil {
+		return job, nil
+	}
+	return nil, ju.missingOr(ctx, ID, ErrJobFinished)
+}
+
+// RetryJobUseCase puts a failed, dead or cancelled job back into the queue
+// with a fresh set of attempts.
+func (ju *JobAppUseCase) RetryJobUseCase(ctx context.Context, ID int64) (*entity.Job, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "JobAppUseCase.RetryJobUseCase")
+	defer span.End()
+
+	job, err := ju.s.RequeueJobStorage(ctx, ID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %s", err)
+	}
+	if job != nil {
+		ju.queue.Notify()
+		return job, nil
+	}
+	return nil, ju.missingOr(ctx, ID, ErrJobNotRetryable)
+}
+
+// missingOr returns nil if the job doesn't exist and err otherwise.
+func (ju *JobAppUseCase) missingOr(ctx context.Context, ID int64, err error) error {
+	job, getErr := ju.s.GetJobStorage(ctx, ID)
+	if getErr != nil {
+		return fmt.Errorf("storage error: %s", getErr)
+	}
+	if job == nil {
+		return nil
+	}
+	return err
+}
+
+// ExportJobErrorsUseCase calls fn for every failed row of the job in row
+// order. It reports false if the job doesn't exist.
+func (ju *JobAppUseCase) ExportJobErrorsUseCase(ctx context.Context, ID int64, fn func(rowError entity.JobRowError) error) (bool, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "JobAppUseCase.ExportJobErrorsUseCase")
+	defer span.End()
+
+	job, err := ju.s.GetJobStorage(ctx, ID)
+	if err != nil {
+		return false, fmt.Errorf("storage error: %s", err)
+	}
+	if job == nil {
+		return false, nil
+	}
+	err = ju.s.ExportJobRowErrorsStorage(ctx, ID, fn)
+	if err != nil {
+		return true, fmt.Errorf("storage error: %s", err)
+	}
+	return true, nil
+}
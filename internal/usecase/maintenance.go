@@ -0,0 +1,96 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/storage"
+	"github.com/ivanov-nikolay/user-api/internal/tracing"
+)
+
+const (
+	purgeBatchSize     = 500
+	expireBanBatchSize = 500
+)
+
+type MaintenanceUseCase interface {
+	PurgeDeletedUsersUseCase(ctx context.Context, retention time.Duration) (int64, error)
+	WarmCacheUseCase(ctx context.Context, limit int) (int, error)
+	ExpireBansUseCase(ctx context.Context) (int, error)
+}
+
+type MaintenanceAppUseCase struct {
+	s storage.Storage
+}
+
+func NewMaintenanceUseCase(s storage.Storage) *MaintenanceAppUseCase {
+	return &MaintenanceAppUseCase{s: s}
+}
+
+// PurgeDeletedUsersUseCase removes for good the users that have been in
+// status deleted for longer than retention.
+func (mu *MaintenanceAppUseCase) PurgeDeletedUsersUseCase(ctx context.Context, retention time.Duration) (int64, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "MaintenanceAppUseCase.PurgeDeletedUsersUseCase")
+	defer span.End()
+
+	purged, err := mu.s.PurgeDeletedUsersStorage(ctx, time.Now().Add(-retention), purgeBatchSize)
+	if err != nil {
+		return purged, fmt.Errorf("storage error: %s", err)
+	}
+	return purged, nil
+}
+
+// WarmCacheUseCase loads the most read users that dropped out of the cache.
+func (mu *MaintenanceAppUseCase) WarmCacheUseCase(ctx context.Context, limit int) (int, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "MaintenanceAppUseCase.WarmCacheUseCase")
+	defer span.End()
+
+	warmed, err := mu.s.WarmCacheStorage(ctx, limit)
+	if err != nil {
+		return warmed, fmt.Errorf("storage error: %s", err)
+	}
+	return warmed, nil
+}
+
+// ExpireBansUseCase returns the users whose temporary ban ended to active.
+// Every user goes through the same locked update as a manual change, so the
+// change gets the same history entry. A user unbanned or banned again after
+// the listing is left as is.
+func (mu *MaintenanceAppUseCase) ExpireBansUseCase(ctx context.Context) (int, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "MaintenanceAppUseCase.ExpireBansUseCase")
+	defer span.End()
+
+	expired := 0
+	for {
+		now := time.Now()
+		IDs, err := mu.s.ListExpiredBansStorage(ctx, now, expireBanBatchSize)
+		if err != nil {
+			return expired, fmt.Errorf("storage error: %s", err)
+		}
+		batchExpired := 0
+		for _, ID := range IDs {
+			changed := false
+			_, err = mu.s.UpdateUserStorage(ctx, ID, func(current entity.User) (entity.User, []byte, error) {
+				if current.Status != entity.UserStatusBanned || current.BannedUntil.IsZero() || current.BannedUntil.After(now) {
+					return current, nil, nil
+				}
+				changed = true
+				return changeStatus(current, entity.UserStatusActive, statusActionBanExpired, "", time.Time{}, now)
+			})
+			if err != nil {
+				return expired, fmt.Errorf("storage error: %s", err)
+			}
+			if changed {
+				batchExpired++
+			}
+		}
+		expired += batchExpired
+		// A batch that expires nothing would be listed again as is, so
+		// going on would never end.
+		if len(IDs) < expireBanBatchSize || batchExpired == 0 {
+			return expired, nil
+		}
+	}
+}
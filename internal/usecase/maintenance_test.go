@@ -0,0 +1,97 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+func TestExpireBansUseCase(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	s := newMemoryStorage(
+		entity.User{ID: 1, Status: entity.UserStatusBanned, BannedUntil: past, BanReason: "spam"},
+		entity.User{ID: 2, Status: entity.UserStatusBanned, BannedUntil: future, BanReason: "spam"},
+		entity.User{ID: 3, Status: entity.UserStatusBanned, BanReason: "spam"},
+		entity.User{ID: 4, Status: entity.UserStatusBanned, BannedUntil: past, MergedInto: 1},
+		entity.User{ID: 5, Status: entity.UserStatusActive},
+	)
+
+	expired, err := NewMaintenanceUseCase(s).ExpireBansUseCase(context.Background())
+	if err != nil {
+		t.Fatalf("ExpireBansUseCase() error: %v", err)
+	}
+	if expired != 1 {
+		t.Errorf("ExpireBansUseCase() = %d, want 1", expired)
+	}
+	if user := s.users[1]; user.Status != entity.UserStatusActive || !user.BannedUntil.IsZero() || user.BanReason != "" {
+		t.Errorf("user 1 = %+v, want active without ban", user)
+	}
+	for _, ID := range []int64{2, 3, 4} {
+		if s.users[ID].Status != entity.UserStatusBanned {
+			t.Errorf("user %d status = %q, want banned", ID, s.users[ID].Status)
+		}
+	}
+}
+
+// staleBansStorage lists merged users as expired, like a listing the updates
+// can't catch up with.
+type staleBansStorage struct {
+	*memoryStorage
+	listed int
+}
+
+func (s *staleBansStorage) ListExpiredBansStorage(ctx context.Context, now time.Time, limit int) ([]int64, error) {
+	s.listed++
+	IDs := make([]int64, limit)
+	for i := range IDs {
+		IDs[i] = int64(i + 1)
+	}
+	return IDs, nil
+}
+
+func TestExpireBansUseCaseStopsWithoutProgress(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	s := &staleBansStorage{memoryStorage: newMemoryStorage()}
+	for ID := int64(1); ID <= expireBanBatchSize; ID++ {
+		s.users[ID] = entity.User{ID: ID, Status: entity.UserStatusBanned, BannedUntil: past, MergedInto: ID + 1}
+	}
+
+	expired, err := NewMaintenanceUseCase(s).ExpireBansUseCase(context.Background())
+	if err != nil {
+		t.Fatalf("ExpireBansUseCase() error: %v", err)
+	}
+	if expired != 0 || s.listed != 1 {
+		t.Errorf("ExpireBansUseCase() = %d after %d listings, want 0 after 1", expired, s.listed)
+	}
+}
+
+// purgeStorage records the arguments of the purge.
+type purgeStorage struct {
+	*memoryStorage
+	before    time.Time
+	batchSize int
+}
+
+func (s *purgeStorage) PurgeDeletedUsersStorage(ctx context.Context, before time.Time, batchSize int) (int64, error) {
+	s.before, s.batchSize = before, batchSize
+	return 3, nil
+}
+
+func TestPurgeDeletedUsersUseCase(t *testing.T) {
+	s := &purgeStorage{memoryStorage: newMemoryStorage()}
+	retention := 30 * 24 * time.Hour
+
+	purged, err := NewMaintenanceUseCase(s).PurgeDeletedUsersUseCase(context.Background(), retention)
+	if err != nil || purged != 3 {
+		t.Fatalf("PurgeDeletedUsersUseCase() = %d, %v, want 3", purged, err)
+	}
+	if age := time.Since(s.before); age < retention || age > retention+time.Minute {
+		t.Errorf("purged users deleted before %s, want %s ago", s.before, retention)
+	}
+	if s.batchSize != purgeBatchSize {
+		t.Errorf("batch size = %d, want %d", s.batchSize, purgeBatchSize)
+	}
+}
@@ -0,0 +1,116 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/dto"
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/tracing"
+)
+
+var ErrAlreadyMerged = errors.New("user was already merged into another user")
+
+type MergedError struct {
+	SurvivorID int64
+}
+
+func (e *MergedError) Error() string {
+	return fmt.Sprintf("user was merged into user %d", e.SurvivorID)
+}
+
+type mergeDetails struct {
+	SurvivorID int64             `json:"survivor_id"`
+	VictimID   int64             `json:"victim_id"`
+	Fields     map[string]string `json:"fields"`
+	Survivor   entity.User       `json:"survivor"`
+	Victim     entity.User       `json:"victim"`
+	// StatusChange is set when the survivor takes the status of the victim.
+	StatusChange json.RawMessage `json:"status_change,omitempty"`
+}
+
+// MergeUsersUseCase returns nil when either user doesn't exist and
+// ErrAlreadyMerged when either of them is already a merge victim. Taking
+// the status of the victim follows the status rules, *TransitionError is
+// returned if the survivor can't move to it.
+func (au *AppUseCase) MergeUsersUseCase(ctx context.Context, survivorID, victimID int64, fields map[string]string) (*entity.User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "AppUseCase.MergeUsersUseCase")
+	defer span.End()
+
+	now := time.Now()
+	merged, err := au.s.MergeUsersStorage(ctx, survivorID, victimID, func(survivor, victim entity.User) (entity.User, []byte, error) {
+		if survivor.MergedInto != 0 || victim.MergedInto != 0 {
+			return entity.User{}, nil, ErrAlreadyMerged
+		}
+		merged, statusChange, err := mergeFields(survivor, victim, fields, now)
+		if err != nil {
+			return entity.User{}, nil, err
+		}
+		details, err := json.Marshal(mergeDetails{
+			SurvivorID:   survivor.ID,
+			VictimID:     victim.ID,
+			Fields:       fields,
+			Survivor:     survivor,
+			Victim:       victim,
+			StatusChange: statusChange,
+		})
+		if err != nil {
+			return entity.User{}, nil, err
+		}
+		return merged, details, nil
+	})
+	if errors.Is(err, ErrAlreadyMerged) {
+		return nil, err
+	}
+	var transitionErr *TransitionError
+	if errors.As(err, &transitionErr) {
+		return nil, transitionErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %s", err)
+	}
+	return merged, nil
+}
+
+// mergeFields builds the surviving record. The status of the victim goes
+// through changeStatus, its details are returned when the status changes.
+// A survivor banned like the victim takes the reason and end of its ban.
+func mergeFields(survivor, victim entity.User, fields map[string]string, now time.Time) (entity.User, []byte, error) {
+	merged := survivor
+	var statusChange []byte
+	for field, from := range fields {
+		if from != dto.MergeFromVictim {
+			continue
+		}
+		switch field {
+		case "name":
+			merged.Name = victim.Name
+		case "surname":
+			merged.Surname = victim.Surname
+		case "patronymic":
+			merged.Patronymic = victim.Patronymic
+		case "gender":
+			merged.Gender = victim.Gender
+		case "status":
+			if merged.Status == victim.Status {
+				if victim.Status == entity.UserStatusBanned {
+					merged.BannedUntil, merged.BanReason = victim.BannedUntil, victim.BanReason
+				}
+				continue
+			}
+			var err error
+			merged, statusChange, err = changeStatus(merged, victim.Status, statusActionMerge, victim.BanReason, victim.BannedUntil, now)
+			if err != nil {
+				return entity.User{}, nil, err
+			}
+		case "birthday":
+			merged.Birthday = victim.Birthday
+		case "join_date":
+			merged.JoinDate = victim.JoinDate
+		}
+	}
+	return merged, statusChange, nil
+}
@@ -0,0 +1,81 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/dto"
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+func TestMergeFields(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	until := now.AddDate(0, 0, 7)
+	survivor := entity.User{ID: 1, Name: "Ivan", Surname: "Petrov", Gender: "male", Status: entity.UserStatusActive}
+	victim := entity.User{ID: 2, Name: "Ivn", Surname: "Petrova", Patronymic: "Olegovich", Gender: "female",
+		Status: entity.UserStatusBanned, BannedUntil: until, BanReason: "spam"}
+
+	merged, statusChange, err := mergeFields(survivor, victim, map[string]string{
+		"name":       dto.MergeFromSurvivor,
+		"surname":    dto.MergeFromVictim,
+		"patronymic": dto.MergeFromVictim,
+	}, now)
+	if err != nil || statusChange != nil {
+		t.Fatalf("mergeFields() = %s, %v", statusChange, err)
+	}
+	if merged.Name != "Ivan" || merged.Surname != "Petrova" || merged.Patronymic != "Olegovich" || merged.Gender != "male" {
+		t.Errorf("mergeFields() = %+v", merged)
+	}
+
+	merged, statusChange, err = mergeFields(survivor, victim, map[string]string{"status": dto.MergeFromVictim}, now)
+	if err != nil || statusChange == nil {
+		t.Fatalf("status from victim = %s, %v, want a status change", statusChange, err)
+	}
+	if merged.Status != entity.UserStatusBanned || !merged.BannedUntil.Equal(until) || merged.BanReason != "spam" || !merged.StatusChangedAt.Equal(now) {
+		t.Errorf("status from victim = %+v, want the ban of the victim", merged)
+	}
+
+	banned := survivor
+	banned.Status, banned.BanReason = entity.UserStatusBanned, "fraud"
+	merged, statusChange, err = mergeFields(banned, victim, map[string]string{"status": dto.MergeFromVictim}, now)
+	if err != nil || statusChange != nil {
+		t.Fatalf("same status = %s, %v, want no status change", statusChange, err)
+	}
+	if merged.BanReason != "spam" || !merged.BannedUntil.Equal(until) {
+		t.Errorf("same status = %+v, want the ban of the victim", merged)
+	}
+
+	deleted := survivor
+	deleted.Status = entity.UserStatusDeleted
+	_, _, err = mergeFields(deleted, victim, map[string]string{"status": dto.MergeFromVictim}, now)
+	var transitionErr *TransitionError
+	if !errors.As(err, &transitionErr) {
+		t.Errorf("deleted to banned error = %v, want *TransitionError", err)
+	}
+}
+
+func TestMergeUsersUseCase(t *testing.T) {
+	s := newMemoryStorage(
+		entity.User{ID: 1, Name: "Ivan", Surname: "Petrov", Status: entity.UserStatusActive},
+		entity.User{ID: 2, Name: "Ivan", Surname: "Petrow", Status: entity.UserStatusActive},
+		entity.User{ID: 3, Name: "Ivan", Surname: "Petrof", Status: entity.UserStatusActive},
+	)
+	au := New(s)
+	ctx := context.Background()
+
+	merged, err := au.MergeUsersUseCase(ctx, 1, 2, map[string]string{"surname": dto.MergeFromVictim})
+	if err != nil || merged == nil || merged.Surname != "Petrow" {
+		t.Fatalf("MergeUsersUseCase() = %+v, %v", merged, err)
+	}
+	if s.users[2].MergedInto != 1 {
+		t.Errorf("victim merged into %d, want 1", s.users[2].MergedInto)
+	}
+	if _, err = au.MergeUsersUseCase(ctx, 3, 2, nil); !errors.Is(err, ErrAlreadyMerged) {
+		t.Errorf("merging a victim again: error = %v, want ErrAlreadyMerged", err)
+	}
+	if merged, err = au.MergeUsersUseCase(ctx, 1, 9, nil); merged != nil || err != nil {
+		t.Errorf("unknown victim = %+v, %v, want nil", merged, err)
+	}
+}
@@ -0,0 +1,148 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/tracing"
+)
+
+const (
+	StatusActionBan      = "ban"
+	StatusActionUnban    = "unban"
+	StatusActionActivate = "activate"
+	// statusActionUpdate is a status change sent through PUT /user.
+	statusActionUpdate = "update"
+	// statusActionBanExpired is the end of a temporary ban.
+	statusActionBanExpired = "ban_expired"
+	// statusActionMerge is the status of the victim taken by a merge.
+	statusActionMerge = "merge"
+)
+
+// statusTransitions lists the statuses a user may move to from each status.
+var statusTransitions = map[string][]string{
+	entity.UserStatusActive:  {entity.UserStatusBanned, entity.UserStatusDeleted},
+	entity.UserStatusBanned:  {entity.UserStatusActive, entity.UserStatusDeleted},
+	entity.UserStatusDeleted: {entity.UserStatusActive},
+}
+
+// statusActions are the transitions made by the status endpoints, an action
+// moves the user from the first status to the second one.
+var statusActions = map[string][2]string{
+	StatusActionBan:      {entity.UserStatusActive, entity.UserStatusBanned},
+	StatusActionUnban:    {entity.UserStatusBanned, entity.UserStatusActive},
+	StatusActionActivate: {entity.UserStatusDeleted, entity.UserStatusActive},
+}
+
+var (
+	ErrReasonRequired = errors.New("reason is required to ban a user")
+	ErrBadBanEnd      = errors.New("only a ban can have an end and it must be in the future")
+)
+
+// TransitionError means the user can't move from its current status to the
+// requested one.
+type TransitionError struct {
+	From string
+	To   string
+	Hint string
+}
+
+func (e *TransitionError) Error() string {
+	if e.From == e.To {
+		return fmt.Sprintf("user is already %s", e.From)
+	}
+	text := fmt.Sprintf("status can't change from %s to %s", e.From, e.To)
+	if e.Hint != "" {
+		text += ", " + e.Hint
+	}
+	return text
+}
+
+type statusDetails struct {
+	From        string     `json:"from"`
+	To          string     `json:"to"`
+	Action      string     `json:"action"`
+	Reason      string     `json:"reason,omitempty"`
+	BannedUntil *time.Time `json:"banned_until,omitempty"`
+}
+
+func canTransition(from, to string) bool {
+	for _, status := range statusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// changeStatus moves user to the status and returns the history details of
+// the change. A user keeping its status is returned as is with nil details.
+// Banning keeps the reason and the end of the ban, until is zero for a
+// permanent ban. Any other status clears them.
+func changeStatus(user entity.User, status, action, reason string, until, now time.Time) (entity.User, []byte, error) {
+	if user.Status == status {
+		return user, nil, nil
+	}
+	if !canTransition(user.Status, status) {
+		return entity.User{}, nil, &TransitionError{From: user.Status, To: status}
+	}
+	changeDetails := statusDetails{
+		From:   user.Status,
+		To:     status,
+		Action: action,
+		Reason: reason,
+	}
+	user.BannedUntil, user.BanReason = time.Time{}, ""
+	if status == entity.UserStatusBanned {
+		user.BannedUntil, user.BanReason = until, reason
+		if !until.IsZero() {
+			changeDetails.BannedUntil = &until
+		}
+	}
+	details, err := json.Marshal(changeDetails)
+	if err != nil {
+		return entity.User{}, nil, err
+	}
+	user.Status = status
+	user.StatusChangedAt = now
+	return user, details, nil
+}
+
+// ChangeStatusUseCase applies one of the status actions. It returns nil if
+// the user doesn't exist and *TransitionError if the user is not in the
+// status the action starts from. until is the end of a ban, zero bans the
+// user until it is unbanned.
+func (au *AppUseCase) ChangeStatusUseCase(ctx context.Context, ID int64, action, reason string, until time.Time) (*entity.User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "AppUseCase.ChangeStatusUseCase")
+	defer span.End()
+
+	transition, ok := statusActions[action]
+	if !ok {
+		return nil, fmt.Errorf("unknown status action %q", action)
+	}
+	if action == StatusActionBan && reason == "" {
+		return nil, ErrReasonRequired
+	}
+	now := time.Now()
+	if !until.IsZero() && (action != StatusActionBan || !until.After(now)) {
+		return nil, ErrBadBanEnd
+	}
+	user, err := au.s.UpdateUserStorage(ctx, ID, func(current entity.User) (entity.User, []byte, error) {
+		if current.Status != transition[0] {
+			return entity.User{}, nil, &TransitionError{From: current.Status, To: transition[1]}
+		}
+		return changeStatus(current, transition[1], action, reason, until, now)
+	})
+	var transitionErr *TransitionError
+	if errors.As(err, &transitionErr) {
+		return nil, transitionErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %s", err)
+	}
+	return user, nil
+}
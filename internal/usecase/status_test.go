@@ -0,0 +1,167 @@
+package usecase
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from   string
+		to     string
+		result bool
+	}{
+		{entity.UserStatusActive, entity.UserStatusBanned, true},
+		{entity.UserStatusActive, entity.UserStatusDeleted, true},
+		{entity.UserStatusBanned, entity.UserStatusActive, true},
+		{entity.UserStatusBanned, entity.UserStatusDeleted, true},
+		{entity.UserStatusDeleted, entity.UserStatusActive, true},
+		{entity.UserStatusDeleted, entity.UserStatusBanned, false},
+		{entity.UserStatusActive, entity.UserStatusActive, false},
+		{entity.UserStatusActive, "unknown", false},
+		{"unknown", entity.UserStatusActive, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			if got := canTransition(tt.from, tt.to); got != tt.result {
+				t.Errorf("canTransition(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.result)
+			}
+		})
+	}
+}
+
+func TestChangeStatus(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	until := now.Add(7 * 24 * time.Hour)
+	changedAt := now.Add(-time.Hour)
+	active := entity.User{ID: 1, Status: entity.UserStatusActive, StatusChangedAt: changedAt}
+	banned := entity.User{ID: 1, Status: entity.UserStatusBanned, StatusChangedAt: changedAt, BannedUntil: until, BanReason: "spam"}
+	deleted := entity.User{ID: 1, Status: entity.UserStatusDeleted, StatusChangedAt: changedAt}
+
+	tests := []struct {
+		name    string
+		user    entity.User
+		status  string
+		action  string
+		reason  string
+		until   time.Time
+		want    entity.User
+		details *statusDetails
+		err     *TransitionError
+	}{
+		{
+			name:   "temporary ban",
+			user:   active,
+			status: entity.UserStatusBanned,
+			action: StatusActionBan,
+			reason: "spam",
+			until:  until,
+			want:   entity.User{ID: 1, Status: entity.UserStatusBanned, StatusChangedAt: now, BannedUntil: until, BanReason: "spam"},
+			details: &statusDetails{
+				From: entity.UserStatusActive, To: entity.UserStatusBanned, Action: StatusActionBan, Reason: "spam", BannedUntil: &until,
+			},
+		},
+		{
+			name:    "permanent ban",
+			user:    active,
+			status:  entity.UserStatusBanned,
+			action:  StatusActionBan,
+			reason:  "fraud",
+			want:    entity.User{ID: 1, Status: entity.UserStatusBanned, StatusChangedAt: now, BanReason: "fraud"},
+			details: &statusDetails{From: entity.UserStatusActive, To: entity.UserStatusBanned, Action: StatusActionBan, Reason: "fraud"},
+		},
+		{
+			name:    "unban clears the ban",
+			user:    banned,
+			status:  entity.UserStatusActive,
+			action:  StatusActionUnban,
+			want:    entity.User{ID: 1, Status: entity.UserStatusActive, StatusChangedAt: now},
+			details: &statusDetails{From: entity.UserStatusBanned, To: entity.UserStatusActive, Action: StatusActionUnban},
+		},
+		{
+			name:    "expired ban",
+			user:    banned,
+			status:  entity.UserStatusActive,
+			action:  statusActionBanExpired,
+			want:    entity.User{ID: 1, Status: entity.UserStatusActive, StatusChangedAt: now},
+			details: &statusDetails{From: entity.UserStatusBanned, To: entity.UserStatusActive, Action: statusActionBanExpired},
+		},
+		{
+			name:    "activate",
+			user:    deleted,
+			status:  entity.UserStatusActive,
+			action:  StatusActionActivate,
+			want:    entity.User{ID: 1, Status: entity.UserStatusActive, StatusChangedAt: now},
+			details: &statusDetails{From: entity.UserStatusDeleted, To: entity.UserStatusActive, Action: StatusActionActivate},
+		},
+		{
+			name:   "same status keeps the user",
+			user:   banned,
+			status: entity.UserStatusBanned,
+			action: statusActionUpdate,
+			want:   banned,
+		},
+		{
+			name:   "forbidden transition",
+			user:   deleted,
+			status: entity.UserStatusBanned,
+			action: statusActionUpdate,
+			err:    &TransitionError{From: entity.UserStatusDeleted, To: entity.UserStatusBanned},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, details, err := changeStatus(tt.user, tt.status, tt.action, tt.reason, tt.until, now)
+			if tt.err != nil {
+				var transitionErr *TransitionError
+				if !errors.As(err, &transitionErr) || *transitionErr != *tt.err {
+					t.Fatalf("error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("user = %+v, want %+v", got, tt.want)
+			}
+			if tt.details == nil {
+				if details != nil {
+					t.Errorf("details = %s, want nil", details)
+				}
+				return
+			}
+			want, err := json.Marshal(tt.details)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(details) != string(want) {
+				t.Errorf("details = %s, want %s", details, want)
+			}
+		})
+	}
+}
+
+func TestTransitionErrorMessage(t *testing.T) {
+	tests := []struct {
+		err  TransitionError
+		text string
+	}{
+		{TransitionError{From: "banned", To: "banned"}, "user is already banned"},
+		{TransitionError{From: "deleted", To: "banned"}, "status can't change from deleted to banned"},
+		{TransitionError{From: "active", To: "active", Hint: "ignored"}, "user is already active"},
+		{TransitionError{From: "deleted", To: "banned", Hint: "activate the user first"}, "status can't change from deleted to banned, activate the user first"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.text {
+				t.Errorf("Error() = %q, want %q", got, tt.text)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package usecase
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/filters"
+	"github.com/ivanov-nikolay/user-api/internal/storage"
+)
+
+// memoryStorage keeps users in a map. Methods a test doesn't override panic
+// through the nil embedded Storage.
+type memoryStorage struct {
+	storage.Storage
+	users map[int64]entity.User
+	attrs []entity.Attribute
+}
+
+func newMemoryStorage(users ...entity.User) *memoryStorage {
+	ms := &memoryStorage{users: map[int64]entity.User{}}
+	for _, user := range users {
+		ms.users[user.ID] = user
+	}
+	return ms
+}
+
+func (ms *memoryStorage) CreateUserStorage(ctx context.Context, user entity.User) (int64, error) {
+	for ID := range ms.users {
+		user.ID = max(user.ID, ID)
+	}
+	user.ID++
+	ms.users[user.ID] = user
+	return user.ID, nil
+}
+
+func (ms *memoryStorage) ListAttributesStorage(ctx context.Context) ([]entity.Attribute, error) {
+	return ms.attrs, nil
+}
+
+// GetDuplicateCandidatesStorage returns every other user that is neither
+// deleted nor merged, the usecase does the matching.
+func (ms *memoryStorage) GetDuplicateCandidatesStorage(ctx context.Context, user entity.User) ([]entity.User, error) {
+	var candidates []entity.User
+	for _, ID := range ms.sortedIDs() {
+		candidate := ms.users[ID]
+		if ID != user.ID && candidate.Status != entity.UserStatusDeleted && candidate.MergedInto == 0 {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates, nil
+}
+
+func (ms *memoryStorage) sortedIDs() []int64 {
+	IDs := make([]int64, 0, len(ms.users))
+	for ID := range ms.users {
+		IDs = append(IDs, ID)
+	}
+	sort.Slice(IDs, func(i, j int) bool { return IDs[i] < IDs[j] })
+	return IDs
+}
+
+// UpdateUserStorage behaves like the postgres storage: merged users are
+// left as is.
+func (ms *memoryStorage) UpdateUserStorage(ctx context.Context, ID int64, update storage.UpdateFunc) (*entity.User, error) {
+	current, ok := ms.users[ID]
+	if !ok || current.MergedInto != 0 {
+		return nil, nil
+	}
+	updated, _, err := update(current)
+	if err != nil {
+		return nil, err
+	}
+	ms.users[ID] = updated
+	return &updated, nil
+}
+
+// MergeUsersStorage points the victim at the survivor, users merged into
+// the victim are not followed.
+func (ms *memoryStorage) MergeUsersStorage(ctx context.Context, survivorID, victimID int64, merge storage.MergeFunc) (*entity.User, error) {
+	survivor, ok := ms.users[survivorID]
+	victim, found := ms.users[victimID]
+	if !ok || !found {
+		return nil, nil
+	}
+	merged, _, err := merge(survivor, victim)
+	if err != nil {
+		return nil, err
+	}
+	victim.MergedInto = survivorID
+	ms.users[survivorID], ms.users[victimID] = merged, victim
+	return &merged, nil
+}
+
+func (ms *memoryStorage) ListExpiredBansStorage(ctx context.Context, now time.Time, limit int) ([]int64, error) {
+	var IDs []int64
+	for _, ID := range ms.sortedIDs() {
+		user := ms.users[ID]
+		if user.Status == entity.UserStatusBanned && !user.BannedUntil.IsZero() && !user.BannedUntil.After(now) && user.MergedInto == 0 {
+			IDs = append(IDs, ID)
+		}
+	}
+	if len(IDs) > limit {
+		IDs = IDs[:limit]
+	}
+	return IDs, nil
+}
+
+// timeoutStorage fails the reads that run with a statement timeout like
+// Postgres does when the timeout runs out.
+type timeoutStorage struct {
+	*memoryStorage
+}
+
+func (timeoutStorage) SearchUsersStorage(ctx context.Context, filter filters.Filter) ([]entity.User, error) {
+	return nil, storage.ErrQueryTimeout
+}
+
+func (timeoutStorage) ExportUsersStorage(ctx context.Context, filter filters.Filter, fn func(user entity.User) error) error {
+	return storage.ErrQueryTimeout
+}
+
+func (timeoutStorage) GetDuplicateCandidatePairsStorage(ctx context.Context, after [2]int64, limit int) ([][2]entity.User, error) {
+	return nil, storage.ErrQueryTimeout
+}
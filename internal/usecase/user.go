@@ -1,22 +1,33 @@
 package usecase
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/ivanov-nikolay/user-api/internal/entity"
 	"github.com/ivanov-nikolay/user-api/internal/filters"
 	"github.com/ivanov-nikolay/user-api/internal/storage"
+	"github.com/ivanov-nikolay/user-api/internal/tracing"
 )
 
 type UserUseCase interface {
-	CreateUserUseCase(user entity.User) (*entity.User, error)
-	DeleteUserUseCase(ID int64) (bool, error)
-	UpdateUserUseCase(user entity.User) (*entity.User, error)
-	GetUserByIDUseCase(ID int64) (*entity.User, error)
-	SearchUsersUseCase(filters filters.Filter) ([]entity.User, error)
+	CreateUserUseCase(ctx context.Context, user entity.User, force bool) (*entity.User, error)
+	DeleteUserUseCase(ctx context.Context, ID int64) (bool, error)
+	UpdateUserUseCase(ctx context.Context, user entity.User, force bool) (*entity.User, error)
+	GetUserByIDUseCase(ctx context.Context, ID int64) (*entity.User, error)
+	SearchUsersUseCase(ctx context.Context, filters filters.Filter) ([]entity.User, error)
+	ExportUsersUseCase(ctx context.Context, filters filters.Filter, fn func(user entity.User) error) error
+	FindDuplicateClustersUseCase(ctx context.Context, after [2]int64, limit int) ([][]entity.User, *[2]int64, error)
+	MergeUsersUseCase(ctx context.Context, survivorID, victimID int64, fields map[string]string) (*entity.User, error)
+	ChangeStatusUseCase(ctx context.Context, ID int64, action, reason string, until time.Time) (*entity.User, error)
 }
 
+// ErrQueryTimeout means the query was stopped by its time budget, usually
+// because the filters match too much of the table.
+var ErrQueryTimeout = errors.New("query took too long, narrow the filters")
+
 type AppUseCase struct {
 	s storage.Storage
 }
@@ -25,49 +36,153 @@ func New(s storage.Storage) *AppUseCase {
 	return &AppUseCase{s: s}
 }
 
-func (au *AppUseCase) CreateUserUseCase(user entity.User) (*entity.User, error) {
+// CreateUserUseCase returns *DuplicateError when the user looks like one
+// that already exists, unless force is set, and *AttributeError when the
+// attributes don't match the registry.
+func (au *AppUseCase) CreateUserUseCase(ctx context.Context, user entity.User, force bool) (*entity.User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "AppUseCase.CreateUserUseCase")
+	defer span.End()
+
+	var err error
+	user.Attributes, err = au.checkAttributes(ctx, user.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	if !force {
+		IDs, err := au.findDuplicates(ctx, user)
+		if err != nil {
+			return nil, fmt.Errorf("storage error: %s", err)
+		}
+		if len(IDs) != 0 {
+			return nil, &DuplicateError{IDs: IDs}
+		}
+	}
 	user.JoinDate = time.Now()
-	ID, err := au.s.CreateUserStorage(user)
+	user.StatusChangedAt = user.JoinDate
+	ID, err := au.s.CreateUserStorage(ctx, user)
 	if err != nil {
-		return nil, fmt.Errorf("storage error: %s", err)
+		return nil, uniqueAttributeOr(err)
 	}
 	user.ID = ID
 	return &user, nil
 }
 
-func (au *AppUseCase) DeleteUserUseCase(ID int64) (bool, error) {
-	isDeleted, err := au.s.DeleteUserStorage(ID)
+func (au *AppUseCase) DeleteUserUseCase(ctx context.Context, ID int64) (bool, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "AppUseCase.DeleteUserUseCase")
+	defer span.End()
+
+	isDeleted, err := au.s.DeleteUserStorage(ctx, ID)
 	if err != nil {
 		return false, fmt.Errorf("storage error: %s", err)
 	}
 	return isDeleted, nil
 }
 
-func (au *AppUseCase) UpdateUserUseCase(user entity.User) (*entity.User, error) {
-	wasUpdated, err := au.s.UpdateUserStorage(user)
-	if err != nil {
-		return nil, fmt.Errorf("storage error: %s", err)
+// UpdateUserUseCase returns *DuplicateError and *AttributeError like
+// CreateUserUseCase and *TransitionError when the status can't change to
+// the sent one. Banning is rejected here, a ban needs a reason and goes
+// through ChangeStatusUseCase. A user sent without attributes keeps the
+// current ones.
+func (au *AppUseCase) UpdateUserUseCase(ctx context.Context, user entity.User, force bool) (*entity.User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "AppUseCase.UpdateUserUseCase")
+	defer span.End()
+
+	keepAttributes := user.Attributes == nil
+	if !keepAttributes {
+		var err error
+		user.Attributes, err = au.checkAttributes(ctx, user.Attributes)
+		if err != nil {
+			return nil, err
+		}
 	}
-	if !wasUpdated {
-		return nil, nil
+
+	if !force {
+		IDs, err := au.findDuplicates(ctx, user)
+		if err != nil {
+			return nil, fmt.Errorf("storage error: %s", err)
+		}
+		if len(IDs) != 0 {
+			return nil, &DuplicateError{IDs: IDs}
+		}
 	}
-	return &user, nil
+	updated, err := au.s.UpdateUserStorage(ctx, user.ID, func(current entity.User) (entity.User, []byte, error) {
+		if user.Status == entity.UserStatusBanned && current.Status != entity.UserStatusBanned {
+			transitionErr := &TransitionError{From: current.Status, To: user.Status}
+			if canTransition(current.Status, user.Status) {
+				transitionErr.Hint = fmt.Sprintf("use POST /user/%d/ban, it needs a reason", user.ID)
+			}
+			return entity.User{}, nil, transitionErr
+		}
+		changed, details, err := changeStatus(current, user.Status, statusActionUpdate, "", time.Time{}, time.Now())
+		if err != nil {
+			return entity.User{}, nil, err
+		}
+		user.JoinDate = changed.JoinDate
+		user.StatusChangedAt = changed.StatusChangedAt
+		user.BannedUntil = changed.BannedUntil
+		user.BanReason = changed.BanReason
+		if keepAttributes {
+			user.Attributes = changed.Attributes
+		}
+		return user, details, nil
+	})
+	var transitionErr *TransitionError
+	if errors.As(err, &transitionErr) {
+		return nil, transitionErr
+	}
+	if err != nil {
+		return nil, uniqueAttributeOr(err)
+	}
+	return updated, nil
 }
 
-func (au *AppUseCase) GetUserByIDUseCase(ID int64) (*entity.User, error) {
-	user, err := au.s.GetUserByIDStorage(ID)
+// GetUserByIDUseCase returns *MergedError for users merged into another.
+func (au *AppUseCase) GetUserByIDUseCase(ctx context.Context, ID int64) (*entity.User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "AppUseCase.GetUserByIDUseCase")
+	defer span.End()
+
+	user, err := au.s.GetUserByIDStorage(ctx, ID)
 	if err != nil {
 		return nil, fmt.Errorf("storage error: %s", err)
 	}
+	if user != nil && user.MergedInto != 0 {
+		return nil, &MergedError{SurvivorID: user.MergedInto}
+	}
 	return user, nil
 
 }
 
-func (au *AppUseCase) SearchUsersUseCase(filter filters.Filter) ([]entity.User, error) {
-	users, err := au.s.SearchUsersStorage(filter)
+func (au *AppUseCase) SearchUsersUseCase(ctx context.Context, filter filters.Filter) ([]entity.User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "AppUseCase.SearchUsersUseCase")
+	defer span.End()
+
+	filter, err := au.resolveFilter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	users, err := au.s.SearchUsersStorage(ctx, filter)
+	if errors.Is(err, storage.ErrQueryTimeout) {
+		return nil, ErrQueryTimeout
+	}
 	if err != nil {
 		return nil, fmt.Errorf("storage error: %s", err)
 	}
 	return users, nil
 
 }
+
+func (au *AppUseCase) ExportUsersUseCase(ctx context.Context, filter filters.Filter, fn func(user entity.User) error) error {
+	ctx, span := tracing.Tracer().Start(ctx, "AppUseCase.ExportUsersUseCase")
+	defer span.End()
+
+	filter, err := au.resolveFilter(ctx, filter)
+	if err != nil {
+		return err
+	}
+	err = au.s.ExportUsersStorage(ctx, filter, fn)
+	if errors.Is(err, storage.ErrQueryTimeout) {
+		return ErrQueryTimeout
+	}
+	return err
+}
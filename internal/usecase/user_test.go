@@ -0,0 +1,21 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ivanov-nikolay/user-api/internal/entity"
+	"github.com/ivanov-nikolay/user-api/internal/filters"
+)
+
+func TestSearchUsersUseCaseTimeout(t *testing.T) {
+	au := New(timeoutStorage{newMemoryStorage()})
+	if _, err := au.SearchUsersUseCase(context.Background(), filters.Filter{Limit: 50}); !errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("SearchUsersUseCase() error = %v, want ErrQueryTimeout", err)
+	}
+	err := au.ExportUsersUseCase(context.Background(), filters.Filter{}, func(user entity.User) error { return nil })
+	if !errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("ExportUsersUseCase() error = %v, want ErrQueryTimeout", err)
+	}
+}